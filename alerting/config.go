@@ -0,0 +1,142 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultInterval = 30 * time.Second
+
+// Config holds the alerting rules, where to send notifications and the
+// silences to start with
+type Config struct {
+	Interval       time.Duration // how often rules are evaluated
+	RepeatInterval time.Duration // resend firing alerts this often, 0 to notify once
+	Rules          []Rule
+	Webhooks       []*Webhook
+	Silences       []Silence
+}
+
+// alertsConfig is the layout of an alerting config file
+type alertsConfig struct {
+	Interval       string `json:"interval" yaml:"interval"`
+	RepeatInterval string `json:"repeat_interval" yaml:"repeat_interval"`
+	Rules          []struct {
+		Name     string `json:"name" yaml:"name"`
+		Expr     string `json:"expr" yaml:"expr"`
+		Window   string `json:"window" yaml:"window"`
+		Severity string `json:"severity" yaml:"severity"`
+	} `json:"rules" yaml:"rules"`
+	Webhooks []struct {
+		URL         string            `json:"url" yaml:"url"`
+		Headers     map[string]string `json:"headers" yaml:"headers"`
+		Timeout     string            `json:"timeout" yaml:"timeout"`
+		MaxAttempts int               `json:"max_attempts" yaml:"max_attempts"`
+	} `json:"webhooks" yaml:"webhooks"`
+	Silences []struct {
+		Rule     string `json:"rule" yaml:"rule"`
+		DeviceID string `json:"device_id" yaml:"device_id"`
+		StartsAt string `json:"starts_at" yaml:"starts_at"`
+		EndsAt   string `json:"ends_at" yaml:"ends_at"`
+		Comment  string `json:"comment" yaml:"comment"`
+	} `json:"silences" yaml:"silences"`
+}
+
+// LoadConfig reads an alerting config from a JSON or YAML file, chosen by
+// its extension
+func LoadConfig(path string) (Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read alerting config: %w", err)
+	}
+
+	var raw alertsConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	default:
+		return Config{}, fmt.Errorf("unsupported alerting config format %q: expected .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse alerting config: %w", err)
+	}
+
+	config := Config{Interval: defaultInterval}
+	if raw.Interval != "" {
+		if config.Interval, err = time.ParseDuration(raw.Interval); err != nil || config.Interval <= 0 {
+			return Config{}, fmt.Errorf("invalid interval %q", raw.Interval)
+		}
+	}
+	if raw.RepeatInterval != "" {
+		if config.RepeatInterval, err = time.ParseDuration(raw.RepeatInterval); err != nil || config.RepeatInterval < 0 {
+			return Config{}, fmt.Errorf("invalid repeat_interval %q", raw.RepeatInterval)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range raw.Rules {
+		name := entry.Name
+		if name == "" {
+			name = entry.Expr
+		}
+		if names[name] {
+			return Config{}, fmt.Errorf("rule %q is defined twice", name)
+		}
+		names[name] = true
+
+		rule, err := ParseRule(name, entry.Expr)
+		if err != nil {
+			return Config{}, err
+		}
+		if entry.Window != "" {
+			if rule.Window, err = time.ParseDuration(entry.Window); err != nil || rule.Window <= 0 {
+				return Config{}, fmt.Errorf("rule %q: invalid window %q", name, entry.Window)
+			}
+		}
+		rule.Severity = entry.Severity
+		config.Rules = append(config.Rules, rule)
+	}
+
+	for _, entry := range raw.Webhooks {
+		if entry.URL == "" {
+			return Config{}, fmt.Errorf("webhook without a url")
+		}
+		var timeout time.Duration
+		if entry.Timeout != "" {
+			if timeout, err = time.ParseDuration(entry.Timeout); err != nil {
+				return Config{}, fmt.Errorf("webhook %s: invalid timeout %q", entry.URL, entry.Timeout)
+			}
+		}
+		config.Webhooks = append(config.Webhooks, newWebhook(entry.URL, entry.Headers, timeout, entry.MaxAttempts))
+	}
+
+	for i, entry := range raw.Silences {
+		silence := Silence{
+			ID:       fmt.Sprintf("config-%d", i+1),
+			Rule:     entry.Rule,
+			DeviceID: entry.DeviceID,
+			Comment:  entry.Comment,
+		}
+		if entry.StartsAt != "" {
+			if silence.StartsAt, err = time.Parse(time.RFC3339, entry.StartsAt); err != nil {
+				return Config{}, fmt.Errorf("silence %d: invalid starts_at %q", i+1, entry.StartsAt)
+			}
+		}
+		if entry.EndsAt != "" {
+			if silence.EndsAt, err = time.Parse(time.RFC3339, entry.EndsAt); err != nil {
+				return Config{}, fmt.Errorf("silence %d: invalid ends_at %q", i+1, entry.EndsAt)
+			}
+		}
+		config.Silences = append(config.Silences, silence)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,444 @@
+package alerting
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// maxPendingNotifications bounds the notifications kept for a webhook that
+// keeps failing; the oldest are dropped first
+const maxPendingNotifications = 1000
+
+var (
+	// ErrSilenceNotFound is returned when removing a silence that does not exist
+	ErrSilenceNotFound = errors.New("silence not found")
+	// ErrInvalidSilence is returned when adding a silence that can never apply
+	ErrInvalidSilence = errors.New("invalid silence")
+)
+
+// Silence suppresses firing notifications of matching alerts while active
+type Silence struct {
+	ID       string
+	Rule     string    // empty matches every rule
+	DeviceID string    // empty matches every device
+	StartsAt time.Time // zero starts immediately
+	EndsAt   time.Time // zero never ends
+	Comment  string
+}
+
+// active reports whether the silence applies at now
+func (s Silence) active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && (s.EndsAt.IsZero() || now.Before(s.EndsAt))
+}
+
+// matches reports whether the silence applies to an alert at now
+func (s Silence) matches(rule, deviceID string, now time.Time) bool {
+	return (s.Rule == "" || s.Rule == rule) && (s.DeviceID == "" || s.DeviceID == deviceID) && s.active(now)
+}
+
+// Alert is a rule whose condition currently holds for a device
+type Alert struct {
+	Rule        string
+	DeviceID    string
+	State       string // "pending" until the condition held for the rule's For, then "firing"
+	Value       float64
+	ActiveSince time.Time // when the condition started to hold
+	StartsAt    time.Time // when the alert started firing, zero while pending
+	Silenced    bool
+}
+
+// Transition is a change in the state of an alert
+type Transition struct {
+	Rule     string
+	DeviceID string
+	From     string // empty for a new alert
+	To       string // "pending", "firing" or "resolved"
+	Value    float64
+	At       time.Time
+	Silenced bool
+}
+
+// alertKey identifies an alert
+type alertKey struct {
+	rule     string
+	deviceID string
+}
+
+// alertState tracks an alert between evaluations
+type alertState struct {
+	Alert
+	rule       Rule
+	notifiedAt time.Time // last firing notification, zero if none was sent
+}
+
+// Engine periodically evaluates alerting rules against every active device
+// and notifies webhooks of alerts that start firing or resolve. A firing
+// alert is only notified again after the repeat interval, and not at all
+// while silenced; an alert that was notified always gets its resolution.
+// Notifications a webhook fails to receive are sent again on the next run.
+type Engine struct {
+	store  storage.DeviceStore
+	config Config
+	now    func() time.Time
+
+	mu       sync.Mutex // guards alerts and silences
+	alerts   map[alertKey]*alertState
+	silences []Silence
+
+	deliveryMu sync.Mutex       // serializes deliveries and guards pending
+	pending    [][]Notification // per webhook, notifications not delivered yet
+
+	onTransition func(Transition) // called for each transition after evaluating
+
+	ctx      context.Context // cancelled on Stop to abort deliveries
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewEngine creates an engine for the rules and webhooks in config
+func NewEngine(store storage.DeviceStore, config Config) *Engine {
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Engine{
+		store:    store,
+		config:   config,
+		now:      time.Now,
+		alerts:   make(map[alertKey]*alertState),
+		silences: append([]Silence(nil), config.Silences...),
+		pending:  make([][]Notification, len(config.Webhooks)),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start evaluates the rules in a background goroutine
+func (e *Engine) Start() {
+	go e.run()
+}
+
+// OnTransition sets a function to call with each alert transition. It must
+// be set before Start.
+func (e *Engine) OnTransition(fn func(Transition)) {
+	e.onTransition = fn
+}
+
+// Stop stops the engine, aborting deliveries in flight, and waits for it
+// to exit
+func (e *Engine) Stop() {
+	e.stopOnce.Do(e.cancel)
+	<-e.done
+}
+
+// RunOnce evaluates the rules and delivers the resulting notifications to
+// every webhook, returning the delivery errors. A webhook that fails keeps
+// its notifications pending and receives them again, ahead of new ones, on
+// the next run.
+func (e *Engine) RunOnce(ctx context.Context) error {
+	notifications := e.Evaluate()
+
+	e.deliveryMu.Lock()
+	defer e.deliveryMu.Unlock()
+
+	errs := make([]error, len(e.config.Webhooks))
+	var wg sync.WaitGroup
+	for i, webhook := range e.config.Webhooks {
+		pending := append(e.pending[i], notifications...)
+		if dropped := len(pending) - maxPendingNotifications; dropped > 0 {
+			log.Printf("Dropping %d undelivered alert notifications for webhook %s", dropped, webhook.URL)
+			pending = pending[dropped:]
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = webhook.Send(ctx, pending); errs[i] != nil {
+				e.pending[i] = pending
+			} else {
+				e.pending[i] = nil
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Evaluate updates the state of every alert and returns the notifications
+// to send, sorted by rule and device
+func (e *Engine) Evaluate() []Notification {
+	notifications, transitions := e.evaluate()
+	if e.onTransition != nil {
+		for _, transition := range transitions {
+			e.onTransition(transition)
+		}
+	}
+	return notifications
+}
+
+// evaluate updates the state of every alert and returns the notifications
+// to send and the transitions, both sorted by rule and device
+func (e *Engine) evaluate() ([]Notification, []Transition) {
+	now := e.now()
+	devices := e.store.ListDevices()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var notifications []Notification
+	var transitions []Transition
+	seen := make(map[alertKey]bool)
+	for _, info := range devices {
+		if info.Retired() {
+			continue
+		}
+		lifetime, err := e.store.GetAggregates(info.DeviceID)
+		if err != nil {
+			continue // deregistered in the meantime
+		}
+		windows := make(map[time.Duration]storage.Aggregates)
+
+		for _, rule := range e.config.Rules {
+			// Rules without a window see the lifetime aggregates, including
+			// samples already pruned by retention
+			aggregates := lifetime
+			if rule.Window > 0 && rule.Metric != MetricHeartbeatAge {
+				if _, ok := windows[rule.Window]; !ok {
+					windowed, err := storage.WindowAggregates(e.store, info.DeviceID, storage.TimeWindow{From: now.Add(-rule.Window), To: now})
+					if err != nil {
+						continue // deregistered in the meantime
+					}
+					windows[rule.Window] = windowed
+				}
+				aggregates = windows[rule.Window]
+			}
+
+			key := alertKey{rule: rule.Name, deviceID: info.DeviceID}
+			value, ok := rule.value(aggregates, now)
+			if !ok || !rule.matches(value) {
+				continue
+			}
+			seen[key] = true
+
+			state, exists := e.alerts[key]
+			if !exists {
+				state = &alertState{
+					Alert: Alert{Rule: rule.Name, DeviceID: info.DeviceID, State: StatePending, ActiveSince: now},
+					rule:  rule,
+				}
+				e.alerts[key] = state
+			}
+			previous := ""
+			if exists {
+				previous = state.State
+			}
+			state.Value = value
+			if state.State == StatePending && now.Sub(state.ActiveSince) >= rule.For {
+				state.State = StateFiring
+				state.StartsAt = now
+			}
+
+			silenced := e.silencedLocked(key, now)
+			if state.State != previous {
+				transitions = append(transitions, state.transition(previous, state.State, now, silenced))
+			}
+			if state.State != StateFiring || silenced {
+				continue
+			}
+			if state.notifiedAt.IsZero() || (e.config.RepeatInterval > 0 && now.Sub(state.notifiedAt) >= e.config.RepeatInterval) {
+				state.notifiedAt = now
+				notifications = append(notifications, state.notification(StateFiring, time.Time{}))
+			}
+		}
+	}
+
+	// Alerts whose condition no longer holds, or whose device is gone, resolve
+	for key, state := range e.alerts {
+		if seen[key] {
+			continue
+		}
+		if !state.notifiedAt.IsZero() {
+			notifications = append(notifications, state.notification(StateResolved, now))
+		}
+		transitions = append(transitions, state.transition(state.State, StateResolved, now, e.silencedLocked(key, now)))
+		delete(e.alerts, key)
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		if notifications[i].Rule != notifications[j].Rule {
+			return notifications[i].Rule < notifications[j].Rule
+		}
+		return notifications[i].DeviceID < notifications[j].DeviceID
+	})
+	sort.Slice(transitions, func(i, j int) bool {
+		if transitions[i].Rule != transitions[j].Rule {
+			return transitions[i].Rule < transitions[j].Rule
+		}
+		return transitions[i].DeviceID < transitions[j].DeviceID
+	})
+	return notifications, transitions
+}
+
+// notification describes the alert for webhooks
+func (s *alertState) notification(status string, resolvedAt time.Time) Notification {
+	notification := Notification{
+		Rule:     s.Rule,
+		DeviceID: s.DeviceID,
+		Status:   status,
+		Expr:     s.rule.Expr,
+		Severity: s.rule.Severity,
+		Value:    s.Value,
+		StartsAt: s.StartsAt,
+	}
+	if !resolvedAt.IsZero() {
+		notification.ResolvedAt = &resolvedAt
+	}
+	return notification
+}
+
+// transition describes a change in the alert's state
+func (s *alertState) transition(from, to string, at time.Time, silenced bool) Transition {
+	return Transition{
+		Rule:     s.Rule,
+		DeviceID: s.DeviceID,
+		From:     from,
+		To:       to,
+		Value:    s.Value,
+		At:       at,
+		Silenced: silenced,
+	}
+}
+
+// Alerts returns the pending and firing alerts, sorted by rule and device
+func (e *Engine) Alerts() []Alert {
+	now := e.now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for key, state := range e.alerts {
+		alert := state.Alert
+		alert.Silenced = e.silencedLocked(key, now)
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].DeviceID < alerts[j].DeviceID
+	})
+	return alerts
+}
+
+// Silences returns the silences that have not ended yet
+func (e *Engine) Silences() []Silence {
+	now := e.now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	silences := make([]Silence, 0, len(e.silences))
+	for _, silence := range e.silences {
+		if silence.EndsAt.IsZero() || now.Before(silence.EndsAt) {
+			silences = append(silences, silence)
+		}
+	}
+	return silences
+}
+
+// AddSilence adds a silence and returns it with its generated ID. The
+// silence must name a known rule, if any, and end in the future.
+func (e *Engine) AddSilence(silence Silence) (Silence, error) {
+	if silence.Rule != "" && !e.hasRule(silence.Rule) {
+		return Silence{}, fmt.Errorf("%w: unknown rule %q", ErrInvalidSilence, silence.Rule)
+	}
+	now := e.now()
+	if !silence.EndsAt.IsZero() && (!silence.EndsAt.After(now) || !silence.EndsAt.After(silence.StartsAt)) {
+		return Silence{}, fmt.Errorf("%w: ends_at must be in the future and after starts_at", ErrInvalidSilence)
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	silence.ID = hex.EncodeToString(id)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Drop silences that have ended so they do not pile up
+	silences := e.silences[:0]
+	for _, existing := range e.silences {
+		if existing.EndsAt.IsZero() || now.Before(existing.EndsAt) {
+			silences = append(silences, existing)
+		}
+	}
+	e.silences = append(silences, silence)
+	return silence, nil
+}
+
+// hasRule reports whether a rule with the name is configured
+func (e *Engine) hasRule(name string) bool {
+	for _, rule := range e.config.Rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveSilence removes a silence by ID
+func (e *Engine) RemoveSilence(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, silence := range e.silences {
+		if silence.ID == id {
+			e.silences = append(e.silences[:i], e.silences[i+1:]...)
+			return nil
+		}
+	}
+	return ErrSilenceNotFound
+}
+
+// silencedLocked reports whether an alert is silenced at now; the caller
+// must hold e.mu
+func (e *Engine) silencedLocked(key alertKey, now time.Time) bool {
+	for _, silence := range e.silences {
+		if silence.matches(key.rule, key.deviceID, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.RunOnce(e.ctx); err != nil {
+				log.Printf("Failed to deliver alerts: %v", err)
+			}
+		}
+	}
+}
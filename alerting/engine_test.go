@@ -0,0 +1,307 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// webhookReceiver records the notifications POSTed to it
+type webhookReceiver struct {
+	mu       sync.Mutex
+	received []string // "<status> <rule> <device ID>"
+	failures int      // respond 500 to this many requests first
+	requests int
+	header   http.Header
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests++
+	r.header = req.Header.Clone()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var payload webhookPayload
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, alert := range payload.Alerts {
+		r.received = append(r.received, alert.Status+" "+alert.Rule+" "+alert.DeviceID)
+	}
+}
+
+// take returns and clears the recorded notifications
+func (r *webhookReceiver) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	received := r.received
+	r.received = nil
+	return received
+}
+
+// newTestWebhook creates a webhook to the server that retries quickly
+func newTestWebhook(url string, headers map[string]string) *Webhook {
+	webhook := newWebhook(url, headers, time.Second, 3)
+	webhook.backoff = time.Millisecond
+	return webhook
+}
+
+func TestEngine(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := baseTime
+
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+
+	stale, err := ParseRule("stale", "no heartbeat for 10m")
+	if err != nil {
+		t.Fatalf("ParseRule failed: %v", err)
+	}
+	slow, err := ParseRule("slow", "avg_upload_time > 1m for 5m")
+	if err != nil {
+		t.Fatalf("ParseRule failed: %v", err)
+	}
+	engine := NewEngine(store, Config{
+		RepeatInterval: time.Hour,
+		Rules:          []Rule{stale, slow},
+		Webhooks:       []*Webhook{newTestWebhook(server.URL, map[string]string{"X-Token": "secret"})},
+	})
+	engine.now = func() time.Time { return now }
+	var transitions []string
+	engine.OnTransition(func(transition Transition) {
+		transitions = append(transitions, transition.To+" "+transition.Rule+" "+transition.DeviceID)
+	})
+
+	store.AddHeartbeat("device-1", baseTime)
+	store.AddUploadTime("device-2", baseTime, int64(2*time.Minute))
+
+	steps := []struct {
+		name     string
+		advance  time.Duration
+		action   func()
+		expected []string
+	}{
+		{name: "Pending"},
+		{
+			name:     "Firing after for",
+			advance:  5 * time.Minute,
+			expected: []string{"firing slow device-2"},
+		},
+		{
+			name:     "Only new alerts are notified",
+			advance:  5 * time.Minute,
+			expected: []string{"firing stale device-1"},
+		},
+		{
+			name:    "Resolved",
+			advance: time.Minute,
+			action: func() {
+				store.AddHeartbeat("device-1", baseTime.Add(11*time.Minute))
+				if _, err := engine.AddSilence(Silence{Rule: "slow", EndsAt: baseTime.Add(30 * time.Minute)}); err != nil {
+					t.Fatalf("AddSilence failed: %v", err)
+				}
+			},
+			expected: []string{"resolved stale device-1"},
+		},
+		{
+			name:    "Repeat silenced",
+			advance: 54 * time.Minute,
+			action: func() {
+				if _, err := engine.AddSilence(Silence{DeviceID: "device-2", StartsAt: now.Add(-time.Minute), EndsAt: baseTime.Add(2 * time.Hour)}); err != nil {
+					t.Fatalf("AddSilence failed: %v", err)
+				}
+			},
+			expected: []string{"firing stale device-1"},
+		},
+		{
+			name:     "Repeated once the silence ends",
+			advance:  time.Hour,
+			expected: []string{"firing slow device-2", "firing stale device-1"},
+		},
+		{
+			name:     "Resolved when the device is deregistered",
+			advance:  time.Minute,
+			action:   func() { store.DeregisterDevice("device-2", false) },
+			expected: []string{"resolved slow device-2"},
+		},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.action != nil {
+			step.action()
+		}
+		if err := engine.RunOnce(context.Background()); err != nil {
+			t.Fatalf("%s: RunOnce failed: %v", step.name, err)
+		}
+		if received := receiver.take(); !reflect.DeepEqual(received, step.expected) {
+			t.Errorf("%s: expected %v, got %v", step.name, step.expected, received)
+		}
+	}
+
+	expectedTransitions := []string{
+		"pending slow device-2",
+		"firing slow device-2",
+		"firing stale device-1",
+		"resolved stale device-1",
+		"firing stale device-1",
+		"resolved slow device-2",
+	}
+	if !reflect.DeepEqual(transitions, expectedTransitions) {
+		t.Errorf("Expected transitions %v, got %v", expectedTransitions, transitions)
+	}
+
+	if token := receiver.header.Get("X-Token"); token != "secret" {
+		t.Errorf("Expected the configured header to be sent, got %q", token)
+	}
+
+	alerts := engine.Alerts()
+	if len(alerts) != 1 || alerts[0].Rule != "stale" || alerts[0].State != StateFiring || alerts[0].Value != (115*time.Minute).Seconds() {
+		t.Errorf("Unexpected alerts %+v", alerts)
+	}
+
+	if _, err := engine.AddSilence(Silence{Rule: "unknown", EndsAt: now.Add(time.Hour)}); err == nil {
+		t.Error("Expected a silence for an unknown rule to be rejected")
+	}
+	if _, err := engine.AddSilence(Silence{EndsAt: now.Add(-time.Hour)}); err == nil {
+		t.Error("Expected a silence that already ended to be rejected")
+	}
+	if silences := engine.Silences(); len(silences) != 0 {
+		t.Errorf("Expected ended silences to be dropped, got %+v", silences)
+	}
+}
+
+func TestEngineKeepsUndeliveredNotifications(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := baseTime
+
+	failing := &webhookReceiver{failures: 3}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+	healthy := &webhookReceiver{}
+	healthyServer := httptest.NewServer(healthy)
+	defer healthyServer.Close()
+
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	stale, err := ParseRule("stale", "no heartbeat for 10m")
+	if err != nil {
+		t.Fatalf("ParseRule failed: %v", err)
+	}
+	engine := NewEngine(store, Config{
+		RepeatInterval: time.Hour,
+		Rules:          []Rule{stale},
+		Webhooks:       []*Webhook{newTestWebhook(failingServer.URL, nil), newTestWebhook(healthyServer.URL, nil)},
+	})
+	engine.now = func() time.Time { return now }
+	store.AddHeartbeat("device-1", baseTime)
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		action    func()
+		expectErr bool
+		failing   []string
+		healthy   []string
+	}{
+		{
+			name:      "Failed delivery",
+			advance:   10 * time.Minute,
+			expectErr: true,
+			healthy:   []string{"firing stale device-1"},
+		},
+		{
+			name:    "Sent again on the next run",
+			advance: time.Minute,
+			failing: []string{"firing stale device-1"},
+		},
+		{
+			name:    "Resolved",
+			advance: time.Minute,
+			action:  func() { store.AddHeartbeat("device-1", now) },
+			failing: []string{"resolved stale device-1"},
+			healthy: []string{"resolved stale device-1"},
+		},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		if step.action != nil {
+			step.action()
+		}
+		if err := engine.RunOnce(context.Background()); (err != nil) != step.expectErr {
+			t.Fatalf("%s: expected error %v, got %v", step.name, step.expectErr, err)
+		}
+		if received := failing.take(); !reflect.DeepEqual(received, step.failing) {
+			t.Errorf("%s: expected the failing webhook to receive %v, got %v", step.name, step.failing, received)
+		}
+		if received := healthy.take(); !reflect.DeepEqual(received, step.healthy) {
+			t.Errorf("%s: expected the healthy webhook to receive %v, got %v", step.name, step.healthy, received)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	testCases := []struct {
+		name      string
+		failures  int
+		requests  int
+		expectErr bool
+	}{
+		{name: "Delivered", failures: 0, requests: 1},
+		{name: "Delivered after retries", failures: 2, requests: 3},
+		{name: "Gives up", failures: 5, requests: 3, expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			receiver := &webhookReceiver{failures: tc.failures}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			err := newTestWebhook(server.URL, nil).Send(context.Background(), []Notification{{Rule: "stale", DeviceID: "device-1", Status: StateFiring}})
+			if tc.expectErr != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if receiver.requests != tc.requests {
+				t.Errorf("Expected %d requests, got %d", tc.requests, receiver.requests)
+			}
+		})
+	}
+
+	// Client errors are not retried
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	start := time.Now()
+	webhook := newWebhook(server.URL, nil, time.Second, 3)
+	if err := webhook.Send(context.Background(), nil); err == nil {
+		t.Error("Expected a 400 response to fail")
+	}
+	if elapsed := time.Since(start); elapsed >= webhook.backoff {
+		t.Errorf("Expected no retry after a 400, took %s", elapsed)
+	}
+}
@@ -0,0 +1,126 @@
+package alerting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// Metric is a device stat a rule can test
+type Metric string
+
+const (
+	// MetricUptime is the uptime percentage
+	MetricUptime Metric = "uptime"
+	// MetricAvgUploadTime is the average upload time, compared in seconds
+	MetricAvgUploadTime Metric = "avg_upload_time"
+	// MetricHeartbeatAge is the time since the latest heartbeat, compared
+	// in seconds
+	MetricHeartbeatAge Metric = "heartbeat_age"
+)
+
+// Rule fires for a device while its condition holds for at least For
+type Rule struct {
+	Name      string
+	Expr      string // the condition as written in the config
+	Metric    Metric
+	Op        string // <, <=, > or >=
+	Threshold float64
+	For       time.Duration
+	Window    time.Duration // evaluate over the samples of this window, 0 for lifetime aggregates including pruned samples
+	Severity  string
+}
+
+// ParseRule parses a rule condition. Supported forms are
+//
+//	uptime < 95 [for 30m]
+//	avg_upload_time > 5m [for 10m]
+//	no heartbeat for 10m
+//
+// with <, <=, > or >= as comparisons.
+func ParseRule(name, expr string) (Rule, error) {
+	rule := Rule{Name: name, Expr: expr}
+	fields := strings.Fields(expr)
+
+	if len(fields) == 4 && fields[0] == "no" && fields[1] == "heartbeat" && fields[2] == "for" {
+		age, err := time.ParseDuration(fields[3])
+		if err != nil || age <= 0 {
+			return rule, fmt.Errorf("rule %q: invalid duration %q", name, fields[3])
+		}
+		rule.Metric = MetricHeartbeatAge
+		rule.Op = ">="
+		rule.Threshold = age.Seconds()
+		return rule, nil
+	}
+
+	if len(fields) != 3 && !(len(fields) == 5 && fields[3] == "for") {
+		return rule, fmt.Errorf("rule %q: expected '<metric> <op> <threshold> [for <duration>]' or 'no heartbeat for <duration>'", name)
+	}
+
+	switch op := fields[1]; op {
+	case "<", "<=", ">", ">=":
+		rule.Op = op
+	default:
+		return rule, fmt.Errorf("rule %q: unknown comparison %q", name, op)
+	}
+
+	switch metric := Metric(fields[0]); metric {
+	case MetricUptime:
+		threshold, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return rule, fmt.Errorf("rule %q: invalid uptime threshold %q", name, fields[2])
+		}
+		rule.Metric, rule.Threshold = metric, threshold
+	case MetricAvgUploadTime, MetricHeartbeatAge:
+		threshold, err := time.ParseDuration(fields[2])
+		if err != nil {
+			return rule, fmt.Errorf("rule %q: invalid duration threshold %q", name, fields[2])
+		}
+		rule.Metric, rule.Threshold = metric, threshold.Seconds()
+	default:
+		return rule, fmt.Errorf("rule %q: unknown metric %q: expected uptime, avg_upload_time or heartbeat_age", name, fields[0])
+	}
+
+	if len(fields) == 5 {
+		holdFor, err := time.ParseDuration(fields[4])
+		if err != nil || holdFor < 0 {
+			return rule, fmt.Errorf("rule %q: invalid duration %q", name, fields[4])
+		}
+		rule.For = holdFor
+	}
+	return rule, nil
+}
+
+// value returns the rule's metric for a device's aggregates at now, or
+// false if the device has no samples the metric depends on
+func (r Rule) value(aggregates storage.Aggregates, now time.Time) (float64, bool) {
+	switch r.Metric {
+	case MetricUptime:
+		return aggregates.Uptime(), aggregates.HeartbeatCount > 0
+	case MetricAvgUploadTime:
+		return aggregates.AvgUploadTime().Seconds(), aggregates.UploadCount > 0
+	case MetricHeartbeatAge:
+		return now.Sub(aggregates.LastHeartbeat).Seconds(), aggregates.HeartbeatCount > 0
+	default:
+		return 0, false
+	}
+}
+
+// matches reports whether value satisfies the rule's comparison
+func (r Rule) matches(value float64) bool {
+	switch r.Op {
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	default:
+		return false
+	}
+}
@@ -0,0 +1,125 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	testCases := []struct {
+		name      string
+		expr      string
+		expected  Rule
+		expectErr bool
+	}{
+		{
+			name:     "Uptime",
+			expr:     "uptime < 95",
+			expected: Rule{Metric: MetricUptime, Op: "<", Threshold: 95},
+		},
+		{
+			name:     "Uptime with for",
+			expr:     "uptime <= 99.5 for 30m",
+			expected: Rule{Metric: MetricUptime, Op: "<=", Threshold: 99.5, For: 30 * time.Minute},
+		},
+		{
+			name:     "Average upload time",
+			expr:     "avg_upload_time > 5m for 10m",
+			expected: Rule{Metric: MetricAvgUploadTime, Op: ">", Threshold: 300, For: 10 * time.Minute},
+		},
+		{
+			name:     "No heartbeat",
+			expr:     "no heartbeat for 10m",
+			expected: Rule{Metric: MetricHeartbeatAge, Op: ">=", Threshold: 600},
+		},
+		{name: "Unknown metric", expr: "temperature > 80", expectErr: true},
+		{name: "Unknown comparison", expr: "uptime == 95", expectErr: true},
+		{name: "Invalid threshold", expr: "avg_upload_time > fast", expectErr: true},
+		{name: "Invalid for", expr: "uptime < 95 for ever", expectErr: true},
+		{name: "Trailing words", expr: "uptime < 95 for", expectErr: true},
+		{name: "Empty", expr: "", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := ParseRule(tc.name, tc.expr)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %+v", rule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule failed: %v", err)
+			}
+			tc.expected.Name, tc.expected.Expr = tc.name, tc.expr
+			if rule != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, rule)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.yaml")
+	content := `
+interval: 1m
+repeat_interval: 4h
+rules:
+  - name: low-uptime
+    expr: uptime < 95 for 30m
+    window: 24h
+    severity: warning
+  - expr: no heartbeat for 10m
+webhooks:
+  - url: http://example.com/hook
+    headers:
+      Authorization: Bearer secret
+silences:
+  - rule: low-uptime
+    device_id: device-1
+    ends_at: 2025-01-02T00:00:00Z
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Interval != time.Minute || config.RepeatInterval != 4*time.Hour {
+		t.Errorf("Expected intervals 1m and 4h, got %s and %s", config.Interval, config.RepeatInterval)
+	}
+	if len(config.Rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(config.Rules))
+	}
+	if rule := config.Rules[0]; rule.Window != 24*time.Hour || rule.Severity != "warning" || rule.For != 30*time.Minute {
+		t.Errorf("Unexpected first rule %+v", rule)
+	}
+	if name := config.Rules[1].Name; name != "no heartbeat for 10m" {
+		t.Errorf("Expected an unnamed rule to be named by its expression, got %q", name)
+	}
+	if len(config.Webhooks) != 1 || config.Webhooks[0].Headers["Authorization"] != "Bearer secret" || config.Webhooks[0].MaxAttempts != defaultWebhookMaxAttempts {
+		t.Errorf("Unexpected webhooks %+v", config.Webhooks)
+	}
+	if len(config.Silences) != 1 || !config.Silences[0].EndsAt.Equal(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected silences %+v", config.Silences)
+	}
+
+	invalid := []string{
+		"rules:\n  - expr: uptime < 95\n  - expr: uptime < 95\n",
+		"rules:\n  - expr: uptime is low\n",
+		"webhooks:\n  - headers: {}\n",
+		"interval: soon\n",
+	}
+	for _, content := range invalid {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("Expected an error loading %q", content)
+		}
+	}
+}
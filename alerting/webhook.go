@@ -0,0 +1,129 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookMaxAttempts = 3
+	defaultWebhookBackoff     = time.Second
+)
+
+// Alert states
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Notification is an alert as delivered to webhooks
+type Notification struct {
+	Rule       string     `json:"rule"`
+	DeviceID   string     `json:"device_id"`
+	Status     string     `json:"status"` // "firing" or "resolved"
+	Expr       string     `json:"expr"`
+	Severity   string     `json:"severity,omitempty"`
+	Value      float64    `json:"value"` // latest value of the rule's metric
+	StartsAt   time.Time  `json:"starts_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// webhookPayload is the body POSTed to webhooks
+type webhookPayload struct {
+	Alerts []Notification `json:"alerts"`
+}
+
+// Webhook delivers notifications to an HTTP endpoint
+type Webhook struct {
+	URL         string
+	Headers     map[string]string
+	Timeout     time.Duration // per attempt
+	MaxAttempts int
+
+	client  *http.Client
+	backoff time.Duration // before the second attempt, doubling after that
+}
+
+// newWebhook fills in the defaults of a configured webhook
+func newWebhook(url string, headers map[string]string, timeout time.Duration, maxAttempts int) *Webhook {
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookMaxAttempts
+	}
+	return &Webhook{
+		URL:         url,
+		Headers:     headers,
+		Timeout:     timeout,
+		MaxAttempts: maxAttempts,
+		client:      &http.Client{},
+		backoff:     defaultWebhookBackoff,
+	}
+}
+
+// Send POSTs the notifications as one JSON document, retrying with
+// exponential backoff on network errors, 429 and 5xx responses
+func (w *Webhook) Send(ctx context.Context, notifications []Notification) error {
+	body, err := json.Marshal(webhookPayload{Alerts: notifications})
+	if err != nil {
+		return fmt.Errorf("failed to encode notifications: %w", err)
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.MaxAttempts {
+			return fmt.Errorf("webhook %s: %w", w.URL, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook %s: %w", w.URL, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports whether a failure is
+// worth retrying
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,147 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	TypeHeartbeat = "heartbeat"
+	TypeStats     = "stats"
+	TypeStatus    = "status"
+	TypeAlert     = "alert"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped
+const subscriberBuffer = 256
+
+// Event is something that happened to a device
+type Event struct {
+	ID       uint64 // increases by one per event, starting at 1
+	Type     string
+	DeviceID string
+	Time     time.Time       // when the event was published
+	Data     json.RawMessage // the event's payload
+}
+
+// Bus fans out events to subscribers and keeps the latest ones in a bounded
+// buffer so subscribers can resume after reconnecting. Event IDs restart
+// when the process does.
+type Bus struct {
+	mu          sync.Mutex // guards every field below
+	buffer      []Event    // ring buffer, oldest event at head once full
+	head        int
+	lastID      uint64
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives the events published after it was created. Its
+// channel is closed when the subscriber falls too far behind, or when the
+// subscription or bus is closed.
+type Subscription struct {
+	bus    *Bus
+	events chan Event
+}
+
+// NewBus creates a bus that keeps the latest capacity events
+func NewBus(capacity int) *Bus {
+	return &Bus{
+		buffer:      make([]Event, 0, max(capacity, 1)),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish encodes data as the payload of a new event and delivers it to
+// every subscriber
+func (b *Bus) Publish(eventType, deviceID string, data any) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, DeviceID: deviceID, Time: time.Now(), Data: payload}
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, event)
+	} else {
+		b.buffer[b.head] = event
+		b.head = (b.head + 1) % len(b.buffer)
+	}
+
+	for subscription := range b.subscribers {
+		select {
+		case subscription.events <- event:
+		default:
+			// Too far behind; the subscriber can resume from the buffer
+			delete(b.subscribers, subscription)
+			close(subscription.events)
+		}
+	}
+	return event, nil
+}
+
+// Subscribe subscribes to new events. If resume is set it also returns the
+// buffered events after lastEventID, oldest first; events that have left the
+// buffer are lost.
+func (b *Bus) Subscribe(lastEventID uint64, resume bool) (*Subscription, []Event) {
+	subscription := &Subscription{bus: b, events: make(chan Event, subscriberBuffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(subscription.events)
+		return subscription, nil
+	}
+	b.subscribers[subscription] = struct{}{}
+
+	// An ID past the latest one is from before a restart; nothing to resume
+	if !resume || lastEventID >= b.lastID {
+		return subscription, nil
+	}
+	oldestID := b.lastID - uint64(len(b.buffer)) + 1
+	skip := 0
+	if lastEventID >= oldestID {
+		skip = int(lastEventID - oldestID + 1)
+	}
+	replay := make([]Event, 0, len(b.buffer)-skip)
+	for i := skip; i < len(b.buffer); i++ {
+		replay = append(replay, b.buffer[(b.head+i)%len(b.buffer)])
+	}
+	return subscription, replay
+}
+
+// Close closes every subscription; later subscriptions are closed at once
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for subscription := range b.subscribers {
+		delete(b.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+// Events returns the channel events are delivered on
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops delivering events to the subscription
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, subscribed := s.bus.subscribers[s]; subscribed {
+		delete(s.bus.subscribers, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,130 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// eventIDs returns the IDs of events
+func eventIDs(events []Event) []uint64 {
+	ids := make([]uint64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestBusResume(t *testing.T) {
+	bus := NewBus(3)
+	for i := 0; i < 5; i++ {
+		if _, err := bus.Publish(TypeHeartbeat, "device-1", HeartbeatData{DeviceID: "device-1", Count: i}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		lastEventID uint64
+		resume      bool
+		expected    []uint64
+	}{
+		{name: "New subscriber", expected: []uint64{}},
+		{name: "Resume within the buffer", lastEventID: 3, resume: true, expected: []uint64{4, 5}},
+		{name: "Resume from before the buffer", lastEventID: 1, resume: true, expected: []uint64{3, 4, 5}},
+		{name: "Resume from zero", lastEventID: 0, resume: true, expected: []uint64{3, 4, 5}},
+		{name: "Up to date", lastEventID: 5, resume: true, expected: []uint64{}},
+		{name: "ID from before a restart", lastEventID: 42, resume: true, expected: []uint64{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			subscription, replay := bus.Subscribe(tc.lastEventID, tc.resume)
+			defer subscription.Close()
+			if ids := eventIDs(replay); !reflect.DeepEqual(ids, tc.expected) {
+				t.Errorf("Expected replay of %v, got %v", tc.expected, ids)
+			}
+		})
+	}
+
+	var data HeartbeatData
+	_, replay := bus.Subscribe(4, true)
+	if err := json.Unmarshal(replay[0].Data, &data); err != nil || data.Count != 4 {
+		t.Errorf("Expected the payload of event 5, got %s", replay[0].Data)
+	}
+}
+
+func TestBusSubscribers(t *testing.T) {
+	bus := NewBus(10)
+	live, _ := bus.Subscribe(0, false)
+	slow, _ := bus.Subscribe(0, false)
+	closed, _ := bus.Subscribe(0, false)
+	closed.Close()
+	closed.Close() // closing twice is harmless
+
+	// The live subscriber keeps up while the slow one never reads
+	for i := 0; i < subscriberBuffer+1; i++ {
+		if _, err := bus.Publish(TypeStats, "device-1", StatsData{}); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		if event := <-live.Events(); event.ID != uint64(i+1) {
+			t.Fatalf("Expected event %d, got %d", i+1, event.ID)
+		}
+	}
+
+	received := 0
+	for range slow.Events() {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected the slow subscriber to be dropped after %d events, got %d", subscriberBuffer, received)
+	}
+	if _, ok := <-closed.Events(); ok {
+		t.Error("Expected no events after closing a subscription")
+	}
+
+	bus.Close()
+	if _, ok := <-live.Events(); ok {
+		t.Error("Expected closing the bus to close subscriptions")
+	}
+	late, _ := bus.Subscribe(0, false)
+	if _, ok := <-late.Events(); ok {
+		t.Error("Expected subscriptions to a closed bus to be closed")
+	}
+}
+
+func TestPublishingStore(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bus := NewBus(10)
+	store := NewPublishingStore(storage.NewMemoryStore(), bus)
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	subscription, _ := bus.Subscribe(0, false)
+
+	store.AddHeartbeat("device-1", baseTime)
+	store.AddHeartbeat("unknown", baseTime) // rejected, not published
+	store.AddUploadTime("device-1", baseTime, int64(time.Second))
+	store.AddBatch("device-1",
+		[]time.Time{baseTime.Add(2 * time.Minute), baseTime.Add(time.Minute)},
+		[]storage.UploadSample{{SentAt: baseTime.Add(3 * time.Minute), UploadTime: int64(3 * time.Second)}})
+	bus.PublishStatusChange(storage.StatusChange{DeviceID: "device-1", StatusTransition: storage.StatusTransition{From: storage.StatusOnline, To: storage.StatusOffline, At: baseTime}})
+	bus.Close()
+
+	var received []string
+	for event := range subscription.Events() {
+		received = append(received, event.Type+" "+string(event.Data))
+	}
+	expected := []string{
+		`heartbeat {"device_id":"device-1","sent_at":"2025-01-01T00:00:00Z","count":1}`,
+		`stats {"device_id":"device-1","sent_at":"2025-01-01T00:00:00Z","upload_time":"1s","count":1}`,
+		`heartbeat {"device_id":"device-1","sent_at":"2025-01-01T00:02:00Z","count":2}`,
+		`stats {"device_id":"device-1","sent_at":"2025-01-01T00:03:00Z","upload_time":"3s","count":1}`,
+		`status {"device_id":"device-1","from":"online","to":"offline","at":"2025-01-01T00:00:00Z"}`,
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected events\n%v\ngot\n%v", expected, received)
+	}
+}
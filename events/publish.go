@@ -0,0 +1,134 @@
+package events
+
+import (
+	"log"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/alerting"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// HeartbeatData is the payload of heartbeat events. Batches publish a single
+// event for all their heartbeats.
+type HeartbeatData struct {
+	DeviceID string    `json:"device_id"`
+	SentAt   time.Time `json:"sent_at"` // the latest heartbeat
+	Count    int       `json:"count"`
+}
+
+// StatsData is the payload of stats events. Batches publish a single event
+// for all their upload times.
+type StatsData struct {
+	DeviceID   string    `json:"device_id"`
+	SentAt     time.Time `json:"sent_at"`     // the latest report
+	UploadTime string    `json:"upload_time"` // of the latest report
+	Count      int       `json:"count"`
+}
+
+// StatusData is the payload of status events
+type StatusData struct {
+	DeviceID string    `json:"device_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	At       time.Time `json:"at"`
+}
+
+// AlertData is the payload of alert events
+type AlertData struct {
+	Rule     string    `json:"rule"`
+	DeviceID string    `json:"device_id"`
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to"` // "pending", "firing" or "resolved"
+	Value    float64   `json:"value"`
+	At       time.Time `json:"at"`
+	Silenced bool      `json:"silenced"`
+}
+
+// PublishingStore wraps a DeviceStore and publishes an event for the
+// heartbeats and upload times it accepts
+type PublishingStore struct {
+	storage.DeviceStore
+	bus *Bus
+}
+
+// NewPublishingStore wraps store
+func NewPublishingStore(store storage.DeviceStore, bus *Bus) *PublishingStore {
+	return &PublishingStore{DeviceStore: store, bus: bus}
+}
+
+// AddHeartbeat adds a heartbeat and publishes it if it was accepted
+func (s *PublishingStore) AddHeartbeat(deviceID string, timestamp time.Time) error {
+	err := s.DeviceStore.AddHeartbeat(deviceID, timestamp)
+	if err == nil {
+		s.publish(TypeHeartbeat, deviceID, HeartbeatData{DeviceID: deviceID, SentAt: timestamp, Count: 1})
+	}
+	return err
+}
+
+// AddUploadTime adds an upload time and publishes it if it was accepted
+func (s *PublishingStore) AddUploadTime(deviceID string, sentAt time.Time, uploadTime int64) error {
+	err := s.DeviceStore.AddUploadTime(deviceID, sentAt, uploadTime)
+	if err == nil {
+		s.publish(TypeStats, deviceID, StatsData{DeviceID: deviceID, SentAt: sentAt, UploadTime: time.Duration(uploadTime).String(), Count: 1})
+	}
+	return err
+}
+
+// AddBatch adds heartbeats and upload times and publishes one event per
+// kind if they were accepted
+func (s *PublishingStore) AddBatch(deviceID string, heartbeats []time.Time, uploads []storage.UploadSample) error {
+	err := s.DeviceStore.AddBatch(deviceID, heartbeats, uploads)
+	if err != nil {
+		return err
+	}
+
+	if len(heartbeats) > 0 {
+		latest := heartbeats[0]
+		for _, heartbeat := range heartbeats[1:] {
+			if heartbeat.After(latest) {
+				latest = heartbeat
+			}
+		}
+		s.publish(TypeHeartbeat, deviceID, HeartbeatData{DeviceID: deviceID, SentAt: latest, Count: len(heartbeats)})
+	}
+	if len(uploads) > 0 {
+		latest := uploads[0]
+		for _, upload := range uploads[1:] {
+			if upload.SentAt.After(latest.SentAt) {
+				latest = upload
+			}
+		}
+		s.publish(TypeStats, deviceID, StatsData{DeviceID: deviceID, SentAt: latest.SentAt, UploadTime: time.Duration(latest.UploadTime).String(), Count: len(uploads)})
+	}
+	return nil
+}
+
+func (s *PublishingStore) publish(eventType, deviceID string, data any) {
+	if _, err := s.bus.Publish(eventType, deviceID, data); err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// PublishStatusChange publishes a device status transition
+func (b *Bus) PublishStatusChange(change storage.StatusChange) {
+	data := StatusData{DeviceID: change.DeviceID, From: string(change.From), To: string(change.To), At: change.At}
+	if _, err := b.Publish(TypeStatus, change.DeviceID, data); err != nil {
+		log.Printf("Failed to publish status event: %v", err)
+	}
+}
+
+// PublishAlertTransition publishes an alert transition
+func (b *Bus) PublishAlertTransition(transition alerting.Transition) {
+	data := AlertData{
+		Rule:     transition.Rule,
+		DeviceID: transition.DeviceID,
+		From:     transition.From,
+		To:       transition.To,
+		Value:    transition.Value,
+		At:       transition.At,
+		Silenced: transition.Silenced,
+	}
+	if _, err := b.Publish(TypeAlert, transition.DeviceID, data); err != nil {
+		log.Printf("Failed to publish alert event: %v", err)
+	}
+}
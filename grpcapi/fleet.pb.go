@@ -0,0 +1,493 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: fleet.proto
+
+package grpcapi
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type HeartbeatRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	SentAt        *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"` // required
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatRequest) Reset() {
+	*x = HeartbeatRequest{}
+	mi := &file_fleet_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatRequest) ProtoMessage() {}
+
+func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *HeartbeatRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+type HeartbeatResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatResponse) Reset() {
+	*x = HeartbeatResponse{}
+	mi := &file_fleet_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatResponse) ProtoMessage() {}
+
+func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{1}
+}
+
+type UploadStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	SentAt        *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`             // required
+	UploadTime    *durationpb.Duration   `protobuf:"bytes,3,opt,name=upload_time,json=uploadTime,proto3" json:"upload_time,omitempty"` // required, not negative
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadStatsRequest) Reset() {
+	*x = UploadStatsRequest{}
+	mi := &file_fleet_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadStatsRequest) ProtoMessage() {}
+
+func (x *UploadStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadStatsRequest.ProtoReflect.Descriptor instead.
+func (*UploadStatsRequest) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UploadStatsRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *UploadStatsRequest) GetSentAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.SentAt
+	}
+	return nil
+}
+
+func (x *UploadStatsRequest) GetUploadTime() *durationpb.Duration {
+	if x != nil {
+		return x.UploadTime
+	}
+	return nil
+}
+
+type UploadStatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadStatsResponse) Reset() {
+	*x = UploadStatsResponse{}
+	mi := &file_fleet_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadStatsResponse) ProtoMessage() {}
+
+func (x *UploadStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadStatsResponse.ProtoReflect.Descriptor instead.
+func (*UploadStatsResponse) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{3}
+}
+
+type GetStatsRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	// Optional time window of samples sent in [from, to)
+	From          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=from,proto3" json:"from,omitempty"`
+	To            *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=to,proto3" json:"to,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_fleet_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetStatsRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *GetStatsRequest) GetFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *GetStatsRequest) GetTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+type GetStatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AvgUploadTime *durationpb.Duration   `protobuf:"bytes,1,opt,name=avg_upload_time,json=avgUploadTime,proto3" json:"avg_upload_time,omitempty"`
+	Uptime        float64                `protobuf:"fixed64,2,opt,name=uptime,proto3" json:"uptime,omitempty"` // percentage like 98.999
+	// Samples the stats are computed from; both are 0 if there is no data yet
+	HeartbeatCount int64 `protobuf:"varint,3,opt,name=heartbeat_count,json=heartbeatCount,proto3" json:"heartbeat_count,omitempty"`
+	UploadCount    int64 `protobuf:"varint,4,opt,name=upload_count,json=uploadCount,proto3" json:"upload_count,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetStatsResponse) Reset() {
+	*x = GetStatsResponse{}
+	mi := &file_fleet_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsResponse) ProtoMessage() {}
+
+func (x *GetStatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsResponse.ProtoReflect.Descriptor instead.
+func (*GetStatsResponse) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetStatsResponse) GetAvgUploadTime() *durationpb.Duration {
+	if x != nil {
+		return x.AvgUploadTime
+	}
+	return nil
+}
+
+func (x *GetStatsResponse) GetUptime() float64 {
+	if x != nil {
+		return x.Uptime
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetHeartbeatCount() int64 {
+	if x != nil {
+		return x.HeartbeatCount
+	}
+	return 0
+}
+
+func (x *GetStatsResponse) GetUploadCount() int64 {
+	if x != nil {
+		return x.UploadCount
+	}
+	return 0
+}
+
+type StreamHeartbeatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      int64                  `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Rejected      int64                  `protobuf:"varint,2,opt,name=rejected,proto3" json:"rejected,omitempty"` // invalid heartbeats or unknown devices
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamHeartbeatsResponse) Reset() {
+	*x = StreamHeartbeatsResponse{}
+	mi := &file_fleet_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamHeartbeatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamHeartbeatsResponse) ProtoMessage() {}
+
+func (x *StreamHeartbeatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_fleet_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamHeartbeatsResponse.ProtoReflect.Descriptor instead.
+func (*StreamHeartbeatsResponse) Descriptor() ([]byte, []int) {
+	return file_fleet_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *StreamHeartbeatsResponse) GetAccepted() int64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *StreamHeartbeatsResponse) GetRejected() int64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+var File_fleet_proto protoreflect.FileDescriptor
+
+const file_fleet_proto_rawDesc = "" +
+	"\n" +
+	"\vfleet.proto\x12\x0ffleetmonitor.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"d\n" +
+	"\x10HeartbeatRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x123\n" +
+	"\asent_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt\"\x13\n" +
+	"\x11HeartbeatResponse\"\xa2\x01\n" +
+	"\x12UploadStatsRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x123\n" +
+	"\asent_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x06sentAt\x12:\n" +
+	"\vupload_time\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\n" +
+	"uploadTime\"\x15\n" +
+	"\x13UploadStatsResponse\"\x8a\x01\n" +
+	"\x0fGetStatsRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12.\n" +
+	"\x04from\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04from\x12*\n" +
+	"\x02to\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x02to\"\xb9\x01\n" +
+	"\x10GetStatsResponse\x12A\n" +
+	"\x0favg_upload_time\x18\x01 \x01(\v2\x19.google.protobuf.DurationR\ravgUploadTime\x12\x16\n" +
+	"\x06uptime\x18\x02 \x01(\x01R\x06uptime\x12'\n" +
+	"\x0fheartbeat_count\x18\x03 \x01(\x03R\x0eheartbeatCount\x12!\n" +
+	"\fupload_count\x18\x04 \x01(\x03R\vuploadCount\"R\n" +
+	"\x18StreamHeartbeatsResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x03R\baccepted\x12\x1a\n" +
+	"\brejected\x18\x02 \x01(\x03R\brejected2\xf2\x02\n" +
+	"\rDeviceService\x12R\n" +
+	"\tHeartbeat\x12!.fleetmonitor.v1.HeartbeatRequest\x1a\".fleetmonitor.v1.HeartbeatResponse\x12X\n" +
+	"\vUploadStats\x12#.fleetmonitor.v1.UploadStatsRequest\x1a$.fleetmonitor.v1.UploadStatsResponse\x12O\n" +
+	"\bGetStats\x12 .fleetmonitor.v1.GetStatsRequest\x1a!.fleetmonitor.v1.GetStatsResponse\x12b\n" +
+	"\x10StreamHeartbeats\x12!.fleetmonitor.v1.HeartbeatRequest\x1a).fleetmonitor.v1.StreamHeartbeatsResponse(\x01B-Z+github.com/vdnguyen58/fleet-monitor/grpcapib\x06proto3"
+
+var (
+	file_fleet_proto_rawDescOnce sync.Once
+	file_fleet_proto_rawDescData []byte
+)
+
+func file_fleet_proto_rawDescGZIP() []byte {
+	file_fleet_proto_rawDescOnce.Do(func() {
+		file_fleet_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_fleet_proto_rawDesc), len(file_fleet_proto_rawDesc)))
+	})
+	return file_fleet_proto_rawDescData
+}
+
+var file_fleet_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_fleet_proto_goTypes = []any{
+	(*HeartbeatRequest)(nil),         // 0: fleetmonitor.v1.HeartbeatRequest
+	(*HeartbeatResponse)(nil),        // 1: fleetmonitor.v1.HeartbeatResponse
+	(*UploadStatsRequest)(nil),       // 2: fleetmonitor.v1.UploadStatsRequest
+	(*UploadStatsResponse)(nil),      // 3: fleetmonitor.v1.UploadStatsResponse
+	(*GetStatsRequest)(nil),          // 4: fleetmonitor.v1.GetStatsRequest
+	(*GetStatsResponse)(nil),         // 5: fleetmonitor.v1.GetStatsResponse
+	(*StreamHeartbeatsResponse)(nil), // 6: fleetmonitor.v1.StreamHeartbeatsResponse
+	(*timestamppb.Timestamp)(nil),    // 7: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),      // 8: google.protobuf.Duration
+}
+var file_fleet_proto_depIdxs = []int32{
+	7,  // 0: fleetmonitor.v1.HeartbeatRequest.sent_at:type_name -> google.protobuf.Timestamp
+	7,  // 1: fleetmonitor.v1.UploadStatsRequest.sent_at:type_name -> google.protobuf.Timestamp
+	8,  // 2: fleetmonitor.v1.UploadStatsRequest.upload_time:type_name -> google.protobuf.Duration
+	7,  // 3: fleetmonitor.v1.GetStatsRequest.from:type_name -> google.protobuf.Timestamp
+	7,  // 4: fleetmonitor.v1.GetStatsRequest.to:type_name -> google.protobuf.Timestamp
+	8,  // 5: fleetmonitor.v1.GetStatsResponse.avg_upload_time:type_name -> google.protobuf.Duration
+	0,  // 6: fleetmonitor.v1.DeviceService.Heartbeat:input_type -> fleetmonitor.v1.HeartbeatRequest
+	2,  // 7: fleetmonitor.v1.DeviceService.UploadStats:input_type -> fleetmonitor.v1.UploadStatsRequest
+	4,  // 8: fleetmonitor.v1.DeviceService.GetStats:input_type -> fleetmonitor.v1.GetStatsRequest
+	0,  // 9: fleetmonitor.v1.DeviceService.StreamHeartbeats:input_type -> fleetmonitor.v1.HeartbeatRequest
+	1,  // 10: fleetmonitor.v1.DeviceService.Heartbeat:output_type -> fleetmonitor.v1.HeartbeatResponse
+	3,  // 11: fleetmonitor.v1.DeviceService.UploadStats:output_type -> fleetmonitor.v1.UploadStatsResponse
+	5,  // 12: fleetmonitor.v1.DeviceService.GetStats:output_type -> fleetmonitor.v1.GetStatsResponse
+	6,  // 13: fleetmonitor.v1.DeviceService.StreamHeartbeats:output_type -> fleetmonitor.v1.StreamHeartbeatsResponse
+	10, // [10:14] is the sub-list for method output_type
+	6,  // [6:10] is the sub-list for method input_type
+	6,  // [6:6] is the sub-list for extension type_name
+	6,  // [6:6] is the sub-list for extension extendee
+	0,  // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_fleet_proto_init() }
+func file_fleet_proto_init() {
+	if File_fleet_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_fleet_proto_rawDesc), len(file_fleet_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_fleet_proto_goTypes,
+		DependencyIndexes: file_fleet_proto_depIdxs,
+		MessageInfos:      file_fleet_proto_msgTypes,
+	}.Build()
+	File_fleet_proto = out.File
+	file_fleet_proto_goTypes = nil
+	file_fleet_proto_depIdxs = nil
+}
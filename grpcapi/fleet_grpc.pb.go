@@ -0,0 +1,247 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: fleet.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	DeviceService_Heartbeat_FullMethodName        = "/fleetmonitor.v1.DeviceService/Heartbeat"
+	DeviceService_UploadStats_FullMethodName      = "/fleetmonitor.v1.DeviceService/UploadStats"
+	DeviceService_GetStats_FullMethodName         = "/fleetmonitor.v1.DeviceService/GetStats"
+	DeviceService_StreamHeartbeats_FullMethodName = "/fleetmonitor.v1.DeviceService/StreamHeartbeats"
+)
+
+// DeviceServiceClient is the client API for DeviceService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// DeviceService receives device telemetry and serves device stats, like the
+// /api/v1/devices REST routes.
+type DeviceServiceClient interface {
+	// Heartbeat stores a heartbeat, like POST /devices/{device_id}/heartbeat
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// UploadStats stores an upload time, like POST /devices/{device_id}/stats
+	UploadStats(ctx context.Context, in *UploadStatsRequest, opts ...grpc.CallOption) (*UploadStatsResponse, error)
+	// GetStats returns the stats of a device, like GET /devices/{device_id}/stats
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error)
+	// StreamHeartbeats stores heartbeats as they are sent, from any number of
+	// devices, and reports how many were accepted when the client closes the
+	// stream. Invalid heartbeats are rejected without ending the stream.
+	StreamHeartbeats(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[HeartbeatRequest, StreamHeartbeatsResponse], error)
+}
+
+type deviceServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeviceServiceClient(cc grpc.ClientConnInterface) DeviceServiceClient {
+	return &deviceServiceClient{cc}
+}
+
+func (c *deviceServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, DeviceService_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) UploadStats(ctx context.Context, in *UploadStatsRequest, opts ...grpc.CallOption) (*UploadStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UploadStatsResponse)
+	err := c.cc.Invoke(ctx, DeviceService_UploadStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*GetStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatsResponse)
+	err := c.cc.Invoke(ctx, DeviceService_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deviceServiceClient) StreamHeartbeats(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[HeartbeatRequest, StreamHeartbeatsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &DeviceService_ServiceDesc.Streams[0], DeviceService_StreamHeartbeats_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[HeartbeatRequest, StreamHeartbeatsResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DeviceService_StreamHeartbeatsClient = grpc.ClientStreamingClient[HeartbeatRequest, StreamHeartbeatsResponse]
+
+// DeviceServiceServer is the server API for DeviceService service.
+// All implementations must embed UnimplementedDeviceServiceServer
+// for forward compatibility.
+//
+// DeviceService receives device telemetry and serves device stats, like the
+// /api/v1/devices REST routes.
+type DeviceServiceServer interface {
+	// Heartbeat stores a heartbeat, like POST /devices/{device_id}/heartbeat
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// UploadStats stores an upload time, like POST /devices/{device_id}/stats
+	UploadStats(context.Context, *UploadStatsRequest) (*UploadStatsResponse, error)
+	// GetStats returns the stats of a device, like GET /devices/{device_id}/stats
+	GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error)
+	// StreamHeartbeats stores heartbeats as they are sent, from any number of
+	// devices, and reports how many were accepted when the client closes the
+	// stream. Invalid heartbeats are rejected without ending the stream.
+	StreamHeartbeats(grpc.ClientStreamingServer[HeartbeatRequest, StreamHeartbeatsResponse]) error
+	mustEmbedUnimplementedDeviceServiceServer()
+}
+
+// UnimplementedDeviceServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDeviceServiceServer struct{}
+
+func (UnimplementedDeviceServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedDeviceServiceServer) UploadStats(context.Context, *UploadStatsRequest) (*UploadStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadStats not implemented")
+}
+func (UnimplementedDeviceServiceServer) GetStats(context.Context, *GetStatsRequest) (*GetStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedDeviceServiceServer) StreamHeartbeats(grpc.ClientStreamingServer[HeartbeatRequest, StreamHeartbeatsResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamHeartbeats not implemented")
+}
+func (UnimplementedDeviceServiceServer) mustEmbedUnimplementedDeviceServiceServer() {}
+func (UnimplementedDeviceServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeDeviceServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeviceServiceServer will
+// result in compilation errors.
+type UnsafeDeviceServiceServer interface {
+	mustEmbedUnimplementedDeviceServiceServer()
+}
+
+func RegisterDeviceServiceServer(s grpc.ServiceRegistrar, srv DeviceServiceServer) {
+	// If the following call pancis, it indicates UnimplementedDeviceServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&DeviceService_ServiceDesc, srv)
+}
+
+func _DeviceService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_UploadStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).UploadStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_UploadStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).UploadStats(ctx, req.(*UploadStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: DeviceService_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DeviceService_StreamHeartbeats_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DeviceServiceServer).StreamHeartbeats(&grpc.GenericServerStream[HeartbeatRequest, StreamHeartbeatsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type DeviceService_StreamHeartbeatsServer = grpc.ClientStreamingServer[HeartbeatRequest, StreamHeartbeatsResponse]
+
+// DeviceService_ServiceDesc is the grpc.ServiceDesc for DeviceService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DeviceService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fleetmonitor.v1.DeviceService",
+	HandlerType: (*DeviceServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Heartbeat",
+			Handler:    _DeviceService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "UploadStats",
+			Handler:    _DeviceService_UploadStats_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _DeviceService_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamHeartbeats",
+			Handler:       _DeviceService_StreamHeartbeats_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "fleet.proto",
+}
@@ -0,0 +1,159 @@
+// Package grpcapi serves the device routes of the REST API over gRPC, for
+// services that prefer a typed client. The service is defined in
+// fleet.proto; fleet.pb.go and fleet_grpc.pb.go are generated from it.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fleet.proto
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errSignatureRequired refuses reports of devices that must sign them
+var errSignatureRequired = status.Error(codes.PermissionDenied, "Device must sign its reports: send them over REST or UDP, signed with one of its keys")
+
+// Server implements DeviceService on a device store. Requests are not
+// signed, so reports of devices that must sign theirs are refused.
+type Server struct {
+	UnimplementedDeviceServiceServer
+	store       storage.DeviceStore
+	credentials *storage.Credentials
+}
+
+// NewServer creates a service that reads from and writes into store,
+// checking in credentials which devices must sign their reports
+func NewServer(store storage.DeviceStore, credentials *storage.Credentials) *Server {
+	return &Server{store: store, credentials: credentials}
+}
+
+// Heartbeat stores a heartbeat
+func (s *Server) Heartbeat(ctx context.Context, req *HeartbeatRequest) (*HeartbeatResponse, error) {
+	if err := s.storeHeartbeat(req); err != nil {
+		return nil, err
+	}
+	return &HeartbeatResponse{}, nil
+}
+
+// UploadStats stores an upload time
+func (s *Server) UploadStats(ctx context.Context, req *UploadStatsRequest) (*UploadStatsResponse, error) {
+	item := models.BatchItem{
+		Type:   models.ReportStats,
+		SentAt: timeOf(req.GetSentAt()),
+	}
+	if req.GetUploadTime() != nil {
+		uploadTime := int64(req.GetUploadTime().AsDuration())
+		item.UploadTime = &uploadTime
+	}
+	if err := item.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !s.store.DeviceExists(req.GetDeviceId()) {
+		return nil, status.Error(codes.NotFound, "Device not found")
+	}
+	if s.credentials.SignatureRequired(req.GetDeviceId()) {
+		return nil, errSignatureRequired
+	}
+	if err := s.store.AddUploadTime(req.GetDeviceId(), item.SentAt, *item.UploadTime); err != nil {
+		return nil, storeError("upload time", err)
+	}
+	return &UploadStatsResponse{}, nil
+}
+
+// GetStats returns the stats of a device, optionally over a time window
+func (s *Server) GetStats(ctx context.Context, req *GetStatsRequest) (*GetStatsResponse, error) {
+	window := storage.TimeWindow{From: timeOf(req.GetFrom()), To: timeOf(req.GetTo())}
+	if !window.From.IsZero() && !window.To.IsZero() && !window.From.Before(window.To) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid time window: 'from' must be before 'to'")
+	}
+
+	if !s.store.DeviceExists(req.GetDeviceId()) {
+		return nil, status.Error(codes.NotFound, "Device not found")
+	}
+	aggregates, err := storage.WindowAggregates(s.store, req.GetDeviceId(), window)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to retrieve device data: %v", err)
+	}
+
+	return &GetStatsResponse{
+		AvgUploadTime:  durationpb.New(aggregates.AvgUploadTime()),
+		Uptime:         aggregates.Uptime(),
+		HeartbeatCount: aggregates.HeartbeatCount,
+		UploadCount:    aggregates.UploadCount,
+	}, nil
+}
+
+// StreamHeartbeats stores the heartbeats of a client stream, counting the
+// ones that are invalid, from unknown devices or from devices that must
+// sign their reports instead of failing
+func (s *Server) StreamHeartbeats(stream DeviceService_StreamHeartbeatsServer) error {
+	var response StreamHeartbeatsResponse
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&response)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.storeHeartbeat(req)
+		switch status.Code(err) {
+		case codes.OK:
+			response.Accepted++
+		case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied:
+			response.Rejected++
+		default:
+			return err
+		}
+	}
+}
+
+// storeHeartbeat validates and stores a heartbeat, returning a status error
+func (s *Server) storeHeartbeat(req *HeartbeatRequest) error {
+	item := models.BatchItem{
+		Type:   models.ReportHeartbeat,
+		SentAt: timeOf(req.GetSentAt()),
+	}
+	if err := item.Validate(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !s.store.DeviceExists(req.GetDeviceId()) {
+		return status.Error(codes.NotFound, "Device not found")
+	}
+	if s.credentials.SignatureRequired(req.GetDeviceId()) {
+		return errSignatureRequired
+	}
+	if err := s.store.AddHeartbeat(req.GetDeviceId(), item.SentAt); err != nil {
+		return storeError("heartbeat", err)
+	}
+	return nil
+}
+
+// storeError converts an error storing a report to a status error
+func storeError(report string, err error) error {
+	if errors.Is(err, storage.ErrDeviceNotFound) {
+		// Deregistered since it was checked
+		return status.Error(codes.NotFound, "Device not found")
+	}
+	return status.Errorf(codes.Internal, "Failed to store %s: %v", report, err)
+}
+
+// timeOf converts a timestamp, leaving unset ones zero
+func timeOf(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
@@ -0,0 +1,205 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// startServer serves the service in memory until the test ends
+func startServer(t *testing.T, store storage.DeviceStore, credentials *storage.Credentials) DeviceServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	RegisterDeviceServiceServer(server, NewServer(store, credentials))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewDeviceServiceClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"device-1", "signed"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	credentials := storage.NewCredentials()
+	if _, err := credentials.Rotate("signed", 0); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	client := startServer(t, store, credentials)
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		call     func() error
+		expected codes.Code
+	}{
+		{
+			name: "Heartbeat",
+			call: func() error {
+				_, err := client.Heartbeat(ctx, &HeartbeatRequest{DeviceId: "device-1", SentAt: timestamppb.New(start)})
+				return err
+			},
+			expected: codes.OK,
+		},
+		{
+			name: "Heartbeat without sent_at",
+			call: func() error {
+				_, err := client.Heartbeat(ctx, &HeartbeatRequest{DeviceId: "device-1"})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "Heartbeat from an unknown device",
+			call: func() error {
+				_, err := client.Heartbeat(ctx, &HeartbeatRequest{DeviceId: "unknown", SentAt: timestamppb.New(start)})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "Heartbeat from a device with keys",
+			call: func() error {
+				_, err := client.Heartbeat(ctx, &HeartbeatRequest{DeviceId: "signed", SentAt: timestamppb.New(start)})
+				return err
+			},
+			expected: codes.PermissionDenied,
+		},
+		{
+			name: "Upload stats",
+			call: func() error {
+				_, err := client.UploadStats(ctx, &UploadStatsRequest{
+					DeviceId:   "device-1",
+					SentAt:     timestamppb.New(start.Add(time.Minute)),
+					UploadTime: durationpb.New(2 * time.Second),
+				})
+				return err
+			},
+			expected: codes.OK,
+		},
+		{
+			name: "Upload stats without upload time",
+			call: func() error {
+				_, err := client.UploadStats(ctx, &UploadStatsRequest{DeviceId: "device-1", SentAt: timestamppb.New(start)})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "Negative upload time",
+			call: func() error {
+				_, err := client.UploadStats(ctx, &UploadStatsRequest{
+					DeviceId:   "device-1",
+					SentAt:     timestamppb.New(start),
+					UploadTime: durationpb.New(-time.Second),
+				})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "Upload stats from a device with keys",
+			call: func() error {
+				_, err := client.UploadStats(ctx, &UploadStatsRequest{
+					DeviceId:   "signed",
+					SentAt:     timestamppb.New(start),
+					UploadTime: durationpb.New(time.Second),
+				})
+				return err
+			},
+			expected: codes.PermissionDenied,
+		},
+		{
+			name: "Stats of an unknown device",
+			call: func() error {
+				_, err := client.GetStats(ctx, &GetStatsRequest{DeviceId: "unknown"})
+				return err
+			},
+			expected: codes.NotFound,
+		},
+		{
+			name: "Stats over an empty window",
+			call: func() error {
+				_, err := client.GetStats(ctx, &GetStatsRequest{
+					DeviceId: "device-1",
+					From:     timestamppb.New(start),
+					To:       timestamppb.New(start),
+				})
+				return err
+			},
+			expected: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := status.Code(tc.call()); code != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, code)
+			}
+		})
+	}
+
+	// A client stream stores the valid heartbeats and counts the others
+	stream, err := client.StreamHeartbeats(ctx)
+	if err != nil {
+		t.Fatalf("StreamHeartbeats failed: %v", err)
+	}
+	for _, req := range []*HeartbeatRequest{
+		{DeviceId: "device-1", SentAt: timestamppb.New(start.Add(2 * time.Minute))},
+		{DeviceId: "device-1"},
+		{DeviceId: "unknown", SentAt: timestamppb.New(start)},
+		{DeviceId: "signed", SentAt: timestamppb.New(start)},
+		{DeviceId: "device-1", SentAt: timestamppb.New(start.Add(3 * time.Minute))},
+	} {
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv failed: %v", err)
+	}
+	if summary.GetAccepted() != 2 || summary.GetRejected() != 3 {
+		t.Errorf("Expected 2 accepted and 3 rejected heartbeats, got %v", summary)
+	}
+
+	// Stats are computed like the REST API's
+	stats, err := client.GetStats(ctx, &GetStatsRequest{DeviceId: "device-1"})
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.GetHeartbeatCount() != 3 || stats.GetUptime() != 100 || stats.GetAvgUploadTime().AsDuration() != 2*time.Second {
+		t.Errorf("Unexpected stats %v", stats)
+	}
+
+	windowed, err := client.GetStats(ctx, &GetStatsRequest{DeviceId: "device-1", From: timestamppb.New(start.Add(2 * time.Minute))})
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if windowed.GetHeartbeatCount() != 2 || windowed.GetUploadCount() != 0 {
+		t.Errorf("Unexpected windowed stats %v", windowed)
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// AdminHandler handles administrative requests
+type AdminHandler struct {
+	store    storage.DeviceStore
+	reloader *storage.CSVWatcher
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(store storage.DeviceStore, reloader *storage.CSVWatcher) *AdminHandler {
+	return &AdminHandler{
+		store:    store,
+		reloader: reloader,
+	}
+}
+
+// AdminAuth checks the bearer token of administrative requests
+type AdminAuth struct {
+	token string // empty disables administrative requests
+}
+
+// NewAdminAuth creates an admin auth accepting the given token. An empty
+// token disables the admin API.
+func NewAdminAuth(token string) *AdminAuth {
+	return &AdminAuth{token: token}
+}
+
+// Authorized reports whether a request carries the admin token
+func (a *AdminAuth) Authorized(c *fiber.Ctx) bool {
+	if a.token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// Middleware rejects requests without the admin token
+func (a *AdminAuth) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if a.token == "" {
+			return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{
+				Msg: "Admin API disabled: no admin token is configured",
+			})
+		}
+		if !a.Authorized(c) {
+			return unauthorized(c, "Missing or invalid admin token")
+		}
+		return c.Next()
+	}
+}
+
+// PostSnapshot handles POST /admin/snapshot. The snapshot is streamed as it
+// is encoded; if encoding fails the response ends without the trailing
+// checksum, so a truncated snapshot cannot be restored.
+func (h *AdminHandler) PostSnapshot(c *fiber.Ctx) error {
+	filename := fmt.Sprintf("fleet-monitor-%s.snap", time.Now().UTC().Format("20060102T150405Z"))
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(fiber.StatusOK)
+
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		err := h.store.Snapshot(bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			log.Printf("Snapshot failed: %v", err)
+		}
+	})
+	return nil
+}
+
+// PostRestore handles POST /admin/restore, reading the snapshot as it
+// arrives
+func (h *AdminHandler) PostRestore(c *fiber.Ctx) error {
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	if err := h.store.Restore(body); err != nil {
+		if errors.Is(err, storage.ErrInvalidSnapshot) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid snapshot: %v", err),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to restore snapshot: %v", err),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetReload handles GET /admin/reload
+func (h *AdminHandler) GetReload(c *fiber.Ctx) error {
+	status, ok := h.reloader.LastReload()
+	if !ok {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	return c.Status(fiber.StatusOK).JSON(reloadResponse(status))
+}
+
+// PostReload handles POST /admin/reload
+func (h *AdminHandler) PostReload(c *fiber.Ctx) error {
+	status := h.reloader.Reload(storage.TriggerAPI)
+	if status.Err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to reload devices: %v", status.Err),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(reloadResponse(status))
+}
+
+// reloadResponse converts a reload status to the API representation
+func reloadResponse(status storage.ReloadStatus) models.ReloadResponse {
+	response := models.ReloadResponse{
+		Trigger:   status.Trigger,
+		Time:      status.Time,
+		Added:     status.Result.Added,
+		Retired:   status.Result.Retired,
+		Updated:   status.Result.Updated,
+		Unchanged: status.Result.Unchanged,
+	}
+	if response.Added == nil {
+		response.Added = []string{}
+	}
+	if response.Retired == nil {
+		response.Retired = []string{}
+	}
+	if response.Updated == nil {
+		response.Updated = []string{}
+	}
+	if status.Err != nil {
+		response.Error = status.Err.Error()
+	}
+	return response
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	heartbeat := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := storage.NewMemoryStore()
+	if err := source.RegisterDevice(storage.DeviceInfo{DeviceID: "dev-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	if err := source.AddHeartbeat("dev-1", heartbeat); err != nil {
+		t.Fatalf("AddHeartbeat failed: %v", err)
+	}
+	target := storage.NewMemoryStore()
+
+	newApp := func(store storage.DeviceStore, token string) *fiber.App {
+		handler := NewAdminHandler(store, nil)
+		app := fiber.New()
+		admin := app.Group("/admin", NewAdminAuth(token).Middleware())
+		admin.Post("/snapshot", handler.PostSnapshot)
+		admin.Post("/restore", handler.PostRestore)
+		return app
+	}
+	request := func(app *fiber.App, target, authorization, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		if authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, authorization)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, string(data)
+	}
+
+	sourceApp := newApp(source, "secret")
+	status, snapshot := request(sourceApp, "/admin/snapshot", "Bearer secret", "")
+	if status != fiber.StatusOK || !strings.HasPrefix(snapshot, "FMSS") {
+		t.Fatalf("Expected a snapshot, got %d: %q", status, snapshot)
+	}
+
+	testCases := []struct {
+		name          string
+		app           *fiber.App
+		target        string
+		authorization string
+		body          string
+		status        int
+	}{
+		{name: "Admin API disabled", app: newApp(source, ""), target: "/admin/snapshot", authorization: "Bearer ", status: fiber.StatusForbidden},
+		{name: "Missing token", app: sourceApp, target: "/admin/snapshot", status: fiber.StatusUnauthorized},
+		{name: "Wrong token", app: sourceApp, target: "/admin/snapshot", authorization: "Bearer guess", status: fiber.StatusUnauthorized},
+		{name: "Not a bearer token", app: sourceApp, target: "/admin/snapshot", authorization: "secret", status: fiber.StatusUnauthorized},
+		{name: "Restore without token", app: newApp(target, "secret"), target: "/admin/restore", body: snapshot, status: fiber.StatusUnauthorized},
+		{name: "Invalid snapshot", app: newApp(target, "secret"), target: "/admin/restore", authorization: "Bearer secret", body: "not a snapshot", status: fiber.StatusBadRequest},
+		{name: "Restore", app: newApp(target, "secret"), target: "/admin/restore", authorization: "Bearer secret", body: snapshot, status: fiber.StatusNoContent},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, body := request(tc.app, tc.target, tc.authorization, tc.body); status != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+		})
+	}
+
+	data, err := target.GetDeviceData("dev-1")
+	if err != nil || len(data.Heartbeats) != 1 || !data.Heartbeats[0].Equal(heartbeat) {
+		t.Errorf("Expected the restored heartbeat, got %+v (%v)", data, err)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/alerting"
+	"github.com/vdnguyen58/fleet-monitor/models"
+)
+
+// AlertsHandler handles alert and silence requests
+type AlertsHandler struct {
+	engine *alerting.Engine
+}
+
+// NewAlertsHandler creates a new alerts handler
+func NewAlertsHandler(engine *alerting.Engine) *AlertsHandler {
+	return &AlertsHandler{
+		engine: engine,
+	}
+}
+
+// ListAlerts handles GET /alerts
+func (h *AlertsHandler) ListAlerts(c *fiber.Ctx) error {
+	alerts := h.engine.Alerts()
+	response := make([]models.AlertResponse, 0, len(alerts))
+	for _, alert := range alerts {
+		entry := models.AlertResponse{
+			Rule:        alert.Rule,
+			DeviceID:    alert.DeviceID,
+			State:       alert.State,
+			Value:       alert.Value,
+			ActiveSince: alert.ActiveSince,
+			Silenced:    alert.Silenced,
+		}
+		if !alert.StartsAt.IsZero() {
+			entry.StartsAt = &alert.StartsAt
+		}
+		response = append(response, entry)
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// ListSilences handles GET /alerts/silences
+func (h *AlertsHandler) ListSilences(c *fiber.Ctx) error {
+	silences := h.engine.Silences()
+	response := make([]models.SilenceResponse, 0, len(silences))
+	for _, silence := range silences {
+		response = append(response, silenceResponse(silence))
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// PostSilence handles POST /alerts/silences
+func (h *AlertsHandler) PostSilence(c *fiber.Ctx) error {
+	var req models.SilenceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Invalid request body",
+		})
+	}
+
+	silence := alerting.Silence{
+		Rule:     req.Rule,
+		DeviceID: req.DeviceID,
+		Comment:  req.Comment,
+	}
+	if req.StartsAt != nil {
+		silence.StartsAt = *req.StartsAt
+	}
+	switch {
+	case req.EndsAt != nil && req.Duration != "":
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Set either ends_at or duration, not both",
+		})
+	case req.EndsAt != nil:
+		silence.EndsAt = *req.EndsAt
+	case req.Duration != "":
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid duration %q", req.Duration),
+			})
+		}
+		start := silence.StartsAt
+		if start.IsZero() {
+			start = time.Now()
+		}
+		silence.EndsAt = start.Add(duration)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "ends_at or duration is required",
+		})
+	}
+
+	silence, err := h.engine.AddSilence(silence)
+	if err != nil {
+		if errors.Is(err, alerting.ErrInvalidSilence) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to add silence: %v", err),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(silenceResponse(silence))
+}
+
+// DeleteSilence handles DELETE /alerts/silences/{id}
+func (h *AlertsHandler) DeleteSilence(c *fiber.Ctx) error {
+	if err := h.engine.RemoveSilence(c.Params("id")); err != nil {
+		if errors.Is(err, alerting.ErrSilenceNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+				Msg: "Silence not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to remove silence: %v", err),
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// silenceResponse converts a silence to the API representation
+func silenceResponse(silence alerting.Silence) models.SilenceResponse {
+	response := models.SilenceResponse{
+		ID:       silence.ID,
+		Rule:     silence.Rule,
+		DeviceID: silence.DeviceID,
+		Comment:  silence.Comment,
+	}
+	if !silence.StartsAt.IsZero() {
+		response.StartsAt = &silence.StartsAt
+	}
+	if !silence.EndsAt.IsZero() {
+		response.EndsAt = &silence.EndsAt
+	}
+	return response
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/alerting"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestAlertsEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	store.AddHeartbeat("device-1", time.Now().Add(-time.Hour))
+
+	rule, err := alerting.ParseRule("stale", "no heartbeat for 10m")
+	if err != nil {
+		t.Fatalf("ParseRule failed: %v", err)
+	}
+	engine := alerting.NewEngine(store, alerting.Config{Rules: []alerting.Rule{rule}})
+	if err := engine.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+
+	handler := NewAlertsHandler(engine)
+	admin := NewAdminAuth("admin-token")
+	app := fiber.New()
+	app.Get("/alerts", handler.ListAlerts)
+	app.Get("/alerts/silences", handler.ListSilences)
+	app.Post("/alerts/silences", admin.Middleware(), handler.PostSilence)
+	app.Delete("/alerts/silences/:id", admin.Middleware(), handler.DeleteSilence)
+
+	authorization := "Bearer admin-token"
+	request := func(method, target, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if authorization != "" {
+			req.Header.Set(fiber.HeaderAuthorization, authorization)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, data
+	}
+
+	testCases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "Silence with duration", body: `{"rule":"stale","device_id":"device-1","duration":"2h","comment":"maintenance"}`, status: fiber.StatusCreated},
+		{name: "Unknown rule", body: `{"rule":"missing","duration":"2h"}`, status: fiber.StatusBadRequest},
+		{name: "No end", body: `{"rule":"stale"}`, status: fiber.StatusBadRequest},
+		{name: "Both end and duration", body: `{"duration":"2h","ends_at":"2099-01-01T00:00:00Z"}`, status: fiber.StatusBadRequest},
+		{name: "Invalid duration", body: `{"duration":"soon"}`, status: fiber.StatusBadRequest},
+		{name: "Already ended", body: `{"ends_at":"2000-01-01T00:00:00Z"}`, status: fiber.StatusBadRequest},
+		{name: "Invalid body", body: `{`, status: fiber.StatusBadRequest},
+	}
+	var silence models.SilenceResponse
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := request("POST", "/alerts/silences", tc.body)
+			if status != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+			if status == fiber.StatusCreated {
+				if err := json.Unmarshal(body, &silence); err != nil {
+					t.Fatalf("Failed to parse response: %v", err)
+				}
+			}
+		})
+	}
+	if silence.ID == "" || silence.EndsAt == nil || silence.Comment != "maintenance" {
+		t.Fatalf("Unexpected silence %+v", silence)
+	}
+
+	// Silences are managed by an admin only, anyone may list them
+	authorization = ""
+	if status, body := request("POST", "/alerts/silences", `{"duration":"2h"}`); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected 401 silencing without the admin token, got %d: %s", status, body)
+	}
+	if status, body := request("DELETE", "/alerts/silences/"+silence.ID, ""); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected 401 removing a silence without the admin token, got %d: %s", status, body)
+	}
+
+	_, body := request("GET", "/alerts", "")
+	var alerts []models.AlertResponse
+	if err := json.Unmarshal(body, &alerts); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].Rule != "stale" || alerts[0].State != alerting.StateFiring || !alerts[0].Silenced {
+		t.Errorf("Expected a silenced firing alert, got %s", body)
+	}
+
+	_, body = request("GET", "/alerts/silences", "")
+	var silences []models.SilenceResponse
+	if err := json.Unmarshal(body, &silences); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(silences) != 1 || silences[0].ID != silence.ID {
+		t.Errorf("Expected the new silence to be listed, got %s", body)
+	}
+
+	authorization = "Bearer admin-token"
+	if status, _ := request("DELETE", "/alerts/silences/"+silence.ID, ""); status != fiber.StatusNoContent {
+		t.Errorf("Expected 204 removing the silence, got %d", status)
+	}
+	if status, _ := request("DELETE", "/alerts/silences/"+silence.ID, ""); status != fiber.StatusNotFound {
+		t.Errorf("Expected 404 removing the silence twice, got %d", status)
+	}
+}
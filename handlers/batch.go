@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+const (
+	maxBatchItems = 10000
+
+	batchAccepted = "accepted"
+	batchRejected = "rejected"
+
+	// msgSignatureRequired rejects reports of devices that must sign them
+	// on routes that cannot carry a device signature
+	msgSignatureRequired = "Device must sign its reports: post them to its own routes, signed with one of its keys"
+)
+
+// PostBatch handles POST /devices/{device_id}/batch
+func (h *DeviceHandler) PostBatch(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	var req models.BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Invalid request body",
+		})
+	}
+	if len(req.Items) > maxBatchItems {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid request body: at most %d items per batch", maxBatchItems),
+		})
+	}
+
+	// Validate device exists
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	response, err := h.storeBatch(deviceID, req.Items)
+	if err != nil && !errors.Is(err, storage.ErrDeviceNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to store batch: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// PostIngest handles POST /ingest. Items of devices that must sign their
+// reports are rejected unless the request carries the admin token.
+func (h *DeviceHandler) PostIngest(c *fiber.Ctx) error {
+	var req models.IngestRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Invalid request body",
+		})
+	}
+
+	total := 0
+	for _, items := range req.Devices {
+		total += len(items)
+	}
+	if total > maxBatchItems {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid request body: at most %d items per batch", maxBatchItems),
+		})
+	}
+
+	response := models.IngestResponse{
+		Devices: make(map[string]models.BatchResponse, len(req.Devices)),
+	}
+	admin := h.admin.Authorized(c)
+	for deviceID, items := range req.Devices {
+		if !admin && h.credentials.SignatureRequired(deviceID) {
+			result := rejectAll(items, msgSignatureRequired)
+			response.Devices[deviceID] = result
+			response.Rejected += result.Rejected
+			continue
+		}
+
+		result, err := h.storeBatch(deviceID, items)
+		if err != nil && !errors.Is(err, storage.ErrDeviceNotFound) {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Failed to store batch for device %s: %v", deviceID, err),
+			})
+		}
+		response.Devices[deviceID] = result
+		response.Accepted += result.Accepted
+		response.Rejected += result.Rejected
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// storeBatch validates the items of a batch and stores the valid ones for a
+// device in one call. If the device does not exist every item is rejected
+// and ErrDeviceNotFound is returned along with the response.
+func (h *DeviceHandler) storeBatch(deviceID string, items []models.BatchItem) (models.BatchResponse, error) {
+	response := models.BatchResponse{
+		Results: make([]models.BatchItemResult, len(items)),
+	}
+
+	var heartbeats []time.Time
+	var uploads []storage.UploadSample
+	for i, item := range items {
+		response.Results[i] = models.BatchItemResult{Index: i, Status: batchAccepted}
+		if err := item.Validate(); err != nil {
+			response.Results[i].Status = batchRejected
+			response.Results[i].Error = err.Error()
+			response.Rejected++
+			continue
+		}
+
+		if item.Type == models.ReportHeartbeat {
+			heartbeats = append(heartbeats, item.SentAt)
+		} else {
+			uploads = append(uploads, storage.UploadSample{SentAt: item.SentAt, UploadTime: *item.UploadTime})
+		}
+		response.Accepted++
+	}
+
+	if response.Accepted == 0 {
+		if !h.store.DeviceExists(deviceID) {
+			return rejectBatch(response, "Device not found"), storage.ErrDeviceNotFound
+		}
+		return response, nil
+	}
+
+	if err := h.store.AddBatch(deviceID, heartbeats, uploads); err != nil {
+		if errors.Is(err, storage.ErrDeviceNotFound) {
+			return rejectBatch(response, "Device not found"), err
+		}
+		return response, err
+	}
+	return response, nil
+}
+
+// rejectAll rejects every item of a batch for the same reason
+func rejectAll(items []models.BatchItem, reason string) models.BatchResponse {
+	response := models.BatchResponse{
+		Results:  make([]models.BatchItemResult, len(items)),
+		Rejected: len(items),
+		Error:    reason,
+	}
+	for i := range items {
+		response.Results[i] = models.BatchItemResult{Index: i, Status: batchRejected, Error: reason}
+	}
+	return response
+}
+
+// rejectBatch marks every item of a batch as rejected for the same reason
+func rejectBatch(response models.BatchResponse, reason string) models.BatchResponse {
+	response.Error = reason
+	response.Accepted = 0
+	response.Rejected = len(response.Results)
+	for i := range response.Results {
+		if response.Results[i].Status == batchAccepted {
+			response.Results[i].Status = batchRejected
+			response.Results[i].Error = reason
+		}
+	}
+	return response
+}
+
+// PostImport handles POST /import. The body is newline-delimited JSON and
+// is read as it arrives when the server streams request bodies. Records of
+// devices that must sign their reports are rejected unless the request
+// carries the admin token.
+func (h *DeviceHandler) PostImport(c *fiber.Ctx) error {
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+
+	credentials := h.credentials
+	if h.admin.Authorized(c) {
+		credentials = nil
+	}
+	result, err := storage.Import(h.store, body, credentials)
+	response := importResponse(result)
+	if err != nil {
+		response.Error = err.Error()
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, storage.ErrImportLineTooLong) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(response)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// importResponse converts an import result to the API representation
+func importResponse(result storage.ImportResult) models.ImportResponse {
+	response := models.ImportResponse{
+		Accepted:  result.Accepted,
+		Rejected:  result.Rejected,
+		Failures:  make([]models.ImportFailure, len(result.Failures)),
+		Truncated: result.Truncated,
+	}
+	for i, failure := range result.Failures {
+		response.Failures[i] = models.ImportFailure{Line: failure.Line, Error: failure.Error}
+	}
+	return response
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestBatchEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"dev-1", "dev-2"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+
+	handler := newTestDeviceHandler(store)
+	app := fiber.New()
+	app.Post("/devices/:device_id/batch", handler.PostBatch)
+	app.Post("/ingest", handler.PostIngest)
+
+	request := func(target, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, data
+	}
+
+	batchCases := []struct {
+		name     string
+		target   string
+		body     string
+		status   int
+		statuses []string
+	}{
+		{
+			name:   "Mixed batch",
+			target: "/devices/dev-1/batch",
+			body: `{"items": [
+				{"type": "heartbeat", "sent_at": "2025-01-01T00:00:00Z"},
+				{"type": "stats", "sent_at": "2025-01-01T00:00:00Z", "upload_time": 2000000000},
+				{"type": "heartbeat", "sent_at": "2025-01-01T00:01:00Z"}
+			]}`,
+			status:   fiber.StatusOK,
+			statuses: []string{"accepted", "accepted", "accepted"},
+		},
+		{
+			name:   "Invalid items are rejected individually",
+			target: "/devices/dev-1/batch",
+			body: `{"items": [
+				{"type": "heartbeat", "sent_at": "2025-01-01T00:02:00Z"},
+				{"type": "stats", "sent_at": "2025-01-01T00:02:00Z"},
+				{"type": "stats", "sent_at": "2025-01-01T00:02:00Z", "upload_time": -1},
+				{"type": "heartbeat"},
+				{"type": "reboot", "sent_at": "2025-01-01T00:02:00Z"}
+			]}`,
+			status:   fiber.StatusOK,
+			statuses: []string{"accepted", "rejected", "rejected", "rejected", "rejected"},
+		},
+		{name: "Unknown device", target: "/devices/unknown/batch", body: `{"items": []}`, status: fiber.StatusNotFound},
+		{name: "Invalid body", target: "/devices/dev-1/batch", body: `{"items": 1}`, status: fiber.StatusBadRequest},
+	}
+	for _, tc := range batchCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := request(tc.target, tc.body)
+			if status != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+			if tc.status != fiber.StatusOK {
+				return
+			}
+
+			var response models.BatchResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			statuses := make([]string, len(response.Results))
+			for i, result := range response.Results {
+				statuses[i] = result.Status
+				if result.Index != i || (result.Status == "rejected") != (result.Error != "") {
+					t.Errorf("Unexpected result %+v", result)
+				}
+			}
+			if !reflect.DeepEqual(statuses, tc.statuses) {
+				t.Errorf("Expected %v, got %v", tc.statuses, statuses)
+			}
+		})
+	}
+
+	data, err := store.GetDeviceData("dev-1")
+	if err != nil || len(data.Heartbeats) != 3 || len(data.Uploads) != 1 {
+		t.Fatalf("Expected 3 heartbeats and 1 upload, got %+v (err %v)", data, err)
+	}
+
+	t.Run("Fleet ingest", func(t *testing.T) {
+		status, body := request("/ingest", `{"devices": {
+			"dev-1": [{"type": "heartbeat", "sent_at": "2025-01-01T00:03:00Z"}],
+			"dev-2": [
+				{"type": "stats", "sent_at": "2025-01-01T00:00:00Z", "upload_time": 1000000000},
+				{"type": "stats", "sent_at": "2025-01-01T00:00:00Z"}
+			],
+			"unknown": [{"type": "heartbeat", "sent_at": "2025-01-01T00:00:00Z"}]
+		}}`)
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", status, body)
+		}
+
+		var response models.IngestResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Accepted != 2 || response.Rejected != 2 {
+			t.Errorf("Expected 2 accepted and 2 rejected, got %+v", response)
+		}
+		if unknown := response.Devices["unknown"]; unknown.Error != "Device not found" || unknown.Rejected != 1 {
+			t.Errorf("Expected the unknown device to be rejected, got %+v", unknown)
+		}
+
+		data, err := store.GetDeviceData("dev-2")
+		if err != nil || len(data.Uploads) != 1 {
+			t.Errorf("Expected 1 upload for dev-2, got %+v (err %v)", data, err)
+		}
+	})
+}
+
+func TestPostImport(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "dev-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+
+	handler := newTestDeviceHandler(store)
+	app := fiber.New()
+	app.Post("/import", handler.PostImport)
+
+	body := `{"device_id": "dev-1", "type": "heartbeat", "sent_at": "2025-01-01T00:00:00Z"}
+{"device_id": "dev-1", "type": "stats", "sent_at": "2025-01-01T00:00:00Z", "upload_time": 1000000000}
+{"device_id": "unknown", "type": "heartbeat", "sent_at": "2025-01-01T00:00:00Z"}
+`
+	resp, err := app.Test(httptest.NewRequest("POST", "/import", strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("POST /import failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	var response models.ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := models.ImportResponse{
+		Accepted: 2,
+		Rejected: 1,
+		Failures: []models.ImportFailure{{Line: 3, Error: "device not found"}},
+	}
+	if !reflect.DeepEqual(response, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, response)
+	}
+}
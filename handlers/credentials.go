@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// defaultKeyOverlap is how long older keys stay valid after a rotation
+const defaultKeyOverlap = 24 * time.Hour
+
+// PostDeviceKey handles POST /devices/{device_id}/keys
+func (h *DeviceHandler) PostDeviceKey(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	// The body is optional
+	var req models.RotateKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: "Invalid request body",
+			})
+		}
+	}
+	overlap := defaultKeyOverlap
+	if req.Overlap != "" {
+		var err error
+		if overlap, err = time.ParseDuration(req.Overlap); err != nil || overlap < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: "Invalid overlap: expected a non-negative duration",
+			})
+		}
+	}
+
+	// Validate device exists
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	key, err := h.credentials.Rotate(deviceID, overlap)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to generate key: %v", err),
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(keyResponse(key, true))
+}
+
+// ListDeviceKeys handles GET /devices/{device_id}/keys
+func (h *DeviceHandler) ListDeviceKeys(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	// Validate device exists
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	response := models.DeviceKeysResponse{Keys: make([]models.DeviceKey, 0)}
+	for _, key := range h.credentials.Keys(deviceID) {
+		response.Keys = append(response.Keys, *keyResponse(key, false))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// DeleteDeviceKey handles DELETE /devices/{device_id}/keys/{key_id}
+func (h *DeviceHandler) DeleteDeviceKey(c *fiber.Ctx) error {
+	if err := h.credentials.Revoke(c.Params("device_id"), c.Params("key_id")); err != nil {
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+				Msg: "Key not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to revoke key: %v", err),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// keyResponse converts a device key, with its secret if withSecret is set
+func keyResponse(key storage.DeviceKey, withSecret bool) *models.DeviceKey {
+	response := &models.DeviceKey{
+		KeyID:     key.ID,
+		CreatedAt: key.CreatedAt,
+	}
+	if withSecret {
+		response.Secret = key.Secret
+	}
+	if !key.ExpiresAt.IsZero() {
+		response.ExpiresAt = &key.ExpiresAt
+	}
+	return response
+}
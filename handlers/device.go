@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -12,13 +11,20 @@ import (
 
 // DeviceHandler handles device-related requests
 type DeviceHandler struct {
-	store *storage.DeviceStore
+	store       storage.DeviceStore
+	status      *storage.StatusTracker
+	credentials *storage.Credentials
+	admin       *AdminAuth
 }
 
-// NewDeviceHandler creates a new device handler
-func NewDeviceHandler(store *storage.DeviceStore) *DeviceHandler {
+// NewDeviceHandler creates a new device handler. Generating keys at
+// registration takes the admin token.
+func NewDeviceHandler(store storage.DeviceStore, status *storage.StatusTracker, credentials *storage.Credentials, admin *AdminAuth) *DeviceHandler {
 	return &DeviceHandler{
-		store: store,
+		store:       store,
+		status:      status,
+		credentials: credentials,
+		admin:       admin,
 	}
 }
 
@@ -32,6 +38,11 @@ func (h *DeviceHandler) PostHeartbeat(c *fiber.Ctx) error {
 			Msg: "Invalid request body",
 		})
 	}
+	if err := req.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid request body: %v", err),
+		})
+	}
 
 	// Validate device exists
 	if !h.store.DeviceExists(deviceID) {
@@ -60,6 +71,11 @@ func (h *DeviceHandler) PostStats(c *fiber.Ctx) error {
 			Msg: "Invalid request body",
 		})
 	}
+	if err := req.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid request body: %v", err),
+		})
+	}
 
 	// Validate device exists
 	if !h.store.DeviceExists(deviceID) {
@@ -69,7 +85,7 @@ func (h *DeviceHandler) PostStats(c *fiber.Ctx) error {
 	}
 
 	// Store upload time
-	if err := h.store.AddUploadTime(deviceID, req.UploadTime); err != nil {
+	if err := h.store.AddUploadTime(deviceID, req.SentAt, req.UploadTime); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Msg: fmt.Sprintf("Failed to store upload time: %v", err),
 		})
@@ -82,6 +98,14 @@ func (h *DeviceHandler) PostStats(c *fiber.Ctx) error {
 func (h *DeviceHandler) GetStats(c *fiber.Ctx) error {
 	deviceID := c.Params("device_id")
 
+	// Optional time window
+	window, err := parseTimeWindow(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: err.Error(),
+		})
+	}
+
 	// Validate device exists
 	if !h.store.DeviceExists(deviceID) {
 		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
@@ -89,8 +113,7 @@ func (h *DeviceHandler) GetStats(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get device data
-	deviceData, err := h.store.GetDeviceData(deviceID)
+	aggregates, err := storage.WindowAggregates(h.store, deviceID, window)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Msg: fmt.Sprintf("Failed to retrieve device data: %v", err),
@@ -98,15 +121,15 @@ func (h *DeviceHandler) GetStats(c *fiber.Ctx) error {
 	}
 
 	// If no data available yet, return 204
-	if len(deviceData.Heartbeats) == 0 && len(deviceData.UploadTimes) == 0 {
+	if aggregates.IsEmpty() {
 		return c.SendStatus(fiber.StatusNoContent)
 	}
 
 	// Calculate uptime
-	uptime := calculateUptime(deviceData.Heartbeats)
+	uptime := aggregates.Uptime()
 
 	// Calculate average upload time
-	avgUploadTime := calculateAvgUploadTime(deviceData.UploadTimes)
+	avgUploadTime := aggregates.AvgUploadTime().String()
 
 	response := models.GetDeviceStatsResponse{
 		AvgUploadTime: avgUploadTime,
@@ -119,55 +142,18 @@ func (h *DeviceHandler) GetStats(c *fiber.Ctx) error {
 // calculateUptime calculates device uptime percentage
 // uptime = (sumHeartbeats / numMinutesBetweenFirstAndLastHeartbeat) * 100
 func calculateUptime(heartbeats []time.Time) float64 {
-	if len(heartbeats) == 0 {
-		return 0.0
+	var aggregates storage.Aggregates
+	for _, heartbeat := range heartbeats {
+		aggregates.AddHeartbeat(heartbeat)
 	}
-
-	// Single heartbeat means 100% uptime
-	if len(heartbeats) == 1 {
-		return 100.0
-	}
-
-	// Sort heartbeats to find first and last
-	sortedHeartbeats := make([]time.Time, len(heartbeats))
-	copy(sortedHeartbeats, heartbeats)
-	sort.Slice(sortedHeartbeats, func(i, j int) bool {
-		return sortedHeartbeats[i].Before(sortedHeartbeats[j])
-	})
-
-	first := sortedHeartbeats[0]
-	last := sortedHeartbeats[len(sortedHeartbeats)-1]
-
-	// Calculate minutes between first and last heartbeat
-	duration := last.Sub(first)
-	minutes := duration.Minutes()
-
-	// If duration is less than a minute, consider it 100% uptime
-	if minutes < 1.0 {
-		return 100.0
-	}
-
-	// Calculate uptime percentage
-	numHeartbeats := float64(len(heartbeats))
-	uptime := (numHeartbeats / minutes) * 100.0
-
-	return uptime
+	return aggregates.Uptime()
 }
 
 // calculateAvgUploadTime calculates average upload time and formats as duration string
 func calculateAvgUploadTime(uploadTimes []int64) string {
-	if len(uploadTimes) == 0 {
-		return "0s"
+	var aggregates storage.Aggregates
+	for _, uploadTime := range uploadTimes {
+		aggregates.AddUpload(uploadTime)
 	}
-
-	// Calculate average
-	var sum int64
-	for _, t := range uploadTimes {
-		sum += t
-	}
-	avgNanos := sum / int64(len(uploadTimes))
-
-	// Convert to time.Duration and format
-	duration := time.Duration(avgNanos)
-	return duration.String()
+	return aggregates.AvgUploadTime().String()
 }
@@ -2,8 +2,15 @@ package handlers
 
 import (
 	"math"
+	"math/rand"
+	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
 )
 
 // ---------------------------------------
@@ -237,3 +244,127 @@ func TestCalculateAvgUploadTime(t *testing.T) {
 		})
 	}
 }
+
+// ---------------------------------------
+// Running aggregates
+// ---------------------------------------
+
+// recomputeUptime is the original full-recompute uptime calculation, kept as
+// a reference so running aggregates can be checked for identical results
+func recomputeUptime(heartbeats []time.Time) float64 {
+	if len(heartbeats) == 0 {
+		return 0.0
+	}
+	if len(heartbeats) == 1 {
+		return 100.0
+	}
+
+	sorted := make([]time.Time, len(heartbeats))
+	copy(sorted, heartbeats)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+
+	minutes := sorted[len(sorted)-1].Sub(sorted[0]).Minutes()
+	if minutes < 1.0 {
+		return 100.0
+	}
+	return (float64(len(heartbeats)) / minutes) * 100.0
+}
+
+func TestAggregatesMatchRecompute(t *testing.T) {
+	baseTime := time.Date(2025, 11, 19, 4, 22, 32, 0, time.UTC)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		// Roughly one heartbeat a minute with jitter, gaps and shuffling,
+		// like the device simulator produces
+		n := rng.Intn(500)
+		heartbeats := make([]time.Time, 0, n)
+		uploadTimes := make([]int64, 0, n)
+		for m := 0; m < n; m++ {
+			if rng.Intn(100) < 2 {
+				continue // missed heartbeat
+			}
+			jitter := time.Duration(rng.Int63n(int64(time.Second)))
+			heartbeats = append(heartbeats, baseTime.Add(time.Duration(m)*time.Minute+jitter))
+			uploadTimes = append(uploadTimes, rng.Int63n(int64(5*time.Minute)))
+		}
+		rng.Shuffle(len(heartbeats), func(a, b int) {
+			heartbeats[a], heartbeats[b] = heartbeats[b], heartbeats[a]
+		})
+
+		var aggregates storage.Aggregates
+		for _, heartbeat := range heartbeats {
+			aggregates.AddHeartbeat(heartbeat)
+		}
+		var sum int64
+		for _, uploadTime := range uploadTimes {
+			aggregates.AddUpload(uploadTime)
+			sum += uploadTime
+		}
+
+		if got, expected := aggregates.Uptime(), recomputeUptime(heartbeats); got != expected {
+			t.Fatalf("Run %d: expected uptime %v, got %v", i, expected, got)
+		}
+
+		expectedAvg := "0s"
+		if len(uploadTimes) > 0 {
+			expectedAvg = time.Duration(sum / int64(len(uploadTimes))).String()
+		}
+		if got := aggregates.AvgUploadTime().String(); got != expectedAvg {
+			t.Fatalf("Run %d: expected avg upload time %s, got %s", i, expectedAvg, got)
+		}
+	}
+}
+
+// ---------------------------------------
+// Report validation
+// ---------------------------------------
+
+func TestPostReportValidation(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "dev-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	handler := newTestDeviceHandler(store)
+	app := fiber.New()
+	app.Post("/devices/:device_id/heartbeat", handler.PostHeartbeat)
+	app.Post("/devices/:device_id/stats", handler.PostStats)
+	soon := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+
+	testCases := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{name: "Heartbeat", target: "/devices/dev-1/heartbeat", body: `{"sent_at":"2025-01-01T00:00:00Z"}`, status: fiber.StatusNoContent},
+		{name: "Heartbeat without sent_at", target: "/devices/dev-1/heartbeat", body: `{}`, status: fiber.StatusBadRequest},
+		{name: "Stats", target: "/devices/dev-1/stats", body: `{"sent_at":"2025-01-01T00:00:00Z","upload_time":1500}`, status: fiber.StatusNoContent},
+		{name: "Stats without sent_at", target: "/devices/dev-1/stats", body: `{"upload_time":1500}`, status: fiber.StatusBadRequest},
+		{name: "Negative upload time", target: "/devices/dev-1/stats", body: `{"sent_at":"2025-01-01T00:00:00Z","upload_time":-1}`, status: fiber.StatusBadRequest},
+		{name: "Heartbeat sent ahead within the skew", target: "/devices/dev-1/heartbeat", body: `{"sent_at":"` + soon + `"}`, status: fiber.StatusNoContent},
+		{name: "Heartbeat from the future", target: "/devices/dev-1/heartbeat", body: `{"sent_at":"2999-01-01T00:00:00Z"}`, status: fiber.StatusBadRequest},
+		{name: "Stats from the future", target: "/devices/dev-1/stats", body: `{"sent_at":"2999-01-01T00:00:00Z","upload_time":1500}`, status: fiber.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("POST %s failed: %v", tc.target, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Errorf("Expected %d, got %d", tc.status, resp.StatusCode)
+			}
+		})
+	}
+
+	// Reports from the future were not stored, so they cannot keep the device online
+	if aggregates, err := store.GetAggregates("dev-1"); err != nil || aggregates.LastHeartbeat.After(time.Now().Add(time.Hour)) {
+		t.Errorf("Expected no heartbeat from the future, got %+v (%v)", aggregates, err)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/events"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// eventKeepalive is how often an idle event stream sends a comment, so
+// proxies keep it open and closed connections are noticed
+const eventKeepalive = 15 * time.Second
+
+// eventTypes are the event types a stream can be filtered by
+var eventTypes = []string{events.TypeHeartbeat, events.TypeStats, events.TypeStatus, events.TypeAlert}
+
+// EventsHandler streams device events as Server-Sent Events
+type EventsHandler struct {
+	store     storage.DeviceStore
+	bus       *events.Bus
+	keepalive time.Duration
+}
+
+// NewEventsHandler creates a new events handler
+func NewEventsHandler(store storage.DeviceStore, bus *events.Bus) *EventsHandler {
+	return &EventsHandler{
+		store:     store,
+		bus:       bus,
+		keepalive: eventKeepalive,
+	}
+}
+
+// eventFilter selects the events a stream receives; empty fields match
+// anything
+type eventFilter struct {
+	deviceIDs map[string]bool
+	types     map[string]bool
+	metadata  deviceFilter
+}
+
+// StreamEvents handles GET /events
+func (h *EventsHandler) StreamEvents(c *fiber.Ctx) error {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid 'type' query parameter: %v", err),
+		})
+	}
+	if value := c.Query("device_id"); value != "" {
+		filter.deviceIDs = make(map[string]bool)
+		for _, deviceID := range strings.Split(value, ",") {
+			filter.deviceIDs[strings.Clone(strings.TrimSpace(deviceID))] = true
+		}
+	}
+	return h.stream(c, filter)
+}
+
+// StreamDeviceEvents handles GET /devices/{device_id}/events
+func (h *EventsHandler) StreamDeviceEvents(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid 'type' query parameter: %v", err),
+		})
+	}
+	filter.deviceIDs = map[string]bool{strings.Clone(deviceID): true}
+	return h.stream(c, filter)
+}
+
+// parseEventFilter reads the type and metadata filters shared by both
+// streams. Values are copied as the stream outlives the request.
+func parseEventFilter(c *fiber.Ctx) (eventFilter, error) {
+	filter := eventFilter{
+		metadata: deviceFilter{
+			model:    strings.Clone(c.Query("model")),
+			firmware: strings.Clone(c.Query("firmware")),
+			site:     strings.Clone(c.Query("site")),
+			region:   strings.Clone(c.Query("region")),
+			owner:    strings.Clone(c.Query("owner")),
+			tag:      strings.Clone(c.Query("tag")),
+		},
+	}
+	if value := c.Query("type"); value != "" {
+		filter.types = make(map[string]bool)
+		for _, eventType := range strings.Split(value, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if !slices.Contains(eventTypes, eventType) {
+				return filter, fmt.Errorf("expected a comma-separated list of %s", strings.Join(eventTypes, ", "))
+			}
+			filter.types[strings.Clone(eventType)] = true
+		}
+	}
+	return filter, nil
+}
+
+// stream subscribes to the bus and writes matching events until the client
+// goes away, falls too far behind or the server shuts down. Clients resume
+// from the Last-Event-ID header, or the last_event_id query parameter.
+func (h *EventsHandler) stream(c *fiber.Ctx, filter eventFilter) error {
+	value := c.Get("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	var lastEventID uint64
+	if value != "" {
+		var err error
+		if lastEventID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: "Invalid Last-Event-ID: expected an event ID",
+			})
+		}
+	}
+
+	subscription, replay := h.bus.Subscribe(lastEventID, value != "")
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Status(fiber.StatusOK)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer subscription.Close()
+
+		// Send the headers right away
+		if _, err := w.WriteString(": connected\n\n"); err != nil {
+			return
+		}
+		for _, event := range replay {
+			if h.matches(filter, event) {
+				writeEvent(w, event)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		keepalive := time.NewTicker(h.keepalive)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case event, ok := <-subscription.Events():
+				if !ok {
+					return
+				}
+				if !h.matches(filter, event) {
+					continue
+				}
+				writeEvent(w, event)
+			case <-keepalive.C:
+				if _, err := w.WriteString(": keepalive\n\n"); err != nil {
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// matches reports whether an event passes the stream's filter
+func (h *EventsHandler) matches(filter eventFilter, event events.Event) bool {
+	if filter.types != nil && !filter.types[event.Type] {
+		return false
+	}
+	if filter.deviceIDs != nil && !filter.deviceIDs[event.DeviceID] {
+		return false
+	}
+	if filter.metadata != (deviceFilter{}) {
+		info, err := h.store.GetDeviceInfo(event.DeviceID)
+		if err != nil || !filter.metadata.matches(info.Metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeEvent writes an event in the text/event-stream format; write errors
+// surface on the next flush
+func writeEvent(w *bufio.Writer, event events.Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/events"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// readEvent reads the next event from a text/event-stream, skipping
+// comments, as "<id> <type> <data>"
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var fields []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && len(fields) > 0:
+			return strings.Join(fields, " ")
+		case line == "" || strings.HasPrefix(line, ":"):
+		default:
+			_, value, _ := strings.Cut(line, ": ")
+			fields = append(fields, value)
+		}
+	}
+}
+
+func TestEventStreams(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	bus := events.NewBus(100)
+	store := events.NewPublishingStore(storage.NewMemoryStore(), bus)
+	for _, info := range []storage.DeviceInfo{
+		{DeviceID: "device-1", Source: storage.SourceAPI, Metadata: storage.DeviceMetadata{Site: "lab"}},
+		{DeviceID: "device-2", Source: storage.SourceAPI, Metadata: storage.DeviceMetadata{Site: "field"}},
+	} {
+		if err := store.RegisterDevice(info); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+
+	handler := NewEventsHandler(store, bus)
+	handler.keepalive = 10 * time.Millisecond
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/events", handler.StreamEvents)
+	app.Get("/devices/:device_id/events", handler.StreamDeviceEvents)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer func() {
+		bus.Close()
+		_ = app.Shutdown()
+	}()
+	baseURL := "http://" + listener.Addr().String()
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	open := func(target, lastEventID string) (*http.Response, *bufio.Reader) {
+		t.Helper()
+		req, err := http.NewRequest("GET", baseURL+target, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", target, err)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	for _, target := range []string{"/devices/unknown/events", "/events?type=reboot", "/events?last_event_id=latest"} {
+		resp, _ := open(target, "")
+		resp.Body.Close()
+		if resp.StatusCode == fiber.StatusOK {
+			t.Errorf("Expected GET %s to fail, got %d", target, resp.StatusCode)
+		}
+	}
+
+	all, allEvents := open("/events", "")
+	defer all.Body.Close()
+	if contentType := all.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", contentType)
+	}
+	device, deviceEvents := open("/devices/device-2/events?type=stats", "")
+	defer device.Body.Close()
+	lab, labEvents := open("/events?site=lab", "")
+	defer lab.Body.Close()
+
+	// Idle streams get keepalives
+	for {
+		line, err := allEvents.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read keepalive: %v", err)
+		}
+		if line == ": keepalive\n" {
+			break
+		}
+	}
+
+	store.AddHeartbeat("device-2", baseTime)
+	store.AddUploadTime("device-2", baseTime, int64(time.Second))
+	store.AddHeartbeat("device-1", baseTime)
+
+	heartbeat2 := `1 heartbeat {"device_id":"device-2","sent_at":"2025-01-01T00:00:00Z","count":1}`
+	stats2 := `2 stats {"device_id":"device-2","sent_at":"2025-01-01T00:00:00Z","upload_time":"1s","count":1}`
+	heartbeat1 := `3 heartbeat {"device_id":"device-1","sent_at":"2025-01-01T00:00:00Z","count":1}`
+
+	for _, expected := range []string{heartbeat2, stats2, heartbeat1} {
+		if event := readEvent(t, allEvents); event != expected {
+			t.Errorf("Expected %s, got %s", expected, event)
+		}
+	}
+	if event := readEvent(t, deviceEvents); event != stats2 {
+		t.Errorf("Expected the device stream to only get %s, got %s", stats2, event)
+	}
+	if event := readEvent(t, labEvents); event != heartbeat1 {
+		t.Errorf("Expected the lab stream to only get %s, got %s", heartbeat1, event)
+	}
+
+	// Resume after the first event
+	resumed, resumedEvents := open("/events", "1")
+	defer resumed.Body.Close()
+	for _, expected := range []string{stats2, heartbeat1} {
+		if event := readEvent(t, resumedEvents); event != expected {
+			t.Errorf("Expected %s on resume, got %s", expected, event)
+		}
+	}
+}
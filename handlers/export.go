@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// Export formats
+const (
+	exportCSV    = "csv"
+	exportNDJSON = "ndjson"
+)
+
+// exportContentTypes maps the supported export formats to their MIME types
+var exportContentTypes = map[string]string{
+	exportCSV:    "text/csv; charset=utf-8",
+	exportNDJSON: "application/x-ndjson",
+}
+
+// ExportDevice handles GET /devices/{device_id}/export
+func (h *DeviceHandler) ExportDevice(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	format, window, err := parseExportQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: err.Error(),
+		})
+	}
+
+	data, err := h.store.GetDeviceData(deviceID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	streamExport(c, format, deviceID, func(w exportWriter) error {
+		return writeDeviceExport(w, deviceID, data, window)
+	})
+	return nil
+}
+
+// ExportFleet handles GET /fleet/export. Devices are read and written one
+// at a time, so only a single device's data is held in memory.
+func (h *FleetHandler) ExportFleet(c *fiber.Ctx) error {
+	format, window, err := parseExportQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: err.Error(),
+		})
+	}
+
+	devices := h.store.ListDevices()
+	streamExport(c, format, "fleet", func(w exportWriter) error {
+		for _, info := range devices {
+			if info.Retired() {
+				continue
+			}
+			data, err := h.store.GetDeviceData(info.DeviceID)
+			if errors.Is(err, storage.ErrDeviceNotFound) {
+				continue // deregistered since the export started
+			}
+			if err != nil {
+				return err
+			}
+			if err := writeDeviceExport(w, info.DeviceID, data, window); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// parseExportQuery reads the "format" query parameter, csv by default, and
+// the optional time window
+func parseExportQuery(c *fiber.Ctx) (string, storage.TimeWindow, error) {
+	format := c.Query("format", exportCSV)
+	if _, ok := exportContentTypes[format]; !ok {
+		return "", storage.TimeWindow{}, fmt.Errorf("Invalid 'format' query parameter: expected csv or ndjson")
+	}
+
+	window, err := parseTimeWindow(c)
+	return format, window, err
+}
+
+// streamExport sends the records written by fn as the response body, as an
+// attachment named after name. Records are flushed to the client as they
+// are written; an error after the first bytes are sent can only be logged.
+func streamExport(c *fiber.Ctx, format, name string, fn func(w exportWriter) error) {
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Set(fiber.HeaderContentType, exportContentTypes[format])
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(fiber.StatusOK)
+
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		w := newExportWriter(format, bw)
+		err := fn(w)
+		if err == nil {
+			err = w.flush()
+		}
+		if err != nil {
+			log.Printf("Export %s failed: %v", name, err)
+		}
+	})
+}
+
+// writeDeviceExport writes a device's heartbeats and uploads inside the
+// window, ordered by the time they were sent
+func writeDeviceExport(w exportWriter, deviceID string, data *storage.DeviceData, window storage.TimeWindow) error {
+	heartbeats := filterHeartbeats(data.Heartbeats, window)
+	uploads := filterUploads(data.Uploads, window)
+	sort.Slice(heartbeats, func(i, j int) bool { return heartbeats[i].Before(heartbeats[j]) })
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].SentAt.Before(uploads[j].SentAt) })
+
+	for len(heartbeats) > 0 || len(uploads) > 0 {
+		record := models.ExportRecord{DeviceID: deviceID}
+		if len(uploads) == 0 || (len(heartbeats) > 0 && !uploads[0].SentAt.Before(heartbeats[0])) {
+			record.Type = "heartbeat"
+			record.SentAt = heartbeats[0]
+			heartbeats = heartbeats[1:]
+		} else {
+			record.Type = "stats"
+			record.SentAt = uploads[0].SentAt
+			record.UploadTime = &uploads[0].UploadTime
+			uploads = uploads[1:]
+		}
+		if err := w.write(record); err != nil {
+			return err
+		}
+	}
+
+	// Push each device to the client rather than the whole export at the end
+	return w.flush()
+}
+
+// exportWriter encodes export records
+type exportWriter interface {
+	write(record models.ExportRecord) error
+	flush() error
+}
+
+// exportCSVHeader is the header row of CSV exports
+var exportCSVHeader = []string{"device_id", "type", "sent_at", "upload_time"}
+
+// newExportWriter creates a writer for the format, which must be supported
+func newExportWriter(format string, w *bufio.Writer) exportWriter {
+	if format == exportNDJSON {
+		return &ndjsonExportWriter{w: w, encoder: json.NewEncoder(w)}
+	}
+
+	// Write errors are kept by the csv.Writer and reported on flush
+	writer := csv.NewWriter(w)
+	_ = writer.Write(exportCSVHeader)
+	return &csvExportWriter{w: w, csv: writer}
+}
+
+// csvExportWriter writes records as CSV rows under a header row
+type csvExportWriter struct {
+	w   *bufio.Writer
+	csv *csv.Writer
+}
+
+func (e *csvExportWriter) write(record models.ExportRecord) error {
+	uploadTime := ""
+	if record.UploadTime != nil {
+		uploadTime = strconv.FormatInt(*record.UploadTime, 10)
+	}
+	return e.csv.Write([]string{record.DeviceID, record.Type, record.SentAt.Format(time.RFC3339Nano), uploadTime})
+}
+
+func (e *csvExportWriter) flush() error {
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// ndjsonExportWriter writes records as newline-delimited JSON, in the
+// format accepted by POST /import
+type ndjsonExportWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (e *ndjsonExportWriter) write(record models.ExportRecord) error {
+	return e.encoder.Encode(record)
+}
+
+func (e *ndjsonExportWriter) flush() error {
+	return e.w.Flush()
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestExport(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"dev-1", "dev-2", "dev-3"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	// Out of order on purpose: records are exported by time
+	store.AddHeartbeat("dev-1", baseTime.Add(time.Minute))
+	store.AddHeartbeat("dev-1", baseTime)
+	store.AddUploadTime("dev-1", baseTime.Add(30*time.Second), int64(2*time.Second))
+	store.AddHeartbeat("dev-2", baseTime)
+	store.AddHeartbeat("dev-3", baseTime)
+	if err := store.DeregisterDevice("dev-3", true); err != nil {
+		t.Fatalf("DeregisterDevice failed: %v", err)
+	}
+
+	deviceHandler := newTestDeviceHandler(store)
+	fleetHandler := NewFleetHandler(store)
+	app := fiber.New()
+	app.Get("/devices/:device_id/export", deviceHandler.ExportDevice)
+	app.Get("/fleet/export", fleetHandler.ExportFleet)
+
+	testCases := []struct {
+		name        string
+		target      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "Device CSV",
+			target:      "/devices/dev-1/export",
+			status:      fiber.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body: `device_id,type,sent_at,upload_time
+dev-1,heartbeat,2025-01-01T00:00:00Z,
+dev-1,stats,2025-01-01T00:00:30Z,2000000000
+dev-1,heartbeat,2025-01-01T00:01:00Z,
+`,
+		},
+		{
+			name:        "Device NDJSON in a time window",
+			target:      "/devices/dev-1/export?format=ndjson&from=2025-01-01T00:00:10Z",
+			status:      fiber.StatusOK,
+			contentType: "application/x-ndjson",
+			body: `{"device_id":"dev-1","type":"stats","sent_at":"2025-01-01T00:00:30Z","upload_time":2000000000}
+{"device_id":"dev-1","type":"heartbeat","sent_at":"2025-01-01T00:01:00Z"}
+`,
+		},
+		{
+			name:        "Fleet skips retired devices",
+			target:      "/fleet/export?format=ndjson&to=2025-01-01T00:00:10Z",
+			status:      fiber.StatusOK,
+			contentType: "application/x-ndjson",
+			body: `{"device_id":"dev-1","type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}
+{"device_id":"dev-2","type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}
+`,
+		},
+		{
+			name:        "Empty CSV keeps the header",
+			target:      "/fleet/export?from=2026-01-01T00:00:00Z",
+			status:      fiber.StatusOK,
+			contentType: "text/csv; charset=utf-8",
+			body:        "device_id,type,sent_at,upload_time\n",
+		},
+		{name: "Invalid format", target: "/fleet/export?format=parquet", status: fiber.StatusBadRequest},
+		{name: "Invalid window", target: "/devices/dev-1/export?from=yesterday", status: fiber.StatusBadRequest},
+		{name: "Unknown device", target: "/devices/unknown/export", status: fiber.StatusNotFound},
+		{name: "Retired device", target: "/devices/dev-3/export", status: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tc.target, nil))
+			if err != nil {
+				t.Fatalf("GET %s failed: %v", tc.target, err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if resp.StatusCode != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, resp.StatusCode, body)
+			}
+			if tc.status != fiber.StatusOK {
+				return
+			}
+
+			if contentType := resp.Header.Get(fiber.HeaderContentType); contentType != tc.contentType {
+				t.Errorf("Expected content type %q, got %q", tc.contentType, contentType)
+			}
+			if string(body) != tc.body {
+				t.Errorf("Expected body:\n%s\ngot:\n%s", tc.body, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// FleetHandler handles fleet-wide requests
+type FleetHandler struct {
+	store storage.DeviceStore
+}
+
+// NewFleetHandler creates a new fleet handler
+func NewFleetHandler(store storage.DeviceStore) *FleetHandler {
+	return &FleetHandler{
+		store: store,
+	}
+}
+
+// fleetGroupKeys maps the supported group_by values to the metadata they
+// group on
+var fleetGroupKeys = map[string]func(storage.DeviceMetadata) string{
+	"site":     func(m storage.DeviceMetadata) string { return m.Site },
+	"model":    func(m storage.DeviceMetadata) string { return m.Model },
+	"firmware": func(m storage.DeviceMetadata) string { return m.Firmware },
+	"region":   func(m storage.DeviceMetadata) string { return m.Region },
+	"owner":    func(m storage.DeviceMetadata) string { return m.Owner },
+}
+
+// GetFleetStats handles GET /fleet/stats
+func (h *FleetHandler) GetFleetStats(c *fiber.Ctx) error {
+	// Optional time window
+	window, err := parseTimeWindow(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: err.Error(),
+		})
+	}
+
+	groupBy := c.Query("group_by")
+	groupKey, ok := fleetGroupKeys[groupBy]
+	if groupBy != "" && !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Invalid 'group_by' query parameter: expected site, model, firmware, region or owner",
+		})
+	}
+
+	var fleet fleetStats
+	groups := make(map[string]*fleetStats)
+	for _, info := range h.store.ListDevices() {
+		if info.Retired() {
+			continue
+		}
+
+		aggregates, err := storage.WindowAggregates(h.store, info.DeviceID, window)
+		if err != nil {
+			// Deregistered since it was listed
+			continue
+		}
+
+		fleet.add(aggregates)
+		if groupKey != nil {
+			key := groupKey(info.Metadata)
+			if groups[key] == nil {
+				groups[key] = &fleetStats{}
+			}
+			groups[key].add(aggregates)
+		}
+	}
+
+	response := models.GetFleetStatsResponse{
+		FleetStats: fleet.summary(),
+	}
+	if groupKey != nil {
+		response.GroupBy = groupBy
+		response.Groups = make([]models.FleetStatsGroup, 0, len(groups))
+		for key, group := range groups {
+			response.Groups = append(response.Groups, models.FleetStatsGroup{
+				Key:        key,
+				FleetStats: group.summary(),
+			})
+		}
+		sort.Slice(response.Groups, func(i, j int) bool {
+			return response.Groups[i].Key < response.Groups[j].Key
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// fleetStats accumulates the statistics of a set of devices
+type fleetStats struct {
+	devices int
+	noData  int
+	uptimes []float64
+	uploads storage.Aggregates // upload totals across devices
+}
+
+// add includes a device in the statistics. Devices with data contribute
+// the same uptime GetStats reports for them.
+func (s *fleetStats) add(aggregates storage.Aggregates) {
+	s.devices++
+	if aggregates.IsEmpty() {
+		s.noData++
+		return
+	}
+
+	s.uptimes = append(s.uptimes, aggregates.Uptime())
+	s.uploads.UploadCount += aggregates.UploadCount
+	s.uploads.UploadSum += aggregates.UploadSum
+}
+
+// summary converts the accumulated statistics to the API representation
+func (s *fleetStats) summary() models.FleetStats {
+	stats := models.FleetStats{
+		Devices:       s.devices,
+		NoData:        s.noData,
+		AvgUploadTime: s.uploads.AvgUploadTime().String(),
+	}
+	if len(s.uptimes) == 0 {
+		return stats
+	}
+
+	sort.Float64s(s.uptimes)
+	sum := 0.0
+	for _, uptime := range s.uptimes {
+		sum += uptime
+	}
+	stats.Uptime = &models.UptimeDistribution{
+		Mean: sum / float64(len(s.uptimes)),
+		P50:  percentile(s.uptimes, 50),
+		P90:  percentile(s.uptimes, 90),
+		P99:  percentile(s.uptimes, 99),
+		Min:  s.uptimes[0],
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p (0-100] of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+	testCases := []struct {
+		name     string
+		values   []float64
+		p        float64
+		expected float64
+	}{
+		{name: "Median", values: values, p: 50, expected: 50},
+		{name: "P90", values: values, p: 90, expected: 90},
+		{name: "P99", values: values, p: 99, expected: 100},
+		{name: "Single value", values: []float64{42}, p: 99, expected: 42},
+		{name: "No values", values: nil, p: 50, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := percentile(tc.values, tc.p); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGetFleetStats(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := storage.NewMemoryStore()
+
+	devices := []struct {
+		id         string
+		site       string
+		heartbeats int // one per minute
+		minutes    int // span between first and last heartbeat
+		uploads    []time.Duration
+	}{
+		{id: "dev-1", site: "lab", heartbeats: 11, minutes: 10, uploads: []time.Duration{2 * time.Second}},
+		{id: "dev-2", site: "lab", heartbeats: 6, minutes: 10, uploads: []time.Duration{4 * time.Second, 6 * time.Second}},
+		{id: "dev-3", site: "field", heartbeats: 1},
+		{id: "dev-4", site: "field"},
+	}
+	for _, device := range devices {
+		info := storage.DeviceInfo{DeviceID: device.id, Source: storage.SourceAPI, Metadata: storage.DeviceMetadata{Site: device.site}}
+		if err := store.RegisterDevice(info); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+		for i := 0; i < device.heartbeats; i++ {
+			offset := 0
+			if device.heartbeats > 1 {
+				offset = i * device.minutes / (device.heartbeats - 1)
+			}
+			_ = store.AddHeartbeat(device.id, baseTime.Add(time.Duration(offset)*time.Minute))
+		}
+		for _, upload := range device.uploads {
+			_ = store.AddUploadTime(device.id, baseTime, upload.Nanoseconds())
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/fleet/stats", NewFleetHandler(store).GetFleetStats)
+
+	get := func(query string) (int, models.GetFleetStatsResponse) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest("GET", "/fleet/stats"+query, nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var response models.GetFleetStatsResponse
+		if resp.StatusCode == fiber.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return resp.StatusCode, response
+	}
+
+	status, response := get("")
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if response.Devices != 4 || response.NoData != 1 {
+		t.Errorf("Unexpected device counts %+v", response.FleetStats)
+	}
+	// Uptimes: dev-1 110%, dev-2 60%, dev-3 100%
+	expected := models.UptimeDistribution{Mean: 90, P50: 100, P90: 110, P99: 110, Min: 60}
+	if response.Uptime == nil {
+		t.Fatal("Expected uptime distribution")
+	}
+	got := *response.Uptime
+	for _, pair := range [][2]float64{
+		{got.Mean, expected.Mean}, {got.P50, expected.P50}, {got.P90, expected.P90},
+		{got.P99, expected.P99}, {got.Min, expected.Min},
+	} {
+		if math.Abs(pair[0]-pair[1]) > 0.001 {
+			t.Errorf("Expected uptime %+v, got %+v", expected, got)
+			break
+		}
+	}
+	if response.AvgUploadTime != "4s" {
+		t.Errorf("Expected avg upload time 4s, got %s", response.AvgUploadTime)
+	}
+	if response.Groups != nil {
+		t.Errorf("Expected no groups, got %+v", response.Groups)
+	}
+
+	status, response = get("?group_by=site")
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if len(response.Groups) != 2 || response.Groups[0].Key != "field" || response.Groups[1].Key != "lab" {
+		t.Fatalf("Unexpected groups %+v", response.Groups)
+	}
+	field := response.Groups[0]
+	if field.Devices != 2 || field.NoData != 1 || field.Uptime == nil || field.Uptime.Min != 100 || field.AvgUploadTime != "0s" {
+		t.Errorf("Unexpected field group %+v", field)
+	}
+
+	if status, _ := get("?group_by=color"); status != fiber.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown group_by, got %d", status)
+	}
+
+	// A window before any sample leaves every device without data
+	status, response = get("?to=2024-01-01T00:00:00Z")
+	if status != fiber.StatusOK || response.NoData != 4 || response.Uptime != nil {
+		t.Errorf("Unexpected windowed response %d %+v", status, response.FleetStats)
+	}
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+const (
+	maxMetricBatchSize  = 10000
+	maxMetricUnitLength = 64
+)
+
+// metricNamePattern restricts metric names to characters that are safe in
+// a URL path segment
+var metricNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]{0,127}$`)
+
+// MetricsHandler handles generic device metrics
+type MetricsHandler struct {
+	store   storage.DeviceStore
+	metrics *storage.MetricRegistry
+}
+
+// NewMetricsHandler creates a new metrics handler
+func NewMetricsHandler(store storage.DeviceStore, metrics *storage.MetricRegistry) *MetricsHandler {
+	return &MetricsHandler{
+		store:   store,
+		metrics: metrics,
+	}
+}
+
+// PostMetrics handles POST /devices/{device_id}/metrics
+func (h *MetricsHandler) PostMetrics(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	var req models.PostMetricsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Invalid request body",
+		})
+	}
+	if len(req.Samples) == 0 || len(req.Samples) > maxMetricBatchSize {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Invalid request body: expected between 1 and %d samples", maxMetricBatchSize),
+		})
+	}
+
+	receivedAt := time.Now().UTC()
+	samples := make([]storage.MetricSample, len(req.Samples))
+	for i, sample := range req.Samples {
+		var err error
+		if samples[i], err = h.metricSample(sample, receivedAt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid sample %d: %v", i, err),
+			})
+		}
+	}
+
+	// Validate device exists
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	if err := h.store.AddMetrics(deviceID, samples); err != nil {
+		if errors.Is(err, storage.ErrMetricTypeMismatch) {
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+				Msg: err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to store metrics: %v", err),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// metricSample validates a sample and fills in its type and unit from the
+// metric's definition
+func (h *MetricsHandler) metricSample(sample models.MetricSample, receivedAt time.Time) (storage.MetricSample, error) {
+	if !metricNamePattern.MatchString(sample.Name) {
+		return storage.MetricSample{}, fmt.Errorf("invalid metric name %q", sample.Name)
+	}
+	if len(sample.Unit) > maxMetricUnitLength {
+		return storage.MetricSample{}, fmt.Errorf("unit must be at most %d bytes", maxMetricUnitLength)
+	}
+
+	definition, defined := h.metrics.Lookup(sample.Name)
+	if sample.Type == "" && defined {
+		sample.Type = string(definition.Type)
+	}
+	metricType, err := storage.ParseMetricType(sample.Type)
+	if err != nil {
+		return storage.MetricSample{}, err
+	}
+
+	if defined {
+		if metricType != definition.Type {
+			return storage.MetricSample{}, fmt.Errorf("%s is configured as a %s", sample.Name, definition.Type)
+		}
+		if sample.Unit == "" {
+			sample.Unit = definition.Unit
+		}
+		if definition.Unit != "" && sample.Unit != definition.Unit {
+			return storage.MetricSample{}, fmt.Errorf("%s is configured with unit %q", sample.Name, definition.Unit)
+		}
+	}
+
+	if sample.Timestamp.IsZero() {
+		sample.Timestamp = receivedAt
+	}
+
+	return storage.MetricSample{
+		Name:      sample.Name,
+		Type:      metricType,
+		Unit:      sample.Unit,
+		Timestamp: sample.Timestamp,
+		Value:     sample.Value,
+	}, nil
+}
+
+// GetMetric handles GET /devices/{device_id}/metrics/{name}
+func (h *MetricsHandler) GetMetric(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+	name := c.Params("name")
+
+	// Optional time window
+	window, err := parseTimeWindow(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: err.Error(),
+		})
+	}
+
+	var aggregation storage.Aggregation
+	if value := c.Query("aggregation"); value != "" {
+		if aggregation, err = storage.ParseAggregation(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid 'aggregation' query parameter: %v", err),
+			})
+		}
+	}
+
+	// Validate device exists
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	series, err := h.store.GetMetric(deviceID, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrMetricNotFound) || errors.Is(err, storage.ErrDeviceNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+				Msg: "Metric not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to retrieve metric: %v", err),
+		})
+	}
+
+	points := make([]storage.MetricPoint, 0, len(series.Points))
+	for _, point := range series.Points {
+		if window.Contains(point.Timestamp) {
+			points = append(points, point)
+		}
+	}
+
+	// If no samples in the window, return 204
+	if len(points) == 0 {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	if aggregation == "" {
+		aggregation = storage.DefaultAggregation(series.Type)
+		if definition, ok := h.metrics.Lookup(name); ok {
+			aggregation = definition.Aggregation
+		}
+	}
+
+	response := aggregateMetric(points)
+	response.Name = name
+	response.Type = string(series.Type)
+	response.Unit = series.Unit
+	response.Aggregation = string(aggregation)
+	response.Value = metricValue(response, aggregation)
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// aggregateMetric computes every aggregation of a non-empty set of points.
+// The latest sample is the one with the latest timestamp, whatever order
+// the samples arrived in.
+func aggregateMetric(points []storage.MetricPoint) models.GetMetricResponse {
+	response := models.GetMetricResponse{
+		Count: len(points),
+		Min:   math.Inf(1),
+		Max:   math.Inf(-1),
+	}
+
+	values := make([]float64, len(points))
+	first := points[0].Timestamp
+	for i, point := range points {
+		values[i] = point.Value
+		response.Sum += point.Value
+		response.Min = min(response.Min, point.Value)
+		response.Max = max(response.Max, point.Value)
+		if i == 0 || !point.Timestamp.Before(response.LastTimestamp) {
+			response.Last = point.Value
+			response.LastTimestamp = point.Timestamp
+		}
+		if point.Timestamp.Before(first) {
+			first = point.Timestamp
+		}
+	}
+	response.Avg = response.Sum / float64(len(points))
+	if seconds := response.LastTimestamp.Sub(first).Seconds(); seconds > 0 {
+		response.Rate = response.Sum / seconds
+	}
+
+	sort.Float64s(values)
+	response.P50 = percentile(values, 50)
+	response.P90 = percentile(values, 90)
+	response.P99 = percentile(values, 99)
+	return response
+}
+
+// metricValue picks the value of an aggregation from the computed ones
+func metricValue(response models.GetMetricResponse, aggregation storage.Aggregation) float64 {
+	switch aggregation {
+	case storage.AggregationSum:
+		return response.Sum
+	case storage.AggregationMin:
+		return response.Min
+	case storage.AggregationMax:
+		return response.Max
+	case storage.AggregationLast:
+		return response.Last
+	case storage.AggregationCount:
+		return float64(response.Count)
+	case storage.AggregationRate:
+		return response.Rate
+	case storage.AggregationP50:
+		return response.P50
+	case storage.AggregationP90:
+		return response.P90
+	case storage.AggregationP99:
+		return response.P99
+	default:
+		return response.Avg
+	}
+}
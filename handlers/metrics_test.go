@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestAggregateMetric(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Out of order on purpose: the latest sample is not the last one added
+	points := []storage.MetricPoint{
+		{Timestamp: baseTime.Add(10 * time.Second), Value: 4},
+		{Timestamp: baseTime, Value: 2},
+		{Timestamp: baseTime.Add(5 * time.Second), Value: 6},
+	}
+
+	response := aggregateMetric(points)
+	expected := models.GetMetricResponse{
+		Count:         3,
+		Sum:           12,
+		Min:           2,
+		Max:           6,
+		Avg:           4,
+		Last:          4,
+		LastTimestamp: baseTime.Add(10 * time.Second),
+		Rate:          1.2,
+		P50:           4,
+		P90:           6,
+		P99:           6,
+	}
+	if response != expected {
+		t.Errorf("Expected %+v, got %+v", expected, response)
+	}
+
+	testCases := []struct {
+		aggregation storage.Aggregation
+		expected    float64
+	}{
+		{aggregation: storage.AggregationAvg, expected: 4},
+		{aggregation: storage.AggregationSum, expected: 12},
+		{aggregation: storage.AggregationMin, expected: 2},
+		{aggregation: storage.AggregationMax, expected: 6},
+		{aggregation: storage.AggregationLast, expected: 4},
+		{aggregation: storage.AggregationCount, expected: 3},
+		{aggregation: storage.AggregationRate, expected: 1.2},
+		{aggregation: storage.AggregationP90, expected: 6},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.aggregation), func(t *testing.T) {
+			if got := metricValue(response, tc.aggregation); got != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoints(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "dev-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	metrics, err := storage.NewMetricRegistry(storage.MetricDefinition{
+		Name:        "errors",
+		Type:        storage.MetricCounter,
+		Unit:        "count",
+		Aggregation: storage.AggregationMax,
+	})
+	if err != nil {
+		t.Fatalf("NewMetricRegistry failed: %v", err)
+	}
+
+	handler := NewMetricsHandler(store, metrics)
+	app := fiber.New()
+	app.Post("/devices/:device_id/metrics", handler.PostMetrics)
+	app.Get("/devices/:device_id/metrics/:name", handler.GetMetric)
+
+	request := func(method, target, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, data
+	}
+
+	postCases := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{
+			name:   "Valid batch",
+			target: "/devices/dev-1/metrics",
+			body: `{"samples": [
+				{"name": "cpu", "type": "gauge", "unit": "percent", "timestamp": "2025-01-01T00:00:00Z", "value": 10},
+				{"name": "cpu", "type": "gauge", "unit": "percent", "timestamp": "2025-01-01T00:01:00Z", "value": 30},
+				{"name": "errors", "timestamp": "2025-01-01T00:00:00Z", "value": 2},
+				{"name": "errors", "timestamp": "2025-01-01T00:01:00Z", "value": 5}
+			]}`,
+			status: fiber.StatusNoContent,
+		},
+		{name: "Empty batch", target: "/devices/dev-1/metrics", body: `{"samples": []}`, status: fiber.StatusBadRequest},
+		{name: "Missing type", target: "/devices/dev-1/metrics", body: `{"samples": [{"name": "temp", "value": 1}]}`, status: fiber.StatusBadRequest},
+		{name: "Invalid name", target: "/devices/dev-1/metrics", body: `{"samples": [{"name": "a/b", "type": "gauge"}]}`, status: fiber.StatusBadRequest},
+		{name: "Configured type differs", target: "/devices/dev-1/metrics", body: `{"samples": [{"name": "errors", "type": "gauge"}]}`, status: fiber.StatusBadRequest},
+		{name: "Configured unit differs", target: "/devices/dev-1/metrics", body: `{"samples": [{"name": "errors", "unit": "percent"}]}`, status: fiber.StatusBadRequest},
+		{name: "Stored type differs", target: "/devices/dev-1/metrics", body: `{"samples": [{"name": "cpu", "type": "counter", "unit": "percent"}]}`, status: fiber.StatusConflict},
+		{name: "Unknown device", target: "/devices/unknown/metrics", body: `{"samples": [{"name": "cpu", "type": "gauge"}]}`, status: fiber.StatusNotFound},
+	}
+	for _, tc := range postCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, body := request("POST", tc.target, tc.body); status != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+		})
+	}
+
+	getCases := []struct {
+		name        string
+		target      string
+		status      int
+		aggregation string
+		value       float64
+		unit        string
+	}{
+		{name: "Default aggregation for a gauge", target: "/devices/dev-1/metrics/cpu", status: fiber.StatusOK, aggregation: "avg", value: 20, unit: "percent"},
+		{name: "Configured aggregation", target: "/devices/dev-1/metrics/errors", status: fiber.StatusOK, aggregation: "max", value: 5, unit: "count"},
+		{name: "Requested aggregation", target: "/devices/dev-1/metrics/errors?aggregation=sum", status: fiber.StatusOK, aggregation: "sum", value: 7, unit: "count"},
+		{name: "Time window", target: "/devices/dev-1/metrics/cpu?from=2025-01-01T00:00:30Z", status: fiber.StatusOK, aggregation: "avg", value: 30, unit: "percent"},
+		{name: "No samples in window", target: "/devices/dev-1/metrics/cpu?to=2024-01-01T00:00:00Z", status: fiber.StatusNoContent},
+		{name: "Invalid aggregation", target: "/devices/dev-1/metrics/cpu?aggregation=median", status: fiber.StatusBadRequest},
+		{name: "Unknown metric", target: "/devices/dev-1/metrics/memory", status: fiber.StatusNotFound},
+		{name: "Unknown device", target: "/devices/unknown/metrics/cpu", status: fiber.StatusNotFound},
+	}
+	for _, tc := range getCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body := request("GET", tc.target, "")
+			if status != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+			if tc.status != fiber.StatusOK {
+				return
+			}
+
+			var response models.GetMetricResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Aggregation != tc.aggregation || response.Value != tc.value || response.Unit != tc.unit {
+				t.Errorf("Unexpected response %+v", response)
+			}
+		})
+	}
+}
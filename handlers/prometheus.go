@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// prometheusContentType is the content type of the text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// unmatchedRoute labels requests that matched no route, so that arbitrary
+// paths do not create new series
+const unmatchedRoute = "unmatched"
+
+// latencyBuckets are the upper bounds of the request latency histogram,
+// in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PrometheusOptions limits the number of per-device series, which grows
+// with the fleet
+type PrometheusOptions struct {
+	// DeviceLimit is the most devices to export series for, picking the
+	// ones whose last heartbeat is oldest; 0 exports none
+	DeviceLimit int
+	// DeviceTag, if set, only exports series for devices with this tag
+	DeviceTag string
+	// Listeners report the message counters of non-HTTP listeners, keyed
+	// by listener name, e.g. "udp"
+	Listeners map[string]ListenerCounters
+}
+
+// ListenerCounters returns how many messages a listener handled, by outcome
+type ListenerCounters func() map[string]int64
+
+// PrometheusHandler exposes fleet and HTTP metrics in the Prometheus text
+// exposition format
+type PrometheusHandler struct {
+	store   *storage.CountingStore
+	options PrometheusOptions
+	now     func() time.Time
+
+	unknownDevice atomic.Int64
+	badRequests   atomic.Int64
+
+	mu        sync.Mutex // guards latencies
+	latencies map[latencyKey]*latencyHistogram
+}
+
+// latencyKey identifies a latency histogram
+type latencyKey struct {
+	method string
+	route  string
+	code   int
+}
+
+// latencyHistogram counts request durations per bucket
+type latencyHistogram struct {
+	buckets []uint64 // per bucket, not cumulative
+	count   uint64
+	sum     float64
+}
+
+// NewPrometheusHandler creates a new Prometheus handler
+func NewPrometheusHandler(store *storage.CountingStore, options PrometheusOptions) *PrometheusHandler {
+	return &PrometheusHandler{
+		store:     store,
+		options:   options,
+		now:       time.Now,
+		latencies: make(map[latencyKey]*latencyHistogram),
+	}
+}
+
+// Middleware records the latency and outcome of every request
+func (h *PrometheusHandler) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		own := c.Route()
+		err := c.Next()
+		elapsed := time.Since(start).Seconds()
+
+		// Errors are turned into responses by the error handler later on
+		code := c.Response().StatusCode()
+		if err != nil {
+			code = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				code = fiberErr.Code
+			}
+		}
+
+		route := c.Route()
+		path := route.Path
+		if route == own {
+			path = unmatchedRoute
+		}
+
+		switch {
+		case code == fiber.StatusNotFound && slices.Contains(route.Params, "device_id") &&
+			!h.store.DeviceExists(c.Params("device_id")):
+			h.unknownDevice.Add(1)
+		case code == fiber.StatusBadRequest:
+			h.badRequests.Add(1)
+		}
+		// Fiber reuses the buffers behind request strings, so copy the labels
+		h.observe(latencyKey{method: strings.Clone(c.Method()), route: strings.Clone(path), code: code}, elapsed)
+
+		return err
+	}
+}
+
+// observe adds a request duration to its histogram
+func (h *PrometheusHandler) observe(key latencyKey, seconds float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	histogram, exists := h.latencies[key]
+	if !exists {
+		histogram = &latencyHistogram{buckets: make([]uint64, len(latencyBuckets))}
+		h.latencies[key] = histogram
+	}
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		histogram.buckets[i]++
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+// GetMetrics handles GET /metrics
+func (h *PrometheusHandler) GetMetrics(c *fiber.Ctx) error {
+	heartbeats, uploads := h.store.Counts()
+	devices, omitted := h.deviceSeries()
+
+	w := &strings.Builder{}
+
+	writeMetricHeader(w, "fleet_monitor_heartbeats_received_total", "counter", "Heartbeats accepted from devices.")
+	fmt.Fprintf(w, "fleet_monitor_heartbeats_received_total %d\n", heartbeats)
+	writeMetricHeader(w, "fleet_monitor_stats_received_total", "counter", "Upload stats accepted from devices.")
+	fmt.Fprintf(w, "fleet_monitor_stats_received_total %d\n", uploads)
+	writeMetricHeader(w, "fleet_monitor_unknown_device_requests_total", "counter", "Requests rejected with 404 because the device is unknown.")
+	fmt.Fprintf(w, "fleet_monitor_unknown_device_requests_total %d\n", h.unknownDevice.Load())
+	writeMetricHeader(w, "fleet_monitor_bad_requests_total", "counter", "Requests rejected with 400.")
+	fmt.Fprintf(w, "fleet_monitor_bad_requests_total %d\n", h.badRequests.Load())
+
+	if len(h.options.Listeners) > 0 {
+		writeMetricHeader(w, "fleet_monitor_listener_messages_total", "counter", "Messages received by non-HTTP listeners by outcome.")
+		for _, listener := range slices.Sorted(maps.Keys(h.options.Listeners)) {
+			counters := h.options.Listeners[listener]()
+			for _, outcome := range slices.Sorted(maps.Keys(counters)) {
+				fmt.Fprintf(w, "fleet_monitor_listener_messages_total{listener=\"%s\",outcome=\"%s\"} %d\n",
+					escapeLabel(listener), escapeLabel(outcome), counters[outcome])
+			}
+		}
+	}
+
+	h.writeLatencies(w)
+
+	writeMetricHeader(w, "fleet_monitor_device_series_omitted", "gauge", "Devices left out of the per-device series by the cardinality limits.")
+	fmt.Fprintf(w, "fleet_monitor_device_series_omitted %d\n", omitted)
+
+	writeMetricHeader(w, "fleet_monitor_device_uptime_percent", "gauge", "Device uptime from lifetime aggregates, including heartbeats pruned by retention.")
+	for _, device := range devices {
+		fmt.Fprintf(w, "fleet_monitor_device_uptime_percent{device_id=\"%s\"} %s\n",
+			escapeLabel(device.id), formatFloat(device.aggregates.Uptime()))
+	}
+	writeMetricHeader(w, "fleet_monitor_device_avg_upload_seconds", "gauge", "Device average upload time.")
+	for _, device := range devices {
+		if device.aggregates.UploadCount == 0 {
+			continue
+		}
+		avg := device.aggregates.AvgUploadTime().Seconds()
+		fmt.Fprintf(w, "fleet_monitor_device_avg_upload_seconds{device_id=\"%s\"} %s\n", escapeLabel(device.id), formatFloat(avg))
+	}
+	writeMetricHeader(w, "fleet_monitor_device_last_heartbeat_age_seconds", "gauge", "Time since the device's latest heartbeat.")
+	now := h.now()
+	for _, device := range devices {
+		if device.aggregates.HeartbeatCount == 0 {
+			continue
+		}
+		age := now.Sub(device.aggregates.LastHeartbeat).Seconds()
+		fmt.Fprintf(w, "fleet_monitor_device_last_heartbeat_age_seconds{device_id=\"%s\"} %s\n", escapeLabel(device.id), formatFloat(age))
+	}
+
+	c.Set(fiber.HeaderContentType, prometheusContentType)
+	return c.Status(fiber.StatusOK).SendString(w.String())
+}
+
+// writeLatencies writes the request latency histograms, sorted by label
+func (h *PrometheusHandler) writeLatencies(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]latencyKey, 0, len(h.latencies))
+	for key := range h.latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	const name = "fleet_monitor_http_request_duration_seconds"
+	writeMetricHeader(w, name, "histogram", "HTTP request latency by route.")
+	for _, key := range keys {
+		histogram := h.latencies[key]
+		labels := fmt.Sprintf(`method="%s",route="%s",code="%d"`, escapeLabel(key.method), escapeLabel(key.route), key.code)
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += histogram.buckets[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, histogram.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(histogram.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, histogram.count)
+	}
+}
+
+// deviceAggregates pairs a device with its lifetime aggregates
+type deviceAggregates struct {
+	id         string
+	aggregates storage.Aggregates
+}
+
+// deviceSeries picks the devices to export series for, sorted by ID, and
+// returns how many eligible devices were left out. Devices whose last
+// heartbeat is oldest are picked first, then devices without heartbeats.
+func (h *PrometheusHandler) deviceSeries() ([]deviceAggregates, int) {
+	var candidates []deviceAggregates
+	for _, info := range h.store.ListDevices() {
+		if info.Retired() {
+			continue
+		}
+		if h.options.DeviceTag != "" && !slices.Contains(info.Metadata.Tags, h.options.DeviceTag) {
+			continue
+		}
+		aggregates, err := h.store.GetAggregates(info.DeviceID)
+		if err != nil {
+			continue // deregistered in the meantime
+		}
+		candidates = append(candidates, deviceAggregates{id: info.DeviceID, aggregates: aggregates})
+	}
+
+	if len(candidates) > h.options.DeviceLimit {
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i].aggregates, candidates[j].aggregates
+			if (a.HeartbeatCount == 0) != (b.HeartbeatCount == 0) {
+				return b.HeartbeatCount == 0
+			}
+			if !a.LastHeartbeat.Equal(b.LastHeartbeat) {
+				return a.LastHeartbeat.Before(b.LastHeartbeat)
+			}
+			return candidates[i].id < candidates[j].id
+		})
+	}
+	limit := max(0, min(h.options.DeviceLimit, len(candidates)))
+	selected := candidates[:limit]
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].id < selected[j].id
+	})
+	return selected, len(candidates) - limit
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// formatFloat formats a sample value
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
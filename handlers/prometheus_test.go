@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	now := time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC)
+
+	store := storage.NewCountingStore(storage.NewMemoryStore())
+	devices := []storage.DeviceInfo{
+		{DeviceID: "dev-1", Source: storage.SourceAPI, Metadata: storage.DeviceMetadata{Tags: []string{"canary"}}},
+		{DeviceID: "dev-2", Source: storage.SourceAPI},
+		{DeviceID: "dev-3", Source: storage.SourceAPI, Metadata: storage.DeviceMetadata{Tags: []string{"canary"}}},
+	}
+	for _, info := range devices {
+		if err := store.RegisterDevice(info); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+
+	handler := NewPrometheusHandler(store, PrometheusOptions{DeviceLimit: 10})
+	handler.now = func() time.Time { return now }
+
+	deviceHandler := newTestDeviceHandler(store)
+	app := fiber.New()
+	app.Use(handler.Middleware())
+	app.Post("/devices/:device_id/heartbeat", deviceHandler.PostHeartbeat)
+	app.Post("/devices/:device_id/stats", deviceHandler.PostStats)
+	app.Get("/metrics", handler.GetMetrics)
+
+	request := func(method, target, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, string(data)
+	}
+
+	// dev-1 was last seen 30 minutes ago, dev-2 59 minutes ago and dev-3 never
+	request("POST", "/devices/dev-1/heartbeat", `{"sent_at": "2025-01-01T00:00:00Z"}`)
+	request("POST", "/devices/dev-1/heartbeat", `{"sent_at": "2025-01-01T00:30:00Z"}`)
+	request("POST", "/devices/dev-1/stats", `{"sent_at": "2025-01-01T00:00:00Z", "upload_time": 1500000000}`)
+	request("POST", "/devices/dev-2/heartbeat", `{"sent_at": "2025-01-01T00:01:00Z"}`)
+	request("POST", "/devices/unknown/heartbeat", `{"sent_at": "2025-01-01T00:00:00Z"}`)
+	request("POST", "/devices/dev-1/heartbeat", `not json`)
+	request("GET", "/nowhere", "")
+
+	testCases := []struct {
+		name       string
+		options    PrometheusOptions
+		contains   []string
+		notContain []string
+	}{
+		{
+			name:    "Fleet counters and latencies",
+			options: PrometheusOptions{DeviceLimit: 10},
+			contains: []string{
+				"# TYPE fleet_monitor_heartbeats_received_total counter\nfleet_monitor_heartbeats_received_total 3\n",
+				"fleet_monitor_stats_received_total 1\n",
+				"fleet_monitor_unknown_device_requests_total 1\n",
+				"fleet_monitor_bad_requests_total 1\n",
+				"# TYPE fleet_monitor_http_request_duration_seconds histogram\n",
+				`fleet_monitor_http_request_duration_seconds_count{method="POST",route="/devices/:device_id/heartbeat",code="204"} 3` + "\n",
+				`fleet_monitor_http_request_duration_seconds_bucket{method="POST",route="/devices/:device_id/heartbeat",code="404",le="+Inf"} 1` + "\n",
+				`fleet_monitor_http_request_duration_seconds_count{method="GET",route="unmatched",code="404"} 1` + "\n",
+				"fleet_monitor_device_series_omitted 0\n",
+				`fleet_monitor_device_uptime_percent{device_id="dev-1"} 6.666666666666667` + "\n",
+				`fleet_monitor_device_uptime_percent{device_id="dev-3"} 0` + "\n",
+				`fleet_monitor_device_avg_upload_seconds{device_id="dev-1"} 1.5` + "\n",
+				`fleet_monitor_device_last_heartbeat_age_seconds{device_id="dev-1"} 1800` + "\n",
+				`fleet_monitor_device_last_heartbeat_age_seconds{device_id="dev-2"} 3540` + "\n",
+			},
+			notContain: []string{
+				`fleet_monitor_device_avg_upload_seconds{device_id="dev-2"}`,
+				`fleet_monitor_device_last_heartbeat_age_seconds{device_id="dev-3"}`,
+				"fleet_monitor_listener_messages_total",
+			},
+		},
+		{
+			name: "Listener counters",
+			options: PrometheusOptions{Listeners: map[string]ListenerCounters{
+				"udp": func() map[string]int64 {
+					return map[string]int64{"accepted": 5, "malformed": 2}
+				},
+				"mqtt": func() map[string]int64 {
+					return map[string]int64{"accepted": 3, "rejected": 1}
+				},
+			}},
+			contains: []string{
+				"# TYPE fleet_monitor_listener_messages_total counter\n" +
+					`fleet_monitor_listener_messages_total{listener="mqtt",outcome="accepted"} 3` + "\n" +
+					`fleet_monitor_listener_messages_total{listener="mqtt",outcome="rejected"} 1` + "\n" +
+					`fleet_monitor_listener_messages_total{listener="udp",outcome="accepted"} 5` + "\n" +
+					`fleet_monitor_listener_messages_total{listener="udp",outcome="malformed"} 2` + "\n",
+			},
+		},
+		{
+			name:     "Top-N keeps the stalest devices",
+			options:  PrometheusOptions{DeviceLimit: 1},
+			contains: []string{"fleet_monitor_device_series_omitted 2\n", `{device_id="dev-2"}`},
+			notContain: []string{
+				`{device_id="dev-1"}`,
+				`{device_id="dev-3"}`,
+			},
+		},
+		{
+			name:       "Opt-in by tag",
+			options:    PrometheusOptions{DeviceLimit: 10, DeviceTag: "canary"},
+			contains:   []string{"fleet_monitor_device_series_omitted 0\n", `{device_id="dev-1"}`, `{device_id="dev-3"}`},
+			notContain: []string{`{device_id="dev-2"}`},
+		},
+		{
+			name:       "No per-device series",
+			options:    PrometheusOptions{},
+			contains:   []string{"fleet_monitor_device_series_omitted 3\n"},
+			notContain: []string{`device_id=`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler.options = tc.options
+			status, body := request("GET", "/metrics", "")
+			if status != fiber.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", status, body)
+			}
+			for _, expected := range tc.contains {
+				if !strings.Contains(body, expected) {
+					t.Errorf("Expected metrics to contain %q, got:\n%s", expected, body)
+				}
+			}
+			for _, unexpected := range tc.notContain {
+				if strings.Contains(body, unexpected) {
+					t.Errorf("Expected metrics not to contain %q", unexpected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// PostDevice handles POST /devices
+func (h *DeviceHandler) PostDevice(c *fiber.Ctx) error {
+	var req models.RegisterDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: "Invalid request body",
+		})
+	}
+
+	info := storage.DeviceInfo{
+		DeviceID:     req.DeviceID,
+		Source:       storage.SourceAPI,
+		Metadata:     storage.DeviceMetadata(req.Metadata),
+		RegisteredAt: time.Now().UTC(),
+	}
+	if err := h.store.RegisterDevice(info); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidDevice):
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: err.Error(),
+			})
+		case errors.Is(err, storage.ErrDeviceExists):
+			return c.Status(fiber.StatusConflict).JSON(models.ErrorResponse{
+				Msg: "Device already exists",
+			})
+		default:
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Failed to register device: %v", err),
+			})
+		}
+	}
+
+	registered, err := h.store.GetDeviceInfo(req.DeviceID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to retrieve device: %v", err),
+		})
+	}
+
+	response := deviceResponse(registered)
+	if status, err := h.status.Status(registered.DeviceID); err == nil {
+		response.Status = statusResponse(status, false)
+	}
+
+	if req.GenerateKey {
+		key, err := h.credentials.Rotate(registered.DeviceID, 0)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Failed to generate key: %v", err),
+			})
+		}
+		response.Key = keyResponse(key, true)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// GetDevice handles GET /devices/{device_id}
+func (h *DeviceHandler) GetDevice(c *fiber.Ctx) error {
+	info, err := h.store.GetDeviceInfo(c.Params("device_id"))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+				Msg: "Device not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to retrieve device: %v", err),
+		})
+	}
+
+	response := deviceResponse(info)
+	if status, err := h.status.Status(info.DeviceID); err == nil && !info.Retired() {
+		response.Status = statusResponse(status, true)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// DeleteDevice handles DELETE /devices/{device_id}
+func (h *DeviceHandler) DeleteDevice(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	retainData := false
+	if value := c.Query("retain_data"); value != "" {
+		var err error
+		if retainData, err = strconv.ParseBool(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: "Invalid 'retain_data' query parameter: expected true or false",
+			})
+		}
+	}
+
+	if err := h.store.DeregisterDevice(deviceID, retainData); err != nil {
+		if errors.Is(err, storage.ErrDeviceNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+				Msg: "Device not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to deregister device: %v", err),
+		})
+	}
+
+	// A device registered again later must not inherit the old keys
+	if err := h.credentials.RemoveDevice(deviceID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to remove device keys: %v", err),
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListDevices handles GET /devices
+func (h *DeviceHandler) ListDevices(c *fiber.Ctx) error {
+	limit := defaultListLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxListLimit {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid 'limit' query parameter: expected an integer between 1 and %d", maxListLimit),
+			})
+		}
+		limit = n
+	}
+
+	includeRetired := false
+	if value := c.Query("include_retired"); value != "" {
+		var err error
+		if includeRetired, err = strconv.ParseBool(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: "Invalid 'include_retired' query parameter: expected true or false",
+			})
+		}
+	}
+
+	var status storage.DeviceStatus
+	if value := c.Query("status"); value != "" {
+		var err error
+		if status, err = storage.ParseDeviceStatus(value); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid 'status' query parameter: %v", err),
+			})
+		}
+	}
+
+	filter := deviceFilter{
+		model:    c.Query("model"),
+		firmware: c.Query("firmware"),
+		site:     c.Query("site"),
+		region:   c.Query("region"),
+		owner:    c.Query("owner"),
+		tag:      c.Query("tag"),
+	}
+
+	// Devices are sorted by ID, so the cursor is the last ID of the previous page
+	devices := make([]storage.DeviceInfo, 0)
+	statuses := make(map[string]storage.DeviceStatusInfo)
+	for _, info := range h.store.ListDevices() {
+		if (!includeRetired && info.Retired()) || !filter.matches(info.Metadata) {
+			continue
+		}
+		// Retired devices have no status
+		if !info.Retired() {
+			if deviceStatus, err := h.status.Status(info.DeviceID); err == nil {
+				statuses[info.DeviceID] = deviceStatus
+			}
+		}
+		if deviceStatus, ok := statuses[info.DeviceID]; status != "" && (!ok || deviceStatus.Status != status) {
+			continue
+		}
+		devices = append(devices, info)
+	}
+
+	cursor := c.Query("cursor")
+	start := sort.Search(len(devices), func(i int) bool {
+		return devices[i].DeviceID > cursor
+	})
+	end := min(start+limit, len(devices))
+
+	response := models.ListDevicesResponse{
+		Devices: make([]models.DeviceResponse, 0, end-start),
+		Total:   len(devices),
+	}
+	for _, info := range devices[start:end] {
+		device := deviceResponse(info)
+		if deviceStatus, ok := statuses[info.DeviceID]; ok {
+			device.Status = statusResponse(deviceStatus, false)
+		}
+		response.Devices = append(response.Devices, device)
+	}
+	if end < len(devices) {
+		response.NextCursor = devices[end-1].DeviceID
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// deviceFilter selects devices by metadata; empty fields match anything
+type deviceFilter struct {
+	model, firmware, site, region, owner, tag string
+}
+
+// matches reports whether metadata satisfies every set field of the filter
+func (f deviceFilter) matches(metadata storage.DeviceMetadata) bool {
+	return matchesField(f.model, metadata.Model) &&
+		matchesField(f.firmware, metadata.Firmware) &&
+		matchesField(f.site, metadata.Site) &&
+		matchesField(f.region, metadata.Region) &&
+		matchesField(f.owner, metadata.Owner) &&
+		(f.tag == "" || slices.Contains(metadata.Tags, f.tag))
+}
+
+// matchesField reports whether value equals want, or want is empty
+func matchesField(want, value string) bool {
+	return want == "" || want == value
+}
+
+// deviceResponse converts registration details to the API representation
+func deviceResponse(info storage.DeviceInfo) models.DeviceResponse {
+	response := models.DeviceResponse{
+		DeviceID:     info.DeviceID,
+		Source:       string(info.Source),
+		Metadata:     models.DeviceMetadata(info.Metadata),
+		RegisteredAt: info.RegisteredAt,
+	}
+	if info.Retired() {
+		retiredAt := info.RetiredAt
+		response.RetiredAt = &retiredAt
+	}
+	return response
+}
+
+// statusResponse converts a device status to the API representation
+func statusResponse(status storage.DeviceStatusInfo, withTransitions bool) *models.DeviceStatus {
+	response := &models.DeviceStatus{Status: string(status.Status), Connected: status.Connected}
+	if !status.Since.IsZero() {
+		since := status.Since
+		response.Since = &since
+	}
+	if !status.LastHeartbeat.IsZero() {
+		lastHeartbeat := status.LastHeartbeat
+		response.LastHeartbeat = &lastHeartbeat
+	}
+	if withTransitions {
+		for _, transition := range status.Transitions {
+			response.Transitions = append(response.Transitions, models.StatusTransition{
+				From: string(transition.From),
+				To:   string(transition.To),
+				At:   transition.At,
+			})
+		}
+	}
+	return response
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// newTestDeviceHandler creates a device handler whose status tracker has
+// not evaluated any device yet
+func newTestDeviceHandler(store storage.DeviceStore) *DeviceHandler {
+	return NewDeviceHandler(store, storage.NewStatusTracker(store, storage.DefaultStatusThresholds, time.Minute), storage.NewCredentials(), NewAdminAuth(""))
+}
+
+func TestDeviceRegistry(t *testing.T) {
+	handler := newTestDeviceHandler(storage.NewMemoryStore())
+	app := fiber.New()
+	app.Post("/devices", handler.PostDevice)
+	app.Get("/devices", handler.ListDevices)
+	app.Delete("/devices/:device_id", handler.DeleteDevice)
+
+	request := func(method, target, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, data
+	}
+
+	for i := 5; i >= 1; i-- {
+		body := fmt.Sprintf(`{"device_id":"dev-%d","metadata":{"site":"lab"}}`, i)
+		if status, _ := request("POST", "/devices", body); status != fiber.StatusCreated {
+			t.Fatalf("Expected 201 registering dev-%d, got %d", i, status)
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "Duplicate registration", method: "POST", target: "/devices", body: `{"device_id":"dev-1"}`, status: fiber.StatusConflict},
+		{name: "Missing device ID", method: "POST", target: "/devices", body: `{}`, status: fiber.StatusBadRequest},
+		{name: "Invalid body", method: "POST", target: "/devices", body: `{`, status: fiber.StatusBadRequest},
+		{name: "Retire device", method: "DELETE", target: "/devices/dev-3?retain_data=true", status: fiber.StatusNoContent},
+		{name: "Invalid retain_data", method: "DELETE", target: "/devices/dev-2?retain_data=maybe", status: fiber.StatusBadRequest},
+		{name: "Unknown device", method: "DELETE", target: "/devices/unknown", status: fiber.StatusNotFound},
+		{name: "Invalid limit", method: "GET", target: "/devices?limit=0", status: fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, body := request(tc.method, tc.target, tc.body); status != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+		})
+	}
+
+	// Page through the active devices two at a time
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		status, body := request("GET", "/devices?limit=2&cursor="+cursor, "")
+		if status != fiber.StatusOK {
+			t.Fatalf("Expected 200, got %d", status)
+		}
+		var page models.ListDevicesResponse
+		if err := json.Unmarshal(body, &page); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if page.Total != 4 {
+			t.Errorf("Expected total 4, got %d", page.Total)
+		}
+		for _, device := range page.Devices {
+			ids = append(ids, device.DeviceID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if strings.Join(ids, ",") != "dev-1,dev-2,dev-4,dev-5" {
+		t.Errorf("Unexpected devices %v", ids)
+	}
+
+	status, body := request("GET", "/devices?include_retired=true", "")
+	var page models.ListDevicesResponse
+	if err := json.Unmarshal(body, &page); status != fiber.StatusOK || err != nil {
+		t.Fatalf("Unexpected response %d: %s", status, body)
+	}
+	if page.Total != 5 || page.Devices[2].RetiredAt == nil || page.Devices[2].Metadata.Site != "lab" {
+		t.Errorf("Unexpected devices %+v", page.Devices)
+	}
+}
+
+func TestListDevicesFilter(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, info := range []storage.DeviceInfo{
+		{DeviceID: "dev-1", Metadata: storage.DeviceMetadata{Model: "x1", Site: "lab", Tags: []string{"beta"}}},
+		{DeviceID: "dev-2", Metadata: storage.DeviceMetadata{Model: "x1", Site: "field"}},
+		{DeviceID: "dev-3", Metadata: storage.DeviceMetadata{Model: "x2", Site: "lab", Tags: []string{"beta", "edge"}}},
+	} {
+		info.Source = storage.SourceAPI
+		if err := store.RegisterDevice(info); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+
+	handler := newTestDeviceHandler(store)
+	app := fiber.New()
+	app.Get("/devices", handler.ListDevices)
+	app.Get("/devices/:device_id", handler.GetDevice)
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "No filter", query: "", expected: "dev-1,dev-2,dev-3"},
+		{name: "By model", query: "model=x1", expected: "dev-1,dev-2"},
+		{name: "By site and model", query: "site=lab&model=x1", expected: "dev-1"},
+		{name: "By tag", query: "tag=beta", expected: "dev-1,dev-3"},
+		{name: "No match", query: "owner=nobody", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/devices?"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var page models.ListDevicesResponse
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := make([]string, 0, len(page.Devices))
+			for _, device := range page.Devices {
+				ids = append(ids, device.DeviceID)
+			}
+			if got := strings.Join(ids, ","); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/devices/dev-3", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var device models.DeviceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Unexpected response %d (err %v)", resp.StatusCode, err)
+	}
+	if device.Metadata.Model != "x2" || len(device.Metadata.Tags) != 2 {
+		t.Errorf("Unexpected device %+v", device)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/devices/unknown", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("Expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeviceStatusFilter(t *testing.T) {
+	now := time.Now()
+
+	store := storage.NewMemoryStore()
+	heartbeats := map[string]time.Duration{"dev-1": 0, "dev-2": 5 * time.Minute, "dev-4": time.Hour}
+	for _, deviceID := range []string{"dev-1", "dev-2", "dev-3", "dev-4"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+		if age, ok := heartbeats[deviceID]; ok {
+			store.AddHeartbeat(deviceID, now.Add(-age))
+		}
+	}
+	status := storage.NewStatusTracker(store, storage.DefaultStatusThresholds, time.Minute)
+	status.RunOnce()
+
+	handler := NewDeviceHandler(store, status, storage.NewCredentials(), NewAdminAuth(""))
+	app := fiber.New()
+	app.Get("/devices", handler.ListDevices)
+	app.Get("/devices/:device_id", handler.GetDevice)
+
+	testCases := []struct {
+		name     string
+		query    string
+		status   int
+		expected string
+	}{
+		{name: "Online", query: "status=online", status: fiber.StatusOK, expected: "dev-1"},
+		{name: "Degraded", query: "status=degraded", status: fiber.StatusOK, expected: "dev-2"},
+		{name: "Never seen", query: "status=never_seen", status: fiber.StatusOK, expected: "dev-3"},
+		{name: "Offline", query: "status=offline", status: fiber.StatusOK, expected: "dev-4"},
+		{name: "Invalid status", query: "status=asleep", status: fiber.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/devices?"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Fatalf("Expected %d, got %d", tc.status, resp.StatusCode)
+			}
+			if tc.status != fiber.StatusOK {
+				return
+			}
+
+			var page models.ListDevicesResponse
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := make([]string, 0, len(page.Devices))
+			for _, device := range page.Devices {
+				ids = append(ids, device.DeviceID)
+			}
+			if got := strings.Join(ids, ","); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/devices/dev-4", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var device models.DeviceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&device); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if device.Status == nil || device.Status.Status != "offline" || device.Status.Since == nil ||
+		device.Status.LastHeartbeat == nil || !device.Status.LastHeartbeat.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Unexpected status %+v", device.Status)
+	}
+}
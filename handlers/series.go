@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+const (
+	defaultSeriesStep = time.Hour
+	minSeriesStep     = time.Minute
+	maxSeriesBuckets  = 10000
+)
+
+// GetStatsSeries handles GET /devices/{device_id}/stats/series
+func (h *DeviceHandler) GetStatsSeries(c *fiber.Ctx) error {
+	deviceID := c.Params("device_id")
+
+	window, err := parseTimeWindow(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: err.Error(),
+		})
+	}
+
+	step := defaultSeriesStep
+	if value := c.Query("step"); value != "" {
+		step, err = time.ParseDuration(value)
+		if err != nil || step < minSeriesStep {
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+				Msg: fmt.Sprintf("Invalid 'step' query parameter: expected a duration of at least %s", minSeriesStep),
+			})
+		}
+	}
+
+	// Validate device exists
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+
+	deviceData, err := h.store.GetDeviceData(deviceID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Failed to retrieve device data: %v", err),
+		})
+	}
+
+	heartbeats := filterHeartbeats(deviceData.Heartbeats, window)
+	uploads := filterUploads(deviceData.Uploads, window)
+
+	// Open bounds default to the span of the data, aligned to the step
+	from, to := window.From, window.To
+	if from.IsZero() || to.IsZero() {
+		first, last, ok := sampleSpan(heartbeats, uploads)
+		if !ok {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+		if from.IsZero() {
+			from = first.Truncate(step)
+		}
+		if to.IsZero() {
+			to = last.Truncate(step).Add(step)
+		}
+	}
+
+	if numBuckets(from, to, step) > maxSeriesBuckets {
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Msg: fmt.Sprintf("Too many buckets: at most %d allowed, increase 'step' or narrow the window", maxSeriesBuckets),
+		})
+	}
+
+	response := models.GetDeviceStatsSeriesResponse{
+		Step:    step.String(),
+		From:    from,
+		To:      to,
+		Buckets: buildSeries(heartbeats, uploads, from, to, step),
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// buildSeries groups samples in [from, to) into consecutive buckets of width
+// step. Uptime per bucket uses the same definition as calculateUptime.
+func buildSeries(heartbeats []time.Time, uploads []storage.UploadSample, from, to time.Time, step time.Duration) []models.StatsBucket {
+	n := numBuckets(from, to, step)
+	bucketHeartbeats := make([][]time.Time, n)
+	bucketUploads := make([][]int64, n)
+
+	for _, heartbeat := range heartbeats {
+		if i, ok := bucketIndex(heartbeat, from, to, step); ok {
+			bucketHeartbeats[i] = append(bucketHeartbeats[i], heartbeat)
+		}
+	}
+	for _, upload := range uploads {
+		if i, ok := bucketIndex(upload.SentAt, from, to, step); ok {
+			bucketUploads[i] = append(bucketUploads[i], upload.UploadTime)
+		}
+	}
+
+	buckets := make([]models.StatsBucket, n)
+	for i := range buckets {
+		bucket := models.StatsBucket{
+			Start:      from.Add(time.Duration(i) * step),
+			Heartbeats: len(bucketHeartbeats[i]),
+			Uptime:     calculateUptime(bucketHeartbeats[i]),
+			Uploads:    len(bucketUploads[i]),
+		}
+
+		if len(bucketUploads[i]) > 0 {
+			avg := calculateAvgUploadTime(bucketUploads[i])
+			minimum, maximum := uploadTimeRange(bucketUploads[i])
+			bucket.AvgUploadTime = &avg
+			bucket.MinUploadTime = &minimum
+			bucket.MaxUploadTime = &maximum
+		}
+
+		buckets[i] = bucket
+	}
+
+	return buckets
+}
+
+// numBuckets returns how many buckets of width step cover [from, to)
+func numBuckets(from, to time.Time, step time.Duration) int {
+	span := to.Sub(from)
+	if span <= 0 {
+		return 0
+	}
+	return int((span + step - 1) / step)
+}
+
+// bucketIndex returns the bucket t falls into, if any
+func bucketIndex(t, from, to time.Time, step time.Duration) (int, bool) {
+	if t.Before(from) || !t.Before(to) {
+		return 0, false
+	}
+	return int(t.Sub(from) / step), true
+}
+
+// sampleSpan returns the earliest and latest sample timestamps
+func sampleSpan(heartbeats []time.Time, uploads []storage.UploadSample) (first, last time.Time, ok bool) {
+	timestamps := make([]time.Time, 0, len(heartbeats)+len(uploads))
+	timestamps = append(timestamps, heartbeats...)
+	for _, upload := range uploads {
+		timestamps = append(timestamps, upload.SentAt)
+	}
+
+	for i, t := range timestamps {
+		if i == 0 || t.Before(first) {
+			first = t
+		}
+		if i == 0 || t.After(last) {
+			last = t
+		}
+	}
+	return first, last, len(timestamps) > 0
+}
+
+// uploadTimeRange returns the smallest and largest upload time as duration strings
+func uploadTimeRange(uploadTimes []int64) (string, string) {
+	minimum, maximum := uploadTimes[0], uploadTimes[0]
+	for _, t := range uploadTimes[1:] {
+		minimum = min(minimum, t)
+		maximum = max(maximum, t)
+	}
+	return time.Duration(minimum).String(), time.Duration(maximum).String()
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestBuildSeries(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Hour)
+
+	heartbeats := []time.Time{
+		// Bucket 0: 3 heartbeats over 2 minutes
+		from,
+		from.Add(2 * time.Minute),
+		from.Add(1 * time.Minute),
+		// Bucket 1: none
+		// Bucket 2: a single heartbeat
+		from.Add(2*time.Hour + 30*time.Minute),
+		// Outside the range
+		to,
+	}
+	uploads := []storage.UploadSample{
+		{SentAt: from.Add(10 * time.Minute), UploadTime: (2 * time.Second).Nanoseconds()},
+		{SentAt: from.Add(20 * time.Minute), UploadTime: (4 * time.Second).Nanoseconds()},
+		{SentAt: from.Add(2 * time.Hour), UploadTime: (1 * time.Minute).Nanoseconds()},
+		{SentAt: from.Add(-time.Second), UploadTime: 1},
+	}
+
+	buckets := buildSeries(heartbeats, uploads, from, to, time.Hour)
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(buckets))
+	}
+
+	testCases := []struct {
+		name       string
+		index      int
+		start      time.Time
+		heartbeats int
+		uptime     float64
+		uploads    int
+		avg        string
+		minimum    string
+		maximum    string
+	}{
+		{
+			name:       "Bucket with heartbeats and uploads",
+			index:      0,
+			start:      from,
+			heartbeats: 3,
+			uptime:     (3.0 / 2.0) * 100.0,
+			uploads:    2,
+			avg:        "3s",
+			minimum:    "2s",
+			maximum:    "4s",
+		},
+		{
+			name:  "Empty bucket",
+			index: 1,
+			start: from.Add(time.Hour),
+		},
+		{
+			name:       "Single heartbeat and upload",
+			index:      2,
+			start:      from.Add(2 * time.Hour),
+			heartbeats: 1,
+			uptime:     100.0,
+			uploads:    1,
+			avg:        "1m0s",
+			minimum:    "1m0s",
+			maximum:    "1m0s",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket := buckets[tc.index]
+			if !bucket.Start.Equal(tc.start) {
+				t.Errorf("Expected start %v, got %v", tc.start, bucket.Start)
+			}
+			if bucket.Heartbeats != tc.heartbeats || bucket.Uptime != tc.uptime {
+				t.Errorf("Expected %d heartbeats and uptime %f, got %d and %f",
+					tc.heartbeats, tc.uptime, bucket.Heartbeats, bucket.Uptime)
+			}
+			if bucket.Uploads != tc.uploads {
+				t.Errorf("Expected %d uploads, got %d", tc.uploads, bucket.Uploads)
+			}
+			if tc.uploads == 0 {
+				if bucket.AvgUploadTime != nil || bucket.MinUploadTime != nil || bucket.MaxUploadTime != nil {
+					t.Error("Expected upload times to be null for an empty bucket")
+				}
+				return
+			}
+			if *bucket.AvgUploadTime != tc.avg || *bucket.MinUploadTime != tc.minimum || *bucket.MaxUploadTime != tc.maximum {
+				t.Errorf("Expected avg/min/max %s/%s/%s, got %s/%s/%s", tc.avg, tc.minimum, tc.maximum,
+					*bucket.AvgUploadTime, *bucket.MinUploadTime, *bucket.MaxUploadTime)
+			}
+		})
+	}
+}
+
+func TestNumBuckets(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		to       time.Time
+		expected int
+	}{
+		{name: "Empty range", to: from, expected: 0},
+		{name: "Exact multiple", to: from.Add(2 * time.Hour), expected: 2},
+		{name: "Partial last bucket", to: from.Add(2*time.Hour + time.Minute), expected: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if n := numBuckets(from, tc.to, time.Hour); n != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// Headers of signed requests
+const (
+	HeaderKeyID     = "X-Key-Id"
+	HeaderTimestamp = "X-Timestamp" // Unix seconds
+	HeaderSignature = "X-Signature" // hex HMAC-SHA256, see Sign
+)
+
+// DefaultSignatureSkew is how far the timestamp of a signed request may be
+// from the server clock by default
+const DefaultSignatureSkew = 5 * time.Minute
+
+// SignatureOptions configure request signature checks
+type SignatureOptions struct {
+	// MaxSkew is how far a request timestamp may be from the server clock
+	MaxSkew time.Duration
+}
+
+// SignatureVerifier checks that device requests are signed with one of
+// the device's keys. Requests with the admin token need no signature.
+type SignatureVerifier struct {
+	credentials *storage.Credentials
+	options     SignatureOptions
+	admin       *AdminAuth
+	now         func() time.Time
+
+	mu        sync.Mutex // guards seen and lastPrune
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewSignatureVerifier creates a verifier checking requests against the
+// keys in credentials
+func NewSignatureVerifier(credentials *storage.Credentials, options SignatureOptions, admin *AdminAuth) *SignatureVerifier {
+	if options.MaxSkew <= 0 {
+		options.MaxSkew = DefaultSignatureSkew
+	}
+	return &SignatureVerifier{
+		credentials: credentials,
+		options:     options,
+		admin:       admin,
+		now:         time.Now,
+		seen:        make(map[string]time.Time),
+	}
+}
+
+// Sign computes the signature of a request: the hex HMAC-SHA256, keyed
+// with the secret, of the timestamp, method, path and body separated by
+// newlines
+func Sign(secret, timestamp, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + path + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware rejects requests of routes with a device_id parameter that
+// are not signed with an active key of the device, if the device must sign
+// its requests
+func (v *SignatureVerifier) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if v.admin.Authorized(c) {
+			return c.Next()
+		}
+		deviceID := c.Params("device_id")
+		if !v.credentials.SignatureRequired(deviceID) {
+			return c.Next()
+		}
+		if len(v.credentials.Keys(deviceID)) == 0 {
+			return unauthorized(c, "Device has no credentials")
+		}
+		if msg := v.verify(c, deviceID); msg != "" {
+			return unauthorized(c, msg)
+		}
+		return c.Next()
+	}
+}
+
+// KeyManagement rejects requests of routes with a device_id parameter
+// that carry neither the admin token nor a signature made with an active
+// key of the device, so only the device itself can rotate its keys
+func (v *SignatureVerifier) KeyManagement() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if v.admin.Authorized(c) {
+			return c.Next()
+		}
+		deviceID := c.Params("device_id")
+		if len(v.credentials.Keys(deviceID)) == 0 {
+			return unauthorized(c, "Admin token required: the device has no keys to sign with")
+		}
+		if msg := v.verify(c, deviceID); msg != "" {
+			return unauthorized(c, msg)
+		}
+		return c.Next()
+	}
+}
+
+// verify checks the signature headers of a request against the keys of a
+// device, returning why the request is rejected or an empty string
+func (v *SignatureVerifier) verify(c *fiber.Ctx, deviceID string) string {
+	keyID, timestamp, signature := c.Get(HeaderKeyID), c.Get(HeaderTimestamp), c.Get(HeaderSignature)
+	if keyID == "" || timestamp == "" || signature == "" {
+		return "Missing signature: " + HeaderKeyID + ", " + HeaderTimestamp + " and " + HeaderSignature + " headers are required"
+	}
+	key, err := v.credentials.Key(deviceID, keyID)
+	if err != nil {
+		return "Unknown or expired key"
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "Invalid " + HeaderTimestamp + " header: expected Unix seconds"
+	}
+	sentAt := time.Unix(seconds, 0)
+	now := v.now()
+	if sentAt.Before(now.Add(-v.options.MaxSkew)) || sentAt.After(now.Add(v.options.MaxSkew)) {
+		return "Request timestamp outside the allowed clock skew"
+	}
+
+	expected := Sign(key.Secret, timestamp, c.Method(), c.Path(), c.Body())
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return "Invalid signature"
+	}
+	// Signatures are checked for replays until their timestamp is too old anyway
+	if !v.remember(expected, sentAt.Add(v.options.MaxSkew), now) {
+		return "Replayed request"
+	}
+	return ""
+}
+
+// remember records a signature until it expires, reporting false if it was
+// already seen
+func (v *SignatureVerifier) remember(signature string, expiresAt, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastPrune) >= v.options.MaxSkew {
+		for seen, seenExpiry := range v.seen {
+			if now.After(seenExpiry) {
+				delete(v.seen, seen)
+			}
+		}
+		v.lastPrune = now
+	}
+
+	if _, exists := v.seen[signature]; exists {
+		return false
+	}
+	v.seen[signature] = expiresAt
+	return true
+}
+
+// unauthorized rejects a request with 401
+func unauthorized(c *fiber.Ctx, msg string) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(models.ErrorResponse{
+		Msg: msg,
+	})
+}
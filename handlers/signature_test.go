@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestSignedRequests(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := storage.NewMemoryStore()
+	credentials := storage.NewCredentials()
+	admin := NewAdminAuth("admin-token")
+	handler := NewDeviceHandler(store, storage.NewStatusTracker(store, storage.DefaultStatusThresholds, time.Minute), credentials, admin)
+	verifier := NewSignatureVerifier(credentials, SignatureOptions{MaxSkew: time.Minute}, admin)
+	verifier.now = func() time.Time { return now }
+
+	app := fiber.New()
+	app.Post("/devices", admin.Middleware(), handler.PostDevice)
+	app.Delete("/devices/:device_id", admin.Middleware(), handler.DeleteDevice)
+	app.Post("/devices/:device_id/heartbeat", verifier.Middleware(), handler.PostHeartbeat)
+	app.Post("/devices/:device_id/keys", verifier.KeyManagement(), handler.PostDeviceKey)
+	app.Get("/devices/:device_id/keys", verifier.KeyManagement(), handler.ListDeviceKeys)
+	app.Delete("/devices/:device_id/keys/:key_id", verifier.KeyManagement(), handler.DeleteDeviceKey)
+
+	request := func(method, target, body string, headers map[string]string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, target, err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		return resp.StatusCode, data
+	}
+
+	// Devices without keys may post unsigned requests
+	adminHeaders := map[string]string{fiber.HeaderAuthorization: "Bearer admin-token"}
+	request("POST", "/devices", `{"device_id":"open"}`, adminHeaders)
+	if status, body := request("POST", "/devices/open/heartbeat", `{"sent_at":"2025-01-01T00:00:00Z"}`, nil); status != fiber.StatusNoContent {
+		t.Fatalf("Expected 204 for a device without keys, got %d: %s", status, body)
+	}
+
+	// A key is provisioned at registration, by an admin only
+	if status, body := request("POST", "/devices", `{"device_id":"device-1","generate_key":true}`, nil); status != fiber.StatusUnauthorized {
+		t.Fatalf("Expected 401 for a key generated without the admin token, got %d: %s", status, body)
+	}
+	status, body := request("POST", "/devices", `{"device_id":"device-1","generate_key":true}`, adminHeaders)
+	if status != fiber.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", status, body)
+	}
+	var device models.DeviceResponse
+	if err := json.Unmarshal(body, &device); err != nil || device.Key == nil || device.Key.Secret == "" {
+		t.Fatalf("Expected a key in the registration response, got %s", body)
+	}
+	key := *device.Key
+
+	const path = "/devices/device-1/heartbeat"
+	heartbeat := `{"sent_at":"2025-01-01T00:00:00Z"}`
+	signedRequest := func(key models.DeviceKey, sentAt time.Time, method, path, body string) map[string]string {
+		timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+		return map[string]string{
+			HeaderKeyID:     key.KeyID,
+			HeaderTimestamp: timestamp,
+			HeaderSignature: Sign(key.Secret, timestamp, method, path, []byte(body)),
+		}
+	}
+	signed := func(key models.DeviceKey, sentAt time.Time, body string) map[string]string {
+		return signedRequest(key, sentAt, "POST", path, body)
+	}
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		body    string
+		status  int
+	}{
+		{name: "Signed", headers: signed(key, now, heartbeat), body: heartbeat, status: fiber.StatusNoContent},
+		{name: "Replayed", headers: signed(key, now, heartbeat), body: heartbeat, status: fiber.StatusUnauthorized},
+		{name: "Unsigned", body: heartbeat, status: fiber.StatusUnauthorized},
+		{name: "Clock ahead within the skew", headers: signed(key, now.Add(time.Minute), heartbeat), body: heartbeat, status: fiber.StatusNoContent},
+		{name: "Too old", headers: signed(key, now.Add(-2*time.Minute), heartbeat), body: heartbeat, status: fiber.StatusUnauthorized},
+		{name: "Too far ahead", headers: signed(key, now.Add(2*time.Minute), heartbeat), body: heartbeat, status: fiber.StatusUnauthorized},
+		{name: "Tampered body", headers: signed(key, now.Add(time.Second), heartbeat), body: `{"sent_at":"2030-01-01T00:00:00Z"}`, status: fiber.StatusUnauthorized},
+		{name: "Unknown key", headers: signed(models.DeviceKey{KeyID: "other", Secret: key.Secret}, now, heartbeat), body: heartbeat, status: fiber.StatusUnauthorized},
+		{name: "Wrong secret", headers: signed(models.DeviceKey{KeyID: key.KeyID, Secret: "guess"}, now, heartbeat), body: heartbeat, status: fiber.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, body := request("POST", path, tc.body, tc.headers); status != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+		})
+	}
+
+	// Keys are managed by an admin or the device itself
+	keyCases := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		status  int
+	}{
+		{name: "Unauthenticated", target: "/devices/device-1/keys", status: fiber.StatusUnauthorized},
+		{name: "Wrong admin token", target: "/devices/device-1/keys", headers: map[string]string{fiber.HeaderAuthorization: "Bearer guess"}, status: fiber.StatusUnauthorized},
+		{name: "Signed by another path", target: "/devices/device-1/keys", headers: signed(key, now.Add(time.Second), ""), status: fiber.StatusUnauthorized},
+		{name: "Device without keys", target: "/devices/open/keys", status: fiber.StatusUnauthorized},
+		{name: "Admin", target: "/devices/device-1/keys", headers: adminHeaders, status: fiber.StatusOK},
+		{name: "Signed by the device", target: "/devices/device-1/keys", headers: signedRequest(key, now.Add(time.Second), "GET", "/devices/device-1/keys", ""), status: fiber.StatusOK},
+	}
+	for _, tc := range keyCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, body := request("GET", tc.target, "", tc.headers); status != tc.status {
+				t.Errorf("Expected %d, got %d: %s", tc.status, status, body)
+			}
+		})
+	}
+	if status, body := request("POST", "/devices/device-1/keys", "", nil); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unauthenticated rotation, got %d: %s", status, body)
+	}
+	if status, body := request("DELETE", "/devices/device-1", "", nil); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unsigned deregistration, got %d: %s", status, body)
+	}
+	// Otherwise a device could deregister itself and be registered again without keys
+	if status, body := request("DELETE", "/devices/device-1", "", signedRequest(key, now.Add(time.Second), "DELETE", "/devices/device-1", "")); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected 401 for a deregistration signed by the device, got %d: %s", status, body)
+	}
+
+	// During a rotation both keys are accepted until the overlap ends
+	rotation := `{"overlap":"1h"}`
+	status, body = request("POST", "/devices/device-1/keys", rotation, signedRequest(key, now.Add(time.Second), "POST", "/devices/device-1/keys", rotation))
+	if status != fiber.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", status, body)
+	}
+	var rotated models.DeviceKey
+	if err := json.Unmarshal(body, &rotated); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	for _, k := range []models.DeviceKey{key, rotated} {
+		if status, body := request("POST", path, heartbeat, signed(k, now.Add(2*time.Second), heartbeat)); status != fiber.StatusNoContent {
+			t.Errorf("Expected key %s to be accepted during the overlap, got %d: %s", k.KeyID, status, body)
+		}
+	}
+
+	status, body = request("GET", "/devices/device-1/keys", "", adminHeaders)
+	var keys models.DeviceKeysResponse
+	if err := json.Unmarshal(body, &keys); err != nil || status != fiber.StatusOK || len(keys.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d: %s", status, body)
+	}
+	if keys.Keys[0].Secret != "" || keys.Keys[0].ExpiresAt == nil || keys.Keys[1].ExpiresAt != nil {
+		t.Errorf("Expected listed keys without secrets, the old one expiring, got %s", body)
+	}
+
+	// Revoked keys stop working at once
+	if status, _ := request("DELETE", "/devices/device-1/keys/"+key.KeyID, "", adminHeaders); status != fiber.StatusNoContent {
+		t.Errorf("Expected 204, got %d", status)
+	}
+	if status, _ := request("DELETE", "/devices/device-1/keys/"+key.KeyID, "", adminHeaders); status != fiber.StatusNotFound {
+		t.Errorf("Expected 404 for a revoked key, got %d", status)
+	}
+	if status, _ := request("POST", path, heartbeat, signed(key, now.Add(3*time.Second), heartbeat)); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be rejected, got %d", status)
+	}
+
+	if status, _ := request("POST", "/devices/device-1/keys", `{"overlap":"soon"}`, adminHeaders); status != fiber.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid overlap, got %d", status)
+	}
+	if status, _ := request("POST", "/devices/unknown/keys", "", adminHeaders); status != fiber.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown device, got %d", status)
+	}
+
+	// Deregistering a device removes its keys
+	if status, body := request("DELETE", "/devices/device-1", "", adminHeaders); status != fiber.StatusNoContent {
+		t.Errorf("Expected 204, got %d: %s", status, body)
+	}
+	if keys := credentials.Keys("device-1"); len(keys) != 0 {
+		t.Errorf("Expected no keys after deregistration, got %+v", keys)
+	}
+
+	// Once signatures are required, devices without keys are rejected
+	credentials.RequireSignatures(true)
+	if status, _ := request("POST", "/devices/open/heartbeat", heartbeat, nil); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected 401 for a device without keys, got %d", status)
+	}
+}
+
+func TestUnsignedIngestion(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"open", "keyed"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	credentials := storage.NewCredentials()
+	if _, err := credentials.Rotate("keyed", 0); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	admin := NewAdminAuth("admin-token")
+	handler := NewDeviceHandler(store, storage.NewStatusTracker(store, storage.DefaultStatusThresholds, time.Minute), credentials, admin)
+	verifier := NewSignatureVerifier(credentials, SignatureOptions{}, admin)
+
+	app := fiber.New()
+	app.Post("/devices/:device_id/batch", verifier.Middleware(), handler.PostBatch)
+	app.Post("/ingest", handler.PostIngest)
+	app.Post("/import", handler.PostImport)
+
+	adminToken := "Bearer admin-token"
+	testCases := []struct {
+		name          string
+		target        string
+		body          string
+		authorization string
+		status        int
+		accepted      int
+		rejected      int
+	}{
+		{name: "Unsigned batch", target: "/devices/keyed/batch", body: `{"items":[{"type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}]}`, status: fiber.StatusUnauthorized},
+		{name: "Batch with the admin token", target: "/devices/keyed/batch", body: `{"items":[{"type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}]}`, authorization: adminToken, status: fiber.StatusOK, accepted: 1},
+		{
+			name:     "Ingest",
+			target:   "/ingest",
+			body:     `{"devices":{"open":[{"type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}],"keyed":[{"type":"heartbeat","sent_at":"2025-01-01T00:01:00Z"}]}}`,
+			status:   fiber.StatusOK,
+			accepted: 1,
+			rejected: 1,
+		},
+		{
+			name:          "Ingest with the admin token",
+			target:        "/ingest",
+			body:          `{"devices":{"keyed":[{"type":"heartbeat","sent_at":"2025-01-01T00:02:00Z"}]}}`,
+			authorization: adminToken,
+			status:        fiber.StatusOK,
+			accepted:      1,
+		},
+		{
+			name:     "Import",
+			target:   "/import",
+			body:     "{\"device_id\":\"open\",\"sent_at\":\"2025-01-01T00:03:00Z\"}\n{\"device_id\":\"keyed\",\"sent_at\":\"2025-01-01T00:03:00Z\"}\n",
+			status:   fiber.StatusOK,
+			accepted: 1,
+			rejected: 1,
+		},
+		{
+			name:          "Import with the admin token",
+			target:        "/import",
+			body:          "{\"device_id\":\"keyed\",\"sent_at\":\"2025-01-01T00:04:00Z\"}\n",
+			authorization: adminToken,
+			status:        fiber.StatusOK,
+			accepted:      1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.authorization != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tc.authorization)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("POST %s failed: %v", tc.target, err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tc.status {
+				t.Fatalf("Expected %d, got %d: %s", tc.status, resp.StatusCode, body)
+			}
+			if tc.status != fiber.StatusOK {
+				return
+			}
+
+			var counts struct {
+				Accepted int `json:"accepted"`
+				Rejected int `json:"rejected"`
+			}
+			if err := json.Unmarshal(body, &counts); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if counts.Accepted != tc.accepted || counts.Rejected != tc.rejected {
+				t.Errorf("Expected %d accepted and %d rejected, got %s", tc.accepted, tc.rejected, body)
+			}
+		})
+	}
+
+	// Only the reports sent with the admin token were stored
+	if aggregates, err := store.GetAggregates("keyed"); err != nil || aggregates.HeartbeatCount != 3 {
+		t.Errorf("Expected 3 heartbeats, got %+v (%v)", aggregates, err)
+	}
+}
+
+func TestSignatureRequiredWithoutActiveKeys(t *testing.T) {
+	// device-1 was given a key that has since expired
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	content := `{"devices":{"device-1":[{"id":"k1","secret":"s1","created_at":"2025-01-01T00:00:00Z","expires_at":"2025-01-02T00:00:00Z"}]}}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	credentials, err := storage.LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadCredentials failed: %v", err)
+	}
+
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	admin := NewAdminAuth("admin-token")
+	handler := NewDeviceHandler(store, storage.NewStatusTracker(store, storage.DefaultStatusThresholds, time.Minute), credentials, admin)
+	verifier := NewSignatureVerifier(credentials, SignatureOptions{}, admin)
+
+	app := fiber.New()
+	app.Post("/devices/:device_id/heartbeat", verifier.Middleware(), handler.PostHeartbeat)
+	app.Post("/ingest", handler.PostIngest)
+
+	// device-2 had its only key revoked
+	key, err := credentials.Rotate("device-2", 0)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if err := credentials.Revoke("device-2", key.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		t.Run(deviceID, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/devices/"+deviceID+"/heartbeat", strings.NewReader(`{"sent_at":"2025-01-01T00:00:00Z"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("POST heartbeat failed: %v", err)
+			}
+			defer resp.Body.Close()
+			var errResp models.ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusUnauthorized || errResp.Msg != "Device has no credentials" {
+				t.Errorf("Expected 401 Device has no credentials, got %d: %s", resp.StatusCode, errResp.Msg)
+			}
+
+			ingest := `{"devices":{"` + deviceID + `":[{"type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}]}}`
+			req = httptest.NewRequest("POST", "/ingest", strings.NewReader(ingest))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err = app.Test(req)
+			if err != nil {
+				t.Fatalf("POST /ingest failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if !strings.Contains(string(body), `"rejected":1`) {
+				t.Errorf("Expected the report to be rejected, got %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+const (
+	socketMaxFrame   = 64 * 1024        // largest frame a device may send
+	socketPongWait   = 60 * time.Second // close connections silent for longer
+	socketPingPeriod = socketPongWait * 9 / 10
+	socketWriteWait  = 10 * time.Second
+)
+
+// socketUpgrader upgrades device connections. Devices are not browsers, so
+// any origin is accepted, as with CORS.
+var socketUpgrader = websocket.FastHTTPUpgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*fasthttp.RequestCtx) bool { return true },
+}
+
+// DeviceSocket handles GET /devices/{device_id}/ws. The device sends
+// heartbeat and stats frames as JSON text messages and gets an ack or error
+// reply for each. While connected, the device counts as online.
+func (h *DeviceHandler) DeviceSocket(c *fiber.Ctx) error {
+	// The connection outlives the request
+	deviceID := strings.Clone(c.Params("device_id"))
+
+	if !h.store.DeviceExists(deviceID) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NotFoundResponse{
+			Msg: "Device not found",
+		})
+	}
+	if !websocket.FastHTTPIsWebSocketUpgrade(c.Context()) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(models.ErrorResponse{
+			Msg: "Expected a WebSocket upgrade request",
+		})
+	}
+
+	return socketUpgrader.Upgrade(c.Context(), func(conn *websocket.Conn) {
+		h.status.Connect(deviceID)
+		defer h.status.Disconnect(deviceID)
+		defer conn.Close()
+
+		if err := h.serveSocket(conn, deviceID); err != nil {
+			log.Printf("WebSocket of device %s closed: %v", deviceID, err)
+		}
+	})
+}
+
+// serveSocket reads frames until the connection closes, replying to each
+func (h *DeviceHandler) serveSocket(conn *websocket.Conn, deviceID string) error {
+	conn.SetReadLimit(socketMaxFrame)
+	_ = conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	})
+
+	// Ping the device so dead connections are noticed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(socketPingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(socketPongWait))
+
+		reply, err := h.handleFrame(deviceID, messageType, data)
+		_ = conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		if writeErr := conn.WriteJSON(reply); writeErr != nil {
+			return writeErr
+		}
+		if errors.Is(err, storage.ErrDeviceNotFound) {
+			// Deregistered while connected
+			message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Device not found")
+			_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteWait))
+			return nil
+		}
+	}
+}
+
+// handleFrame validates and stores a frame, returning the reply and the
+// error that caused a rejection, if any
+func (h *DeviceHandler) handleFrame(deviceID string, messageType int, data []byte) (models.FrameReply, error) {
+	if messageType != websocket.TextMessage {
+		return frameError("", "Expected a JSON text message"), fmt.Errorf("unexpected message type %d", messageType)
+	}
+
+	var frame models.DeviceFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return frameError("", "Invalid frame"), err
+	}
+	if err := frame.Validate(); err != nil {
+		return frameError(frame.ID, err.Error()), err
+	}
+
+	var err error
+	if frame.Type == models.ReportHeartbeat {
+		err = h.store.AddHeartbeat(deviceID, frame.SentAt)
+	} else {
+		err = h.store.AddUploadTime(deviceID, frame.SentAt, *frame.UploadTime)
+	}
+	switch {
+	case errors.Is(err, storage.ErrDeviceNotFound):
+		return frameError(frame.ID, "Device not found"), err
+	case err != nil:
+		return frameError(frame.ID, fmt.Sprintf("Failed to store %s: %v", frame.Type, err)), err
+	}
+	return models.FrameReply{Type: "ack", ID: frame.ID}, nil
+}
+
+// frameError builds an error reply
+func frameError(id, msg string) models.FrameReply {
+	return models.FrameReply{Type: "error", ID: id, Msg: msg}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestDeviceSocket(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	handler := newTestDeviceHandler(store)
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/devices/:device_id/ws", handler.DeviceSocket)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+	baseURL := "ws://" + listener.Addr().String()
+
+	if _, resp, err := websocket.DefaultDialer.Dial(baseURL+"/devices/unknown/ws", nil); err == nil || resp.StatusCode != fiber.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown device, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(baseURL+"/devices/device-1/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		frame    string
+		expected models.FrameReply
+	}{
+		{
+			name:     "Heartbeat",
+			frame:    `{"id":"1","type":"heartbeat","sent_at":"2025-01-01T00:00:00Z"}`,
+			expected: models.FrameReply{Type: "ack", ID: "1"},
+		},
+		{
+			name:     "Stats",
+			frame:    `{"id":"2","type":"stats","sent_at":"2025-01-01T00:01:00Z","upload_time":2000000000}`,
+			expected: models.FrameReply{Type: "ack", ID: "2"},
+		},
+		{
+			name:     "Stats without upload time",
+			frame:    `{"id":"3","type":"stats","sent_at":"2025-01-01T00:01:00Z"}`,
+			expected: models.FrameReply{Type: "error", ID: "3", Msg: "upload_time is required for stats"},
+		},
+		{
+			name:     "Invalid JSON",
+			frame:    `{"id":`,
+			expected: models.FrameReply{Type: "error", Msg: "Invalid frame"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tc.frame)); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+			var reply models.FrameReply
+			if err := conn.ReadJSON(&reply); err != nil {
+				t.Fatalf("ReadJSON failed: %v", err)
+			}
+			if reply != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, reply)
+			}
+		})
+	}
+
+	aggregates, err := store.GetAggregates("device-1")
+	if err != nil || aggregates.HeartbeatCount != 1 || aggregates.UploadCount != 1 {
+		t.Errorf("Expected one heartbeat and one upload to be stored, got %+v", aggregates)
+	}
+
+	// A device that stopped sending heartbeats long ago is online while connected
+	if status, err := handler.status.Status("device-1"); err != nil || status.Status != storage.StatusOnline || !status.Connected {
+		t.Errorf("Expected a connected online device, got %+v", status)
+	}
+
+	// Closing the connection disconnects the device
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, err := handler.status.Status("device-1")
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if !status.Connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the device to be disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Deregistering the device closes its connection
+	conn, _, err = websocket.DefaultDialer.Dial(baseURL+"/devices/device-1/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if err := store.DeregisterDevice("device-1", true); err != nil {
+		t.Fatalf("DeregisterDevice failed: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"4","type":"heartbeat","sent_at":"2025-01-01T00:02:00Z"}`)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	var reply models.FrameReply
+	if err := conn.ReadJSON(&reply); err != nil || reply.Type != "error" || reply.Msg != "Device not found" {
+		t.Errorf("Expected a device not found error, got %+v (%v)", reply, err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+}
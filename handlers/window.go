@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// parseTimeWindow reads the optional RFC3339 "from" and "to" query
+// parameters. A missing parameter leaves that side of the window open.
+func parseTimeWindow(c *fiber.Ctx) (storage.TimeWindow, error) {
+	var window storage.TimeWindow
+
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{name: "from", dst: &window.From},
+		{name: "to", dst: &window.To},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return window, fmt.Errorf("Invalid '%s' query parameter: expected RFC3339 timestamp", param.name)
+		}
+		*param.dst = t
+	}
+
+	if !window.From.IsZero() && !window.To.IsZero() && !window.From.Before(window.To) {
+		return window, fmt.Errorf("Invalid time window: 'from' must be before 'to'")
+	}
+
+	return window, nil
+}
+
+// filterHeartbeats returns the heartbeats inside the window
+func filterHeartbeats(heartbeats []time.Time, window storage.TimeWindow) []time.Time {
+	filtered := make([]time.Time, 0, len(heartbeats))
+	for _, heartbeat := range heartbeats {
+		if window.Contains(heartbeat) {
+			filtered = append(filtered, heartbeat)
+		}
+	}
+	return filtered
+}
+
+// filterUploads returns the upload samples inside the window
+func filterUploads(uploads []storage.UploadSample, window storage.TimeWindow) []storage.UploadSample {
+	filtered := make([]storage.UploadSample, 0, len(uploads))
+	for _, upload := range uploads {
+		if window.Contains(upload.SentAt) {
+			filtered = append(filtered, upload)
+		}
+	}
+	return filtered
+}
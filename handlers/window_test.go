@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	testCases := []struct {
+		name        string
+		query       string
+		expectError bool
+		expected    storage.TimeWindow
+	}{
+		{
+			name:     "No window",
+			query:    "",
+			expected: storage.TimeWindow{},
+		},
+		{
+			name:     "From and to",
+			query:    "?from=2025-01-01T00:00:00Z&to=2025-01-01T01:00:00Z",
+			expected: storage.TimeWindow{From: from, To: to},
+		},
+		{
+			name:     "Only from",
+			query:    "?from=2025-01-01T00:00:00Z",
+			expected: storage.TimeWindow{From: from},
+		},
+		{
+			name:        "Invalid timestamp",
+			query:       "?to=yesterday",
+			expectError: true,
+		},
+		{
+			name:        "From after to",
+			query:       "?from=2025-01-01T01:00:00Z&to=2025-01-01T00:00:00Z",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var window storage.TimeWindow
+			var parseErr error
+
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				window, parseErr = parseTimeWindow(c)
+				return nil
+			})
+			if _, err := app.Test(httptest.NewRequest("GET", "/"+tc.query, nil)); err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+
+			if tc.expectError {
+				if parseErr == nil {
+					t.Fatal("Expected error but got nil")
+				}
+				return
+			}
+			if parseErr != nil {
+				t.Fatalf("parseTimeWindow failed: %v", parseErr)
+			}
+			if !window.From.Equal(tc.expected.From) || !window.To.Equal(tc.expected.To) {
+				t.Errorf("Expected %v, got %v", tc.expected, window)
+			}
+		})
+	}
+}
+
+func TestFilterByTimeWindow(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := storage.TimeWindow{From: baseTime.Add(time.Minute), To: baseTime.Add(3 * time.Minute)}
+
+	heartbeats := []time.Time{
+		baseTime,
+		baseTime.Add(1 * time.Minute), // inclusive start
+		baseTime.Add(2 * time.Minute),
+		baseTime.Add(3 * time.Minute), // exclusive end
+	}
+	filtered := filterHeartbeats(heartbeats, window)
+	if len(filtered) != 2 || !filtered[0].Equal(heartbeats[1]) || !filtered[1].Equal(heartbeats[2]) {
+		t.Errorf("Unexpected heartbeats %v", filtered)
+	}
+
+	uploads := []storage.UploadSample{
+		{SentAt: baseTime, UploadTime: 1},
+		{SentAt: baseTime.Add(2 * time.Minute), UploadTime: 2},
+		{SentAt: baseTime.Add(5 * time.Minute), UploadTime: 3},
+	}
+	filteredUploads := filterUploads(uploads, window)
+	if len(filteredUploads) != 1 || filteredUploads[0] != uploads[1] {
+		t.Errorf("Unexpected uploads %v", filteredUploads)
+	}
+
+	if all := filterUploads(uploads, storage.TimeWindow{}); len(all) != 3 {
+		t.Errorf("Expected an open window to keep every sample, got %v", all)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// runImport implements the import subcommand, which adds heartbeats and
+// upload stats from NDJSON files (or stdin) to the file store. It fails if
+// a server has the same data directory open.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [flags] [file.ndjson ...]\n\nReads stdin if no file is given.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	csvFlag := flags.String("csv", "", "Path to devices CSV file")
+	dataDirFlag := flags.String("data-dir", "", "Directory for the file storage backend")
+	walSyncFlag := flags.String("wal-sync", "", "WAL fsync policy: always, batch or interval")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	syncPolicy, err := storage.ParseSyncPolicy(resolveSetting(*walSyncFlag, "WAL_SYNC", string(storage.SyncBatch)))
+	if err != nil {
+		return fmt.Errorf("invalid WAL sync policy: %w", err)
+	}
+	store, err := storage.NewFileStore(resolveSetting(*dataDirFlag, "DATA_DIR", "data"), storage.FileStoreOptions{
+		WAL: storage.WALOptions{SyncPolicy: syncPolicy, SyncInterval: time.Second},
+	})
+	if errors.Is(err, storage.ErrDataDirLocked) {
+		return fmt.Errorf("%w: stop the server or import through POST /api/v1/import", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open file store: %w", err)
+	}
+	defer store.Close()
+
+	if err := store.LoadDevicesFromCSV(resolveSetting(*csvFlag, "DEVICES_CSV", "devices.csv")); err != nil {
+		return fmt.Errorf("failed to load devices from CSV: %w", err)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		if err := importFile(store, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFile imports one NDJSON file, or stdin if path is "-", and logs
+// a summary and the failed lines
+func importFile(store storage.DeviceStore, path string) error {
+	var input io.Reader = os.Stdin
+	name := "stdin"
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer file.Close()
+		input = file
+		name = path
+	}
+
+	// Whoever can run the import can write the data directory anyway, so
+	// records of devices with keys are trusted
+	result, err := storage.Import(store, input, nil)
+	for _, failure := range result.Failures {
+		log.Printf("%s:%d: %s", name, failure.Line, failure.Error)
+	}
+	if result.Truncated {
+		log.Printf("%s: more failed lines not shown", name)
+	}
+	log.Printf("Imported %s: %d accepted, %d rejected", name, result.Accepted, result.Rejected)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -1,37 +1,110 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/vdnguyen58/fleet-monitor/alerting"
+	"github.com/vdnguyen58/fleet-monitor/events"
+	"github.com/vdnguyen58/fleet-monitor/grpcapi"
+	"github.com/vdnguyen58/fleet-monitor/handlers"
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/mqtt"
 	"github.com/vdnguyen58/fleet-monitor/routes"
 	"github.com/vdnguyen58/fleet-monitor/storage"
+	"github.com/vdnguyen58/fleet-monitor/udp"
+	"google.golang.org/grpc"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		return
+	}
+
 	// Define command-line flags
 	csvFlag := flag.String("csv", "", "Path to devices CSV file")
 	portFlag := flag.String("port", "", "Server port number")
+	storeFlag := flag.String("store", "", "Storage backend: memory or file")
+	dataDirFlag := flag.String("data-dir", "", "Directory for the file storage backend")
+	walSyncFlag := flag.String("wal-sync", "", "WAL fsync policy: always, batch or interval")
+	walSyncIntervalFlag := flag.String("wal-sync-interval", "", "WAL fsync interval for the interval policy")
+	retentionFlag := flag.String("retention", "", "How long to keep raw samples, e.g. 720h or 30d (0 keeps them forever)")
+	metricsConfigFlag := flag.String("metrics-config", "", "Path to a JSON or YAML file defining device metrics")
+	csvPollIntervalFlag := flag.String("csv-poll-interval", "", "How often to check the devices CSV for changes if it cannot be watched")
+	statusDegradedFlag := flag.String("status-degraded-after", "", "Heartbeat age after which a device is degraded")
+	statusOfflineFlag := flag.String("status-offline-after", "", "Heartbeat age after which a device is offline")
+	statusIntervalFlag := flag.String("status-interval", "", "How often to evaluate device statuses")
+	mqttPortFlag := flag.String("mqtt-port", "", "Port to accept MQTT device reports on (disabled by default)")
+	grpcPortFlag := flag.String("grpc-port", "", "Port to serve the gRPC API on (disabled by default)")
+	udpPortFlag := flag.String("udp-port", "", "Port to accept compact UDP heartbeats on (disabled by default)")
+	udpSecretFlag := flag.String("udp-secret", "", "HMAC key UDP heartbeats of devices without keys must be signed with (unsigned ones are accepted if unset)")
+	udpRateFlag := flag.String("udp-rate", "", "UDP heartbeats accepted per second per device")
+	eventsBufferFlag := flag.String("events-buffer", "", "How many recent events to keep for event streams to resume from")
+	alertsConfigFlag := flag.String("alerts-config", "", "Path to a JSON or YAML file defining alerting rules and webhooks")
+	credentialsFlag := flag.String("credentials", "", "Path to a JSON file of device keys, written back when keys change")
+	signatureSkewFlag := flag.String("signature-skew", "", "How far signed request timestamps may be from the server clock")
+	maxFutureSkewFlag := flag.String("max-future-skew", "", "How far ahead of the server clock the sent_at of a report may be")
+	requireSignaturesFlag := flag.String("require-signatures", "", "Reject reports of devices without keys on every transport: true or false")
+	adminTokenFlag := flag.String("admin-token", "", "Bearer token required by the admin API (disabled if unset)")
+	prometheusDeviceLimitFlag := flag.String("prometheus-device-limit", "", "Most devices to export Prometheus series for, stalest first (0 exports none)")
+	prometheusDeviceTagFlag := flag.String("prometheus-device-tag", "", "Only export Prometheus series for devices with this tag")
 	flag.Parse()
 
+	// Settings are resolved as: CLI flag > env var > default
+	backend := resolveSetting(*storeFlag, "STORE_BACKEND", "memory")
+	dataDir := resolveSetting(*dataDirFlag, "DATA_DIR", "data")
+
+	syncPolicy, err := storage.ParseSyncPolicy(resolveSetting(*walSyncFlag, "WAL_SYNC", string(storage.SyncAlways)))
+	if err != nil {
+		log.Fatalf("Invalid WAL sync policy: %v", err)
+	}
+	syncInterval, err := time.ParseDuration(resolveSetting(*walSyncIntervalFlag, "WAL_SYNC_INTERVAL", "1s"))
+	if err != nil {
+		log.Fatalf("Invalid WAL sync interval: %v", err)
+	}
+
 	// Initialize device store
-	store := storage.NewDeviceStore()
-
-	// Determine CSV path: CLI flag > env var > default
-	csvPath := *csvFlag
-	if csvPath == "" {
-		csvPath = os.Getenv("DEVICES_CSV")
-		if csvPath == "" {
-			csvPath = "devices.csv" // Default path
-		}
+	store, err := openStore(backend, dataDir, storage.FileStoreOptions{
+		WAL: storage.WALOptions{
+			SyncPolicy:   syncPolicy,
+			SyncInterval: syncInterval,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to open %s store: %v", backend, err)
+	}
+	log.Printf("Using %s storage backend", backend)
+
+	// Count accepted reports for /metrics
+	counting := storage.NewCountingStore(store)
+	store = counting
+
+	// Publish accepted reports to event streams
+	eventsBuffer, err := strconv.Atoi(resolveSetting(*eventsBufferFlag, "EVENTS_BUFFER", "10000"))
+	if err != nil || eventsBuffer < 1 {
+		log.Fatalf("Invalid events buffer: expected a positive integer")
 	}
+	bus := events.NewBus(eventsBuffer)
+	store = events.NewPublishingStore(store, bus)
+
+	csvPath := resolveSetting(*csvFlag, "DEVICES_CSV", "devices.csv")
 
 	if err := store.LoadDevicesFromCSV(csvPath); err != nil {
 		log.Fatalf("Failed to load devices from CSV: %v", err)
@@ -39,20 +112,167 @@ func main() {
 
 	log.Printf("Devices loaded successfully from: %s", csvPath)
 
+	// Reload the CSV when it changes or on SIGHUP
+	csvPollInterval, err := time.ParseDuration(resolveSetting(*csvPollIntervalFlag, "CSV_POLL_INTERVAL", "5s"))
+	if err != nil {
+		log.Fatalf("Invalid CSV poll interval: %v", err)
+	}
+	if csvPollInterval <= 0 {
+		log.Fatalf("Invalid CSV poll interval: must be positive")
+	}
+	reloader := storage.NewCSVWatcher(store, csvPath, csvPollInterval)
+	reloader.Start()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloader.Reload(storage.TriggerSignal)
+		}
+	}()
+
+	// Load metric definitions
+	metrics, err := loadMetrics(resolveSetting(*metricsConfigFlag, "METRICS_CONFIG", ""))
+	if err != nil {
+		log.Fatalf("Failed to load metrics config: %v", err)
+	}
+
+	// Prune old samples in the background if a retention window is set
+	retention, err := storage.ParseRetention(resolveSetting(*retentionFlag, "RETENTION", "0"))
+	if err != nil {
+		log.Fatalf("Invalid retention: %v", err)
+	}
+	if retention < 0 {
+		log.Fatalf("Invalid retention: must not be negative")
+	}
+	var janitor *storage.Janitor
+	if shortest := shortestRetention(retention, metrics); shortest > 0 {
+		janitor = storage.NewJanitor(store, retention, metrics, janitorInterval(shortest))
+		janitor.Start()
+		if retention > 0 {
+			log.Printf("Keeping raw samples for %s", retention)
+		}
+	}
+
+	// Evaluate device statuses in the background
+	status, err := newStatusTracker(store,
+		resolveSetting(*statusDegradedFlag, "STATUS_DEGRADED_AFTER", storage.DefaultStatusThresholds.Degraded.String()),
+		resolveSetting(*statusOfflineFlag, "STATUS_OFFLINE_AFTER", storage.DefaultStatusThresholds.Offline.String()),
+		resolveSetting(*statusIntervalFlag, "STATUS_INTERVAL", "15s"))
+	if err != nil {
+		log.Fatalf("Invalid status settings: %v", err)
+	}
+	status.OnChange(bus.PublishStatusChange)
+	status.Start()
+
+	// Evaluate alerting rules in the background
+	alerts, err := loadAlerts(store, resolveSetting(*alertsConfigFlag, "ALERTS_CONFIG", ""))
+	if err != nil {
+		log.Fatalf("Failed to load alerting config: %v", err)
+	}
+	alerts.OnTransition(bus.PublishAlertTransition)
+	alerts.Start()
+
+	// Device keys to verify signed requests with
+	credentials, err := loadCredentials(resolveSetting(*credentialsFlag, "DEVICE_CREDENTIALS", ""))
+	if err != nil {
+		log.Fatalf("Failed to load device credentials: %v", err)
+	}
+	var signatures handlers.SignatureOptions
+	if signatures.MaxSkew, err = time.ParseDuration(resolveSetting(*signatureSkewFlag, "SIGNATURE_SKEW", handlers.DefaultSignatureSkew.String())); err != nil || signatures.MaxSkew <= 0 {
+		log.Fatalf("Invalid signature skew: expected a positive duration")
+	}
+	requireSignatures, err := strconv.ParseBool(resolveSetting(*requireSignaturesFlag, "REQUIRE_SIGNATURES", "false"))
+	if err != nil {
+		log.Fatalf("Invalid require signatures setting: expected true or false")
+	}
+	credentials.RequireSignatures(requireSignatures)
+
+	// Reports sent further ahead would keep their device online until then
+	if models.MaxFutureSkew, err = time.ParseDuration(resolveSetting(*maxFutureSkewFlag, "MAX_FUTURE_SKEW", models.DefaultMaxFutureSkew.String())); err != nil || models.MaxFutureSkew < 0 {
+		log.Fatalf("Invalid max future skew: expected a non-negative duration")
+	}
+
+	// Accept UDP heartbeats if a port is set
+	var udpServer *udp.Server
+	if udpPort := resolveSetting(*udpPortFlag, "UDP_PORT", ""); udpPort != "" {
+		udpRate, err := strconv.ParseFloat(resolveSetting(*udpRateFlag, "UDP_RATE", strconv.FormatFloat(udp.DefaultRate, 'f', -1, 64)), 64)
+		if err != nil || udpRate <= 0 {
+			log.Fatalf("Invalid UDP rate: expected a positive number")
+		}
+		conn, err := net.ListenPacket("udp", ":"+udpPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for UDP: %v", err)
+		}
+		udpServer = udp.NewServer(store, udp.Options{
+			Credentials: credentials,
+			Secret:      []byte(resolveSetting(*udpSecretFlag, "UDP_SECRET", "")),
+			Rate:        udpRate,
+			MaxSkew:     signatures.MaxSkew,
+		})
+		go func() {
+			if err := udpServer.Serve(conn); !errors.Is(err, udp.ErrServerClosed) {
+				log.Printf("UDP server failed: %v", err)
+			}
+		}()
+		log.Printf("Accepting UDP heartbeats on port %s", udpPort)
+	}
+
+	// Accept MQTT reports if a port is set
+	var mqttServer *mqtt.Server
+	if mqttPort := resolveSetting(*mqttPortFlag, "MQTT_PORT", ""); mqttPort != "" {
+		listener, err := net.Listen("tcp", ":"+mqttPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for MQTT: %v", err)
+		}
+		mqttServer = mqtt.NewServer(store, credentials)
+		go func() {
+			if err := mqttServer.Serve(listener); !errors.Is(err, mqtt.ErrServerClosed) {
+				log.Printf("MQTT server failed: %v", err)
+			}
+		}()
+		log.Printf("Accepting MQTT on port %s", mqttPort)
+	}
+
+	// Per-device Prometheus series are limited for large fleets
+	prometheusDeviceLimit, err := strconv.Atoi(resolveSetting(*prometheusDeviceLimitFlag, "PROMETHEUS_DEVICE_LIMIT", "100"))
+	if err != nil || prometheusDeviceLimit < 0 {
+		log.Fatalf("Invalid Prometheus device limit: expected a non-negative integer")
+	}
+	prometheusOptions := handlers.PrometheusOptions{
+		DeviceLimit: prometheusDeviceLimit,
+		DeviceTag:   resolveSetting(*prometheusDeviceTagFlag, "PROMETHEUS_DEVICE_TAG", ""),
+		Listeners:   make(map[string]handlers.ListenerCounters),
+	}
+	if udpServer != nil {
+		prometheusOptions.Listeners["udp"] = udpServer.Counters
+	}
+	if mqttServer != nil {
+		prometheusOptions.Listeners["mqtt"] = mqttServer.Counters
+	}
+	prometheus := handlers.NewPrometheusHandler(counting, prometheusOptions)
+
 	// Create Fiber app with custom configuration
 	app := fiber.New(fiber.Config{
 		AppName:      "Fleet Management Metrics Server",
 		ServerHeader: "Fiber",
 		ErrorHandler: customErrorHandler,
+		// Let large bodies such as NDJSON imports be read as they arrive
+		StreamRequestBody: true,
 	})
 
 	// Middleware
 	app.Use(recover.New()) // Recover from panics
 	app.Use(logger.New())  // Request logging
 	app.Use(cors.New())    // CORS
+	app.Use(prometheus.Middleware())
+
+	// Only imports and restores read their body as it arrives; other bodies stay limited
+	app.Use(limitRequestBody(fiber.DefaultBodyLimit, "/api/v1/import", "/api/v1/admin/restore"))
 
 	// Setup routes
-	routes.SetupRoutes(app, store)
+	adminToken := resolveSetting(*adminTokenFlag, "ADMIN_TOKEN", "")
+	routes.SetupRoutes(app, store, reloader, metrics, status, alerts, bus, credentials, signatures, adminToken)
 
 	// Health check endpoint
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -61,6 +281,26 @@ func main() {
 		})
 	})
 
+	// Prometheus metrics endpoint
+	app.Get("/metrics", prometheus.GetMetrics)
+
+	// Serve the gRPC API if a port is set
+	var grpcServer *grpc.Server
+	if grpcPort := resolveSetting(*grpcPortFlag, "GRPC_PORT", ""); grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+		grpcServer = grpc.NewServer()
+		grpcapi.RegisterDeviceServiceServer(grpcServer, grpcapi.NewServer(store, credentials))
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Printf("gRPC server failed: %v", err)
+			}
+		}()
+		log.Printf("Serving gRPC on port %s", grpcPort)
+	}
+
 	// Graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -68,26 +308,165 @@ func main() {
 	go func() {
 		<-c
 		log.Println("Gracefully shutting down...")
+		bus.Close() // end event streams so their connections can close
 		_ = app.Shutdown()
 	}()
 
-	// Determine port: CLI flag > env var > default
-	port := *portFlag
-	if port == "" {
-		port = os.Getenv("PORT")
-		if port == "" {
-			port = "6733" // Default port from OpenAPI spec
-		}
-	}
+	port := resolveSetting(*portFlag, "PORT", "6733") // Default port from OpenAPI spec
 
 	log.Printf("Starting server on port %s...", port)
 	if err := app.Listen(":" + port); err != nil {
 		log.Fatal(err)
 	}
 
+	signal.Stop(hup)
+	if mqttServer != nil {
+		mqttServer.Close()
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if udpServer != nil {
+		udpServer.Close()
+	}
+	reloader.Stop()
+	status.Stop()
+	alerts.Stop()
+	if janitor != nil {
+		janitor.Stop()
+	}
+	if err := store.Close(); err != nil {
+		log.Printf("Failed to close store: %v", err)
+	}
+
 	log.Println("Server stopped")
 }
 
+// resolveSetting returns the flag value if set, else the env var, else the default
+func resolveSetting(flagValue, envKey, defaultValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if value := os.Getenv(envKey); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// janitorInterval picks how often to prune for a retention window:
+// 1/24th of the window, between one minute and one hour
+func janitorInterval(retention time.Duration) time.Duration {
+	return min(max(retention/24, time.Minute), time.Hour)
+}
+
+// shortestRetention returns the shortest positive retention window among
+// the global one and those of the configured metrics, or 0 if there is none
+func shortestRetention(retention time.Duration, metrics *storage.MetricRegistry) time.Duration {
+	shortest := retention
+	for _, definition := range metrics.Definitions() {
+		if definition.Retention > 0 && (shortest <= 0 || definition.Retention < shortest) {
+			shortest = definition.Retention
+		}
+	}
+	return shortest
+}
+
+// newStatusTracker creates a status tracker from its settings
+func newStatusTracker(store storage.DeviceStore, degraded, offline, interval string) (*storage.StatusTracker, error) {
+	var thresholds storage.StatusThresholds
+	var err error
+	if thresholds.Degraded, err = time.ParseDuration(degraded); err != nil {
+		return nil, fmt.Errorf("invalid degraded threshold: %w", err)
+	}
+	if thresholds.Offline, err = time.ParseDuration(offline); err != nil {
+		return nil, fmt.Errorf("invalid offline threshold: %w", err)
+	}
+	if err := thresholds.Validate(); err != nil {
+		return nil, err
+	}
+	evaluateEvery, err := time.ParseDuration(interval)
+	if err != nil || evaluateEvery <= 0 {
+		return nil, fmt.Errorf("invalid status interval %q: expected a positive duration", interval)
+	}
+	return storage.NewStatusTracker(store, thresholds, evaluateEvery), nil
+}
+
+// loadMetrics loads metric definitions from path, or returns an empty
+// registry if no path is set
+func loadMetrics(path string) (*storage.MetricRegistry, error) {
+	if path == "" {
+		return storage.NewMetricRegistry()
+	}
+	metrics, err := storage.LoadMetricRegistry(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d metric definitions from %s", len(metrics.Definitions()), path)
+	return metrics, nil
+}
+
+// loadCredentials loads device keys from path, or keeps them in memory
+// only if no path is set
+func loadCredentials(path string) (*storage.Credentials, error) {
+	if path == "" {
+		return storage.NewCredentials(), nil
+	}
+	credentials, err := storage.LoadCredentials(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded device credentials from %s", path)
+	return credentials, nil
+}
+
+// loadAlerts creates the alerting engine from the config at path, or one
+// without rules if no path is set
+func loadAlerts(store storage.DeviceStore, path string) (*alerting.Engine, error) {
+	if path == "" {
+		return alerting.NewEngine(store, alerting.Config{}), nil
+	}
+	config, err := alerting.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d alerting rules and %d webhooks from %s", len(config.Rules), len(config.Webhooks), path)
+	return alerting.NewEngine(store, config), nil
+}
+
+// openStore creates the device store for the selected backend
+func openStore(backend, dataDir string, opts storage.FileStoreOptions) (storage.DeviceStore, error) {
+	switch backend {
+	case "memory":
+		return storage.NewMemoryStore(), nil
+	case "file":
+		return storage.NewFileStore(dataDir, opts)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// limitRequestBody reads request bodies of up to limit bytes into memory,
+// as fiber does when it does not stream them, except on the given paths.
+// Larger bodies are rejected.
+func limitRequestBody(limit int, streamed ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stream := c.Context().RequestBodyStream()
+		if stream == nil || slices.Contains(streamed, c.Path()) {
+			return c.Next()
+		}
+
+		body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Failed to read request body")
+		}
+		if len(body) > limit {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
+}
+
 // customErrorHandler handles errors returned from handlers
 func customErrorHandler(c *fiber.Ctx, err error) error {
 	code := fiber.StatusInternalServerError
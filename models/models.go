@@ -28,3 +28,281 @@ type ErrorResponse struct {
 type NotFoundResponse struct {
 	Msg string `json:"msg"`
 }
+
+// GetDeviceStatsSeriesResponse represents downsampled device statistics
+type GetDeviceStatsSeriesResponse struct {
+	Step    string        `json:"step"` // bucket width as a duration string like "1h0m0s"
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Buckets []StatsBucket `json:"buckets"`
+}
+
+// StatsBucket represents device statistics for a single time bucket.
+// Buckets without samples are still returned so gaps are visible.
+type StatsBucket struct {
+	Start         time.Time `json:"start"`
+	Heartbeats    int       `json:"heartbeats"`
+	Uptime        float64   `json:"uptime"` // percentage, 0 when no heartbeats
+	Uploads       int       `json:"uploads"`
+	AvgUploadTime *string   `json:"avg_upload_time"` // null when no uploads
+	MinUploadTime *string   `json:"min_upload_time"`
+	MaxUploadTime *string   `json:"max_upload_time"`
+}
+
+// DeviceMetadata describes a device
+type DeviceMetadata struct {
+	Model      string            `json:"model,omitempty"`
+	Firmware   string            `json:"firmware,omitempty"`
+	Site       string            `json:"site,omitempty"`
+	Region     string            `json:"region,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"` // extra columns from the devices CSV
+}
+
+// RegisterDeviceRequest represents a runtime device registration
+type RegisterDeviceRequest struct {
+	DeviceID    string         `json:"device_id" validate:"required"`
+	Metadata    DeviceMetadata `json:"metadata"`
+	GenerateKey bool           `json:"generate_key"` // provision a key to sign requests with
+}
+
+// DeviceResponse represents a registered device
+type DeviceResponse struct {
+	DeviceID     string         `json:"device_id"`
+	Source       string         `json:"source"` // "csv" or "api"
+	Metadata     DeviceMetadata `json:"metadata"`
+	RegisteredAt time.Time      `json:"registered_at"`
+	RetiredAt    *time.Time     `json:"retired_at,omitempty"` // set once deregistered with retained data
+	Status       *DeviceStatus  `json:"status,omitempty"`     // omitted for retired devices
+	Key          *DeviceKey     `json:"key,omitempty"`        // only on registration with generate_key
+}
+
+// DeviceStatus represents the liveness of a device
+type DeviceStatus struct {
+	Status        string             `json:"status"` // "online", "degraded", "offline" or "never_seen"
+	Since         *time.Time         `json:"since,omitempty"`
+	LastHeartbeat *time.Time         `json:"last_heartbeat,omitempty"`
+	Connected     bool               `json:"connected,omitempty"`   // holds a WebSocket connection
+	Transitions   []StatusTransition `json:"transitions,omitempty"` // latest last, only on single devices
+}
+
+// StatusTransition represents a change of a device's status
+type StatusTransition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// ListDevicesResponse represents a page of registered devices
+type ListDevicesResponse struct {
+	Devices    []DeviceResponse `json:"devices"`
+	Total      int              `json:"total"`                 // devices matching the query across all pages
+	NextCursor string           `json:"next_cursor,omitempty"` // pass as cursor to fetch the next page
+}
+
+// ReloadResponse represents the outcome of a devices CSV reload
+type ReloadResponse struct {
+	Trigger   string    `json:"trigger"` // "file_change", "signal" or "api"
+	Time      time.Time `json:"time"`
+	Added     []string  `json:"added"`   // new or reactivated devices
+	Retired   []string  `json:"retired"` // devices no longer listed
+	Updated   []string  `json:"updated"` // devices whose metadata changed
+	Unchanged int       `json:"unchanged"`
+	Error     string    `json:"error,omitempty"` // set if the reload failed and nothing changed
+}
+
+// GetFleetStatsResponse represents fleet-wide statistics, optionally split
+// into groups of devices sharing a metadata value
+type GetFleetStatsResponse struct {
+	FleetStats
+	GroupBy string            `json:"group_by,omitempty"`
+	Groups  []FleetStatsGroup `json:"groups,omitempty"`
+}
+
+// FleetStats represents statistics over a set of devices
+type FleetStats struct {
+	Devices       int                 `json:"devices"`
+	NoData        int                 `json:"no_data"` // devices without heartbeats or uploads
+	Uptime        *UptimeDistribution `json:"uptime"`  // null when no device has data
+	AvgUploadTime string              `json:"avg_upload_time"`
+}
+
+// UptimeDistribution represents the spread of device uptime percentages
+type UptimeDistribution struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Min  float64 `json:"min"`
+}
+
+// FleetStatsGroup represents the statistics of one group of devices
+type FleetStatsGroup struct {
+	Key string `json:"key"` // metadata value shared by the group, empty if unset
+	FleetStats
+}
+
+// PostMetricsRequest represents a batch of metric samples from a device
+type PostMetricsRequest struct {
+	Samples []MetricSample `json:"samples" validate:"required"`
+}
+
+// MetricSample represents a single reported metric value
+type MetricSample struct {
+	Name      string    `json:"name" validate:"required"`
+	Type      string    `json:"type"` // gauge, counter or histogram; optional for configured metrics
+	Unit      string    `json:"unit,omitempty"`
+	Timestamp time.Time `json:"timestamp"` // defaults to when the batch is received
+	Value     float64   `json:"value"`
+}
+
+// GetMetricResponse represents the aggregations of a device metric
+type GetMetricResponse struct {
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Unit          string    `json:"unit,omitempty"`
+	Aggregation   string    `json:"aggregation"` // the aggregation reported as value
+	Value         float64   `json:"value"`
+	Count         int       `json:"count"`
+	Sum           float64   `json:"sum"`
+	Min           float64   `json:"min"`
+	Max           float64   `json:"max"`
+	Avg           float64   `json:"avg"`
+	Last          float64   `json:"last"` // value of the latest sample
+	LastTimestamp time.Time `json:"last_timestamp"`
+	Rate          float64   `json:"rate"` // sum per second between the first and latest sample
+	P50           float64   `json:"p50"`
+	P90           float64   `json:"p90"`
+	P99           float64   `json:"p99"`
+}
+
+// BatchRequest represents buffered telemetry from a device
+type BatchRequest struct {
+	Items []BatchItem `json:"items" validate:"required"`
+}
+
+// BatchItem represents a single heartbeat or upload stats report in a batch
+type BatchItem struct {
+	Type       string    `json:"type" validate:"required"` // "heartbeat" or "stats"
+	SentAt     time.Time `json:"sent_at" validate:"required"`
+	UploadTime *int64    `json:"upload_time,omitempty"` // nanoseconds, required for stats
+}
+
+// IngestRequest represents buffered telemetry from several devices, keyed
+// by device ID
+type IngestRequest struct {
+	Devices map[string][]BatchItem `json:"devices" validate:"required"`
+}
+
+// BatchResponse represents the outcome of a batch for one device
+type BatchResponse struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Error    string            `json:"error,omitempty"` // set if the whole batch was rejected
+	Results  []BatchItemResult `json:"results"`
+}
+
+// BatchItemResult represents the outcome of a single batch item
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // "accepted" or "rejected"
+	Error  string `json:"error,omitempty"`
+}
+
+// IngestResponse represents the outcome of a fleet-wide batch, keyed by
+// device ID
+type IngestResponse struct {
+	Accepted int                      `json:"accepted"`
+	Rejected int                      `json:"rejected"`
+	Devices  map[string]BatchResponse `json:"devices"`
+}
+
+// ImportResponse represents the outcome of an NDJSON import
+type ImportResponse struct {
+	Accepted  int             `json:"accepted"`
+	Rejected  int             `json:"rejected"`
+	Failures  []ImportFailure `json:"failures"`
+	Truncated bool            `json:"truncated,omitempty"` // more lines failed than are listed
+	Error     string          `json:"error,omitempty"`     // set if the import stopped early
+}
+
+// ImportFailure represents a rejected import line
+type ImportFailure struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ExportRecord represents an exported heartbeat or upload stats report. It
+// uses the same layout as NDJSON import records.
+type ExportRecord struct {
+	DeviceID   string    `json:"device_id"`
+	Type       string    `json:"type"` // "heartbeat" or "stats"
+	SentAt     time.Time `json:"sent_at"`
+	UploadTime *int64    `json:"upload_time,omitempty"` // nanoseconds, only for stats
+}
+
+// AlertResponse represents a pending or firing alert
+type AlertResponse struct {
+	Rule        string     `json:"rule"`
+	DeviceID    string     `json:"device_id"`
+	State       string     `json:"state"` // "pending" or "firing"
+	Value       float64    `json:"value"`
+	ActiveSince time.Time  `json:"active_since"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"` // when the alert started firing
+	Silenced    bool       `json:"silenced"`
+}
+
+// SilenceRequest represents a request to silence alerts. An empty rule or
+// device ID matches every rule or device.
+type SilenceRequest struct {
+	Rule     string     `json:"rule"`
+	DeviceID string     `json:"device_id"`
+	StartsAt *time.Time `json:"starts_at"` // defaults to now
+	EndsAt   *time.Time `json:"ends_at"`   // required unless duration is set
+	Duration string     `json:"duration"`  // e.g. "2h", an alternative to ends_at
+	Comment  string     `json:"comment"`
+}
+
+// SilenceResponse represents a silence
+type SilenceResponse struct {
+	ID       string     `json:"id"`
+	Rule     string     `json:"rule,omitempty"`
+	DeviceID string     `json:"device_id,omitempty"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	Comment  string     `json:"comment,omitempty"`
+}
+
+// DeviceFrame represents a heartbeat or upload stats report sent over a
+// device's WebSocket connection
+type DeviceFrame struct {
+	ID string `json:"id,omitempty"` // echoed in the reply
+	BatchItem
+}
+
+// FrameReply represents the reply to a device frame
+type FrameReply struct {
+	Type string `json:"type"` // "ack" or "error"
+	ID   string `json:"id,omitempty"`
+	Msg  string `json:"msg,omitempty"`
+}
+
+// RotateKeyRequest represents a request to create a new device key
+type RotateKeyRequest struct {
+	Overlap string `json:"overlap"` // how long older keys stay valid, e.g. "24h"
+}
+
+// DeviceKey represents a key a device signs its requests with. The secret
+// is only returned when the key is created.
+type DeviceKey struct {
+	KeyID     string     `json:"key_id"`
+	Secret    string     `json:"secret,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// DeviceKeysResponse represents the active keys of a device
+type DeviceKeysResponse struct {
+	Keys []DeviceKey `json:"keys"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Report types
+const (
+	ReportHeartbeat = "heartbeat"
+	ReportStats     = "stats"
+)
+
+// DefaultMaxFutureSkew is how far ahead of the server clock the sent_at of
+// a report may be by default
+const DefaultMaxFutureSkew = 5 * time.Minute
+
+// MaxFutureSkew is how far ahead of the server clock the sent_at of a
+// report may be. Reports from further ahead would keep their device online
+// and its heartbeats young until then. Set it before reports are accepted.
+var MaxFutureSkew = DefaultMaxFutureSkew
+
+// now is the server clock reports are checked against
+var now = time.Now
+
+// Validate checks that an item has the fields its type needs and was not
+// sent in the future. Reports are validated with it whichever transport
+// they arrive on.
+func (item BatchItem) Validate() error {
+	switch item.Type {
+	case ReportHeartbeat:
+	case ReportStats:
+		if item.UploadTime == nil {
+			return fmt.Errorf("upload_time is required for stats")
+		}
+		if *item.UploadTime < 0 {
+			return fmt.Errorf("upload_time must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown type %q: expected heartbeat or stats", item.Type)
+	}
+
+	if item.SentAt.IsZero() {
+		return fmt.Errorf("sent_at is required")
+	}
+	if item.SentAt.After(now().Add(MaxFutureSkew)) {
+		return fmt.Errorf("sent_at is more than %v ahead of the server clock", MaxFutureSkew)
+	}
+	return nil
+}
+
+// Validate checks the fields of a heartbeat like those of a batch item
+func (req HeartbeatRequest) Validate() error {
+	return BatchItem{Type: ReportHeartbeat, SentAt: req.SentAt}.Validate()
+}
+
+// Validate checks the fields of upload stats like those of a batch item
+func (req UploadStatsRequest) Validate() error {
+	return BatchItem{Type: ReportStats, SentAt: req.SentAt, UploadTime: &req.UploadTime}.Validate()
+}
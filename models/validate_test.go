@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchItemValidate(t *testing.T) {
+	sentAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return sentAt }
+	t.Cleanup(func() { now = time.Now })
+	uploadTime := func(v int64) *int64 { return &v }
+
+	testCases := []struct {
+		name      string
+		item      BatchItem
+		expectErr bool
+	}{
+		{name: "Heartbeat", item: BatchItem{Type: ReportHeartbeat, SentAt: sentAt}},
+		{name: "Stats", item: BatchItem{Type: ReportStats, SentAt: sentAt, UploadTime: uploadTime(1500)}},
+		{name: "Zero upload time", item: BatchItem{Type: ReportStats, SentAt: sentAt, UploadTime: uploadTime(0)}},
+		{name: "Missing sent_at", item: BatchItem{Type: ReportHeartbeat}, expectErr: true},
+		{name: "Missing upload_time", item: BatchItem{Type: ReportStats, SentAt: sentAt}, expectErr: true},
+		{name: "Negative upload_time", item: BatchItem{Type: ReportStats, SentAt: sentAt, UploadTime: uploadTime(-1)}, expectErr: true},
+		{name: "Unknown type", item: BatchItem{Type: "reboot", SentAt: sentAt}, expectErr: true},
+		{name: "Sent ahead within the skew", item: BatchItem{Type: ReportHeartbeat, SentAt: sentAt.Add(MaxFutureSkew)}},
+		{name: "Sent too far ahead", item: BatchItem{Type: ReportHeartbeat, SentAt: sentAt.Add(MaxFutureSkew + time.Second)}, expectErr: true},
+		{name: "Stats sent too far ahead", item: BatchItem{Type: ReportStats, SentAt: sentAt.Add(24 * time.Hour), UploadTime: uploadTime(1500)}, expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.item.Validate(); (err != nil) != tc.expectErr {
+				t.Errorf("Expected error %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
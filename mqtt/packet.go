@@ -0,0 +1,206 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types of MQTT 3.1.1
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// CONNACK return codes
+const (
+	connackAccepted           byte = 0
+	connackBadProtocolVersion byte = 1
+	connackIdentifierRejected byte = 2
+)
+
+// subackFailure is the SUBACK return code refusing a subscription
+const subackFailure byte = 0x80
+
+var (
+	errPacketTooLarge = errors.New("packet too large")
+	errMalformed      = errors.New("malformed packet")
+)
+
+// packet is a control packet with its fixed header split off
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads a control packet whose remaining length is at most
+// maxSize bytes
+func readPacket(r *bufio.Reader, maxSize int) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	// The remaining length is a variable byte integer of up to four bytes
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errMalformed
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxSize {
+		return packet{}, errPacketTooLarge
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// writePacket writes a control packet
+func writePacket(w io.Writer, kind, flags byte, body []byte) error {
+	buf := []byte{kind<<4 | flags}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		buf = append(buf, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// decoder reads the fields of a packet body
+type decoder struct {
+	body []byte
+	err  error
+}
+
+func (d *decoder) uint8() byte {
+	if d.err != nil || len(d.body) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	value := d.body[0]
+	d.body = d.body[1:]
+	return value
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.body) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	value := binary.BigEndian.Uint16(d.body)
+	d.body = d.body[2:]
+	return value
+}
+
+// bytes reads a length-prefixed field
+func (d *decoder) bytes() []byte {
+	length := int(d.uint16())
+	if d.err != nil || len(d.body) < length {
+		d.err = errMalformed
+		return nil
+	}
+	value := d.body[:length]
+	d.body = d.body[length:]
+	return value
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// connect is the part of a CONNECT packet the server uses
+type connect struct {
+	protocol  string
+	level     byte
+	keepAlive uint16 // seconds, 0 disables keep alive
+	clientID  string
+}
+
+// parseConnect decodes a CONNECT packet body
+func parseConnect(body []byte) (connect, error) {
+	d := &decoder{body: body}
+	var c connect
+	c.protocol = d.string()
+	c.level = d.uint8()
+	flags := d.uint8()
+	c.keepAlive = d.uint16()
+	c.clientID = d.string()
+	if flags&0x04 != 0 { // will
+		d.string()
+		d.bytes()
+	}
+	if flags&0x80 != 0 { // username
+		d.string()
+	}
+	if flags&0x40 != 0 { // password
+		d.bytes()
+	}
+	if d.err != nil || flags&0x01 != 0 {
+		return c, errMalformed
+	}
+	return c, nil
+}
+
+// publish is a PUBLISH packet
+type publish struct {
+	topic    string
+	qos      byte
+	packetID uint16
+	payload  []byte
+}
+
+// parsePublish decodes a PUBLISH packet
+func parsePublish(p packet) (publish, error) {
+	d := &decoder{body: p.body}
+	msg := publish{qos: (p.flags >> 1) & 0x03}
+	msg.topic = d.string()
+	if msg.qos > 0 {
+		msg.packetID = d.uint16()
+	}
+	if d.err != nil || msg.qos > 2 {
+		return msg, fmt.Errorf("%w: invalid PUBLISH", errMalformed)
+	}
+	msg.payload = d.body
+	return msg, nil
+}
+
+// packetIDBody encodes a body holding just a packet identifier
+func packetIDBody(id uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, id)
+}
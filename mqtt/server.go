@@ -0,0 +1,353 @@
+// Package mqtt is an embedded MQTT 3.1.1 listener that accepts device
+// telemetry published on devices/{device_id}/heartbeat and
+// devices/{device_id}/stats. It only receives: subscriptions are refused
+// and nothing is retained or forwarded.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/models"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+const (
+	maxPacketSize  = 64 * 1024        // largest packet a device may send
+	connectTimeout = 10 * time.Second // to send CONNECT after connecting
+
+	// rejectionLogInterval is the least time between logs of rejected
+	// messages, so a misbehaving device cannot flood the logs
+	rejectionLogInterval = time.Minute
+)
+
+// Topic suffixes of the reports
+const (
+	topicHeartbeat = "heartbeat"
+	topicStats     = "stats"
+)
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("mqtt: server closed")
+
+// Stats counts the reports published to the server
+type Stats struct {
+	Accepted int64
+	Rejected int64 // unknown topics or devices, invalid payloads and devices that must sign
+}
+
+// Server accepts MQTT connections from devices and stores the reports they
+// publish, parsed and validated like the REST API's. Messages are not
+// signed, so reports of devices that must sign theirs are rejected.
+type Server struct {
+	store       storage.DeviceStore
+	credentials *storage.Credentials
+	now         func() time.Time
+	accepted    atomic.Int64
+	rejected    atomic.Int64
+
+	logMu    sync.Mutex // guards lastLog and unlogged
+	lastLog  time.Time
+	unlogged int64 // rejections since the last log
+
+	mu       sync.Mutex // guards listener, conns and closed
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	sessions sync.WaitGroup
+}
+
+// NewServer creates a server that writes into store, checking in
+// credentials which devices must sign their reports
+func NewServer(store storage.DeviceStore, credentials *storage.Credentials) *Server {
+	return &Server{
+		store:       store,
+		credentials: credentials,
+		now:         time.Now,
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on listener until Close is called
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.sessions.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// them to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.sessions.Wait()
+	return err
+}
+
+// Stats returns how many reports were accepted and rejected
+func (s *Server) Stats() Stats {
+	return Stats{Accepted: s.accepted.Load(), Rejected: s.rejected.Load()}
+}
+
+// Counters returns the stats by outcome, for /metrics
+func (s *Server) Counters() map[string]int64 {
+	stats := s.Stats()
+	return map[string]int64{
+		"accepted": stats.Accepted,
+		"rejected": stats.Rejected,
+	}
+}
+
+// serveConn runs an MQTT session until the device disconnects or breaks
+// the protocol
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.sessions.Done()
+	}()
+
+	if err := s.session(conn); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("MQTT connection from %s closed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// session handles the packets of one connection
+func (s *Server) session(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	_ = conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	p, err := readPacket(r, maxPacketSize)
+	if err != nil {
+		return err
+	}
+	if p.kind != packetConnect {
+		return fmt.Errorf("expected CONNECT, got packet type %d", p.kind)
+	}
+	c, err := parseConnect(p.body)
+	if err != nil {
+		return err
+	}
+	if (c.protocol != "MQTT" || c.level != 4) && (c.protocol != "MQIsdp" || c.level != 3) {
+		_ = writePacket(conn, packetConnack, 0, []byte{0, connackBadProtocolVersion})
+		return fmt.Errorf("unsupported protocol %s level %d", c.protocol, c.level)
+	}
+	if err := writePacket(conn, packetConnack, 0, []byte{0, connackAccepted}); err != nil {
+		return err
+	}
+
+	// Devices must send something every keep alive period, with some grace
+	keepAlive := time.Duration(c.keepAlive) * time.Second * 3 / 2
+	inflight := make(map[uint16]bool) // QoS 2 packets awaiting PUBREL
+
+	for {
+		deadline := time.Time{}
+		if keepAlive > 0 {
+			deadline = time.Now().Add(keepAlive)
+		}
+		_ = conn.SetReadDeadline(deadline)
+
+		p, err := readPacket(r, maxPacketSize)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch p.kind {
+		case packetPublish:
+			msg, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			switch msg.qos {
+			case 0:
+				s.handlePublish(msg)
+			case 1:
+				s.handlePublish(msg)
+				err = writePacket(conn, packetPuback, 0, packetIDBody(msg.packetID))
+			case 2:
+				// Redelivered packets were already stored
+				if !inflight[msg.packetID] {
+					s.handlePublish(msg)
+					inflight[msg.packetID] = true
+				}
+				err = writePacket(conn, packetPubrec, 0, packetIDBody(msg.packetID))
+			}
+		case packetPubrel:
+			d := &decoder{body: p.body}
+			id := d.uint16()
+			if d.err != nil {
+				return d.err
+			}
+			delete(inflight, id)
+			err = writePacket(conn, packetPubcomp, 0, packetIDBody(id))
+		case packetSubscribe:
+			err = s.refuseSubscribe(conn, p.body)
+		case packetUnsubscribe:
+			d := &decoder{body: p.body}
+			id := d.uint16()
+			if d.err != nil {
+				return d.err
+			}
+			err = writePacket(conn, packetUnsuback, 0, packetIDBody(id))
+		case packetPingreq:
+			err = writePacket(conn, packetPingresp, 0, nil)
+		case packetDisconnect:
+			return nil
+		default:
+			return fmt.Errorf("unexpected packet type %d", p.kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// refuseSubscribe answers a SUBSCRIBE with a failure for every topic filter
+func (s *Server) refuseSubscribe(conn net.Conn, body []byte) error {
+	d := &decoder{body: body}
+	id := d.uint16()
+	codes := packetIDBody(id)
+	for d.err == nil && len(d.body) > 0 {
+		d.string()
+		d.uint8()
+		codes = append(codes, subackFailure)
+	}
+	if d.err != nil || len(codes) == 2 {
+		return errMalformed
+	}
+	return writePacket(conn, packetSuback, 0, codes)
+}
+
+// handlePublish stores the report in a PUBLISH packet. MQTT has no way to
+// reject a message, so rejections are counted and logged.
+func (s *Server) handlePublish(msg publish) {
+	if err := storeReport(s.store, s.credentials, msg.topic, msg.payload); err != nil {
+		s.rejected.Add(1)
+		s.logRejection(msg.topic, err)
+		return
+	}
+	s.accepted.Add(1)
+}
+
+// logRejection logs a rejected message, at most once per
+// rejectionLogInterval along with how many were rejected since the last
+// log; the rest are only counted
+func (s *Server) logRejection(topic string, err error) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	s.unlogged++
+	now := s.now()
+	if now.Sub(s.lastLog) < rejectionLogInterval {
+		return
+	}
+	if s.unlogged > 1 {
+		log.Printf("MQTT message on %s rejected: %v (%d messages rejected since the last log)", topic, err, s.unlogged)
+	} else {
+		log.Printf("MQTT message on %s rejected: %v", topic, err)
+	}
+	s.lastLog = now
+	s.unlogged = 0
+}
+
+// parseTopic splits devices/{device_id}/{kind} topics
+func parseTopic(topic string) (deviceID, kind string, ok bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 3 || parts[0] != "devices" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// storeReport validates a report payload and stores it
+func storeReport(store storage.DeviceStore, credentials *storage.Credentials, topic string, payload []byte) error {
+	deviceID, kind, ok := parseTopic(topic)
+	if !ok {
+		return fmt.Errorf("unknown topic: expected devices/{device_id}/heartbeat or devices/{device_id}/stats")
+	}
+	if credentials.SignatureRequired(deviceID) {
+		return storage.ErrSignatureRequired
+	}
+
+	switch kind {
+	case topicHeartbeat:
+		var req models.HeartbeatRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if err := req.Validate(); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if !store.DeviceExists(deviceID) {
+			return storage.ErrDeviceNotFound
+		}
+		return store.AddHeartbeat(deviceID, req.SentAt)
+	case topicStats:
+		var req models.UploadStatsRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if err := req.Validate(); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if !store.DeviceExists(deviceID) {
+			return storage.ErrDeviceNotFound
+		}
+		return store.AddUploadTime(deviceID, req.SentAt, req.UploadTime)
+	default:
+		return fmt.Errorf("unknown report %q: expected heartbeat or stats", kind)
+	}
+}
@@ -0,0 +1,189 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// startServer serves MQTT on a local port until the test ends
+func startServer(t *testing.T, store storage.DeviceStore, credentials *storage.Credentials) (*Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := NewServer(store, credentials)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-served; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Expected Serve to return ErrServerClosed, got %v", err)
+		}
+	})
+	return server, "tcp://" + listener.Addr().String()
+}
+
+// dial connects an MQTT client to the server
+func dial(t *testing.T, broker, clientID string) paho.Client {
+	t.Helper()
+	client := paho.NewClient(paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetKeepAlive(2 * time.Second).
+		SetAutoReconnect(false))
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("Connect failed: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+func TestServer(t *testing.T) {
+	store := storage.NewMemoryStore()
+	for _, deviceID := range []string{"device-1", "signed"} {
+		if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: deviceID, Source: storage.SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	credentials := storage.NewCredentials()
+	if _, err := credentials.Rotate("signed", 0); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	server, broker := startServer(t, store, credentials)
+	client := dial(t, broker, "device-1")
+
+	testCases := []struct {
+		name    string
+		topic   string
+		qos     byte
+		payload string
+	}{
+		{name: "Heartbeat at QoS 0", topic: "devices/device-1/heartbeat", qos: 0, payload: `{"sent_at":"2025-01-01T00:00:00Z"}`},
+		{name: "Heartbeat at QoS 1", topic: "devices/device-1/heartbeat", qos: 1, payload: `{"sent_at":"2025-01-01T00:01:00Z"}`},
+		{name: "Stats at QoS 2", topic: "devices/device-1/stats", qos: 2, payload: `{"sent_at":"2025-01-01T00:01:00Z","upload_time":2000000000}`},
+		{name: "Unknown device", topic: "devices/unknown/heartbeat", qos: 1, payload: `{"sent_at":"2025-01-01T00:00:00Z"}`},
+		{name: "Unknown report", topic: "devices/device-1/reboot", qos: 1, payload: `{}`},
+		{name: "Unknown topic", topic: "sensors/device-1", qos: 1, payload: `{}`},
+		{name: "Invalid payload", topic: "devices/device-1/stats", qos: 1, payload: `{"upload_time":"slow"}`},
+		{name: "Missing sent_at", topic: "devices/device-1/heartbeat", qos: 1, payload: `{}`},
+		{name: "Negative upload time", topic: "devices/device-1/stats", qos: 1, payload: `{"sent_at":"2025-01-01T00:02:00Z","upload_time":-1}`},
+		{name: "Device with keys", topic: "devices/signed/heartbeat", qos: 1, payload: `{"sent_at":"2025-01-01T00:00:00Z"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token := client.Publish(tc.topic, tc.qos, false, tc.payload)
+			if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+				t.Fatalf("Publish failed: %v", token.Error())
+			}
+		})
+	}
+
+	// QoS 0 messages are not acknowledged, but arrive before the later ones
+	aggregates, err := store.GetAggregates("device-1")
+	if err != nil {
+		t.Fatalf("GetAggregates failed: %v", err)
+	}
+	if aggregates.HeartbeatCount != 2 || aggregates.UploadCount != 1 || aggregates.UploadSum != int64(2*time.Second) {
+		t.Errorf("Unexpected aggregates %+v", aggregates)
+	}
+	if aggregates, err := store.GetAggregates("signed"); err != nil || aggregates.HeartbeatCount != 0 {
+		t.Errorf("Expected no heartbeats of a device with keys, got %+v (%v)", aggregates, err)
+	}
+	if stats := server.Stats(); stats != (Stats{Accepted: 3, Rejected: 7}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if counters := server.Counters(); counters["accepted"] != 3 || counters["rejected"] != 7 {
+		t.Errorf("Unexpected counters %v", counters)
+	}
+
+	// Subscriptions are refused but keep the connection open
+	token := client.Subscribe("devices/#", 1, nil)
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatal("Subscribe timed out")
+	}
+	if !client.IsConnected() {
+		t.Error("Expected the client to stay connected after subscribing")
+	}
+}
+
+func TestServerRejectsProtocolErrors(t *testing.T) {
+	_, broker := startServer(t, storage.NewMemoryStore(), storage.NewCredentials())
+
+	testCases := []struct {
+		name   string
+		packet []byte
+	}{
+		{name: "Publish before connect", packet: []byte{packetPublish << 4, 0x03, 0x00, 0x01, 'a'}},
+		{name: "MQTT 5", packet: []byte{packetConnect << 4, 0x0d, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x05, 0x02, 0x00, 0x3c, 0x00, 0x00, 0x00}},
+		{name: "Oversized remaining length", packet: []byte{packetConnect << 4, 0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", broker[len("tcp://"):])
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write(tc.packet); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			// The server closes the connection, after a CONNACK refusal at most
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 16)
+			total := 0
+			for {
+				n, err := conn.Read(buf[total:])
+				total += n
+				if err != nil {
+					var netErr net.Error
+					if errors.As(err, &netErr) && netErr.Timeout() {
+						t.Fatal("Expected the server to close the connection")
+					}
+					break
+				}
+			}
+			if total > 0 && (total != 4 || buf[0] != packetConnack<<4 || buf[3] != connackBadProtocolVersion) {
+				t.Errorf("Expected nothing or a CONNACK refusal, got % x", buf[:total])
+			}
+		})
+	}
+}
+
+func TestLogRejection(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	server := NewServer(storage.NewMemoryStore(), storage.NewCredentials())
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+
+	// Only the first rejection is logged until the interval has passed
+	for range 3 {
+		server.logRejection("devices/device-1/heartbeat", errors.New("invalid payload"))
+	}
+	now = now.Add(rejectionLogInterval)
+	server.logRejection("devices/device-2/heartbeat", errors.New("device not found"))
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 log lines, got %q", lines)
+	}
+	if !strings.HasSuffix(lines[0], "MQTT message on devices/device-1/heartbeat rejected: invalid payload") {
+		t.Errorf("Unexpected first log line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "rejected: device not found (3 messages rejected since the last log)") {
+		t.Errorf("Unexpected second log line %q", lines[1])
+	}
+}
@@ -2,14 +2,23 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/vdnguyen58/fleet-monitor/alerting"
+	"github.com/vdnguyen58/fleet-monitor/events"
 	"github.com/vdnguyen58/fleet-monitor/handlers"
 	"github.com/vdnguyen58/fleet-monitor/storage"
 )
 
 // SetupRoutes configures all application routes
-func SetupRoutes(app *fiber.App, store *storage.DeviceStore) {
+func SetupRoutes(app *fiber.App, store storage.DeviceStore, reloader *storage.CSVWatcher, metrics *storage.MetricRegistry, status *storage.StatusTracker, alerts *alerting.Engine, bus *events.Bus, credentials *storage.Credentials, signatures handlers.SignatureOptions, adminToken string) {
 	// Initialize handlers
-	deviceHandler := handlers.NewDeviceHandler(store)
+	adminAuth := handlers.NewAdminAuth(adminToken)
+	deviceHandler := handlers.NewDeviceHandler(store, status, credentials, adminAuth)
+	signatureVerifier := handlers.NewSignatureVerifier(credentials, signatures, adminAuth)
+	fleetHandler := handlers.NewFleetHandler(store)
+	metricsHandler := handlers.NewMetricsHandler(store, metrics)
+	adminHandler := handlers.NewAdminHandler(store, reloader)
+	alertsHandler := handlers.NewAlertsHandler(alerts)
+	eventsHandler := handlers.NewEventsHandler(store, bus)
 
 	// API v1 group
 	api := app.Group("/api/v1")
@@ -17,12 +26,102 @@ func SetupRoutes(app *fiber.App, store *storage.DeviceStore) {
 	// Device routes
 	devices := api.Group("/devices")
 
+	// POST /api/v1/devices
+	devices.Post("/", adminAuth.Middleware(), deviceHandler.PostDevice)
+
+	// GET /api/v1/devices
+	devices.Get("/", deviceHandler.ListDevices)
+
+	// GET /api/v1/devices/{device_id}
+	devices.Get("/:device_id", deviceHandler.GetDevice)
+
+	// DELETE /api/v1/devices/{device_id}
+	devices.Delete("/:device_id", adminAuth.Middleware(), deviceHandler.DeleteDevice)
+
 	// POST /api/v1/devices/{device_id}/heartbeat
-	devices.Post("/:device_id/heartbeat", deviceHandler.PostHeartbeat)
+	devices.Post("/:device_id/heartbeat", signatureVerifier.Middleware(), deviceHandler.PostHeartbeat)
 
 	// POST /api/v1/devices/{device_id}/stats
-	devices.Post("/:device_id/stats", deviceHandler.PostStats)
+	devices.Post("/:device_id/stats", signatureVerifier.Middleware(), deviceHandler.PostStats)
 
 	// GET /api/v1/devices/{device_id}/stats
 	devices.Get("/:device_id/stats", deviceHandler.GetStats)
+
+	// GET /api/v1/devices/{device_id}/stats/series
+	devices.Get("/:device_id/stats/series", deviceHandler.GetStatsSeries)
+
+	// GET /api/v1/devices/{device_id}/ws
+	devices.Get("/:device_id/ws", signatureVerifier.Middleware(), deviceHandler.DeviceSocket)
+
+	// POST /api/v1/devices/{device_id}/keys
+	devices.Post("/:device_id/keys", signatureVerifier.KeyManagement(), deviceHandler.PostDeviceKey)
+
+	// GET /api/v1/devices/{device_id}/keys
+	devices.Get("/:device_id/keys", signatureVerifier.KeyManagement(), deviceHandler.ListDeviceKeys)
+
+	// DELETE /api/v1/devices/{device_id}/keys/{key_id}
+	devices.Delete("/:device_id/keys/:key_id", signatureVerifier.KeyManagement(), deviceHandler.DeleteDeviceKey)
+
+	// POST /api/v1/devices/{device_id}/batch
+	devices.Post("/:device_id/batch", signatureVerifier.Middleware(), deviceHandler.PostBatch)
+
+	// POST /api/v1/ingest
+	api.Post("/ingest", deviceHandler.PostIngest)
+
+	// POST /api/v1/import
+	api.Post("/import", deviceHandler.PostImport)
+
+	// GET /api/v1/devices/{device_id}/export
+	devices.Get("/:device_id/export", deviceHandler.ExportDevice)
+
+	// GET /api/v1/devices/{device_id}/events
+	devices.Get("/:device_id/events", eventsHandler.StreamDeviceEvents)
+
+	// GET /api/v1/events
+	api.Get("/events", eventsHandler.StreamEvents)
+
+	// POST /api/v1/devices/{device_id}/metrics
+	devices.Post("/:device_id/metrics", signatureVerifier.Middleware(), metricsHandler.PostMetrics)
+
+	// GET /api/v1/devices/{device_id}/metrics/{name}
+	devices.Get("/:device_id/metrics/:name", metricsHandler.GetMetric)
+
+	// Fleet routes
+	fleet := api.Group("/fleet")
+
+	// GET /api/v1/fleet/stats
+	fleet.Get("/stats", fleetHandler.GetFleetStats)
+
+	// GET /api/v1/fleet/export
+	fleet.Get("/export", fleetHandler.ExportFleet)
+
+	// Alert routes
+	alertRoutes := api.Group("/alerts")
+
+	// GET /api/v1/alerts
+	alertRoutes.Get("/", alertsHandler.ListAlerts)
+
+	// GET /api/v1/alerts/silences
+	alertRoutes.Get("/silences", alertsHandler.ListSilences)
+
+	// POST /api/v1/alerts/silences
+	alertRoutes.Post("/silences", adminAuth.Middleware(), alertsHandler.PostSilence)
+
+	// DELETE /api/v1/alerts/silences/{id}
+	alertRoutes.Delete("/silences/:id", adminAuth.Middleware(), alertsHandler.DeleteSilence)
+
+	// Admin routes
+	admin := api.Group("/admin", adminAuth.Middleware())
+
+	// POST /api/v1/admin/snapshot
+	admin.Post("/snapshot", adminHandler.PostSnapshot)
+
+	// POST /api/v1/admin/restore
+	admin.Post("/restore", adminHandler.PostRestore)
+
+	// GET /api/v1/admin/reload
+	admin.Get("/reload", adminHandler.GetReload)
+
+	// POST /api/v1/admin/reload
+	admin.Post("/reload", adminHandler.PostReload)
 }
@@ -0,0 +1,113 @@
+package storage
+
+import "time"
+
+// Aggregates are running totals over every sample a device has ever
+// reported. They are updated as samples arrive and are not affected by
+// retention pruning, so lifetime stats can be answered in O(1).
+type Aggregates struct {
+	HeartbeatCount int64
+	FirstHeartbeat time.Time // earliest heartbeat, zero when HeartbeatCount is 0
+	LastHeartbeat  time.Time // latest heartbeat
+	UploadCount    int64
+	UploadSum      int64 // nanoseconds
+}
+
+// AddHeartbeat folds a heartbeat into the aggregates. Heartbeats may
+// arrive out of order.
+func (a *Aggregates) AddHeartbeat(heartbeat time.Time) {
+	if a.HeartbeatCount == 0 || heartbeat.Before(a.FirstHeartbeat) {
+		a.FirstHeartbeat = heartbeat
+	}
+	if a.HeartbeatCount == 0 || heartbeat.After(a.LastHeartbeat) {
+		a.LastHeartbeat = heartbeat
+	}
+	a.HeartbeatCount++
+}
+
+// AddUpload folds an upload time into the aggregates
+func (a *Aggregates) AddUpload(uploadTime int64) {
+	a.UploadCount++
+	a.UploadSum += uploadTime
+}
+
+// IsEmpty reports whether no samples have been aggregated
+func (a Aggregates) IsEmpty() bool {
+	return a.HeartbeatCount == 0 && a.UploadCount == 0
+}
+
+// Uptime is the number of heartbeats divided by the minutes between the
+// first and last heartbeat, as a percentage. A single heartbeat, or
+// heartbeats spanning less than a minute, count as 100%.
+func (a Aggregates) Uptime() float64 {
+	if a.HeartbeatCount == 0 {
+		return 0.0
+	}
+
+	// Single heartbeat means 100% uptime
+	if a.HeartbeatCount == 1 {
+		return 100.0
+	}
+
+	// Calculate minutes between first and last heartbeat
+	minutes := a.LastHeartbeat.Sub(a.FirstHeartbeat).Minutes()
+
+	// If duration is less than a minute, consider it 100% uptime
+	if minutes < 1.0 {
+		return 100.0
+	}
+
+	return (float64(a.HeartbeatCount) / minutes) * 100.0
+}
+
+// AvgUploadTime is the mean upload time, or 0 without uploads
+func (a Aggregates) AvgUploadTime() time.Duration {
+	if a.UploadCount == 0 {
+		return 0
+	}
+	return time.Duration(a.UploadSum / a.UploadCount)
+}
+
+// TimeWindow limits samples to those sent in [From, To). A zero bound
+// leaves that side of the window open.
+type TimeWindow struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains reports whether t falls inside the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	if !w.From.IsZero() && t.Before(w.From) {
+		return false
+	}
+	if !w.To.IsZero() && !t.Before(w.To) {
+		return false
+	}
+	return true
+}
+
+// WindowAggregates aggregates the samples of a device sent inside a
+// window. With both sides of the window open, the running lifetime
+// aggregates are returned.
+func WindowAggregates(store DeviceStore, deviceID string, window TimeWindow) (Aggregates, error) {
+	if window.From.IsZero() && window.To.IsZero() {
+		return store.GetAggregates(deviceID)
+	}
+
+	var aggregates Aggregates
+	data, err := store.GetDeviceData(deviceID)
+	if err != nil {
+		return aggregates, err
+	}
+	for _, heartbeat := range data.Heartbeats {
+		if window.Contains(heartbeat) {
+			aggregates.AddHeartbeat(heartbeat)
+		}
+	}
+	for _, upload := range data.Uploads {
+		if window.Contains(upload.SentAt) {
+			aggregates.AddUpload(upload.UploadTime)
+		}
+	}
+	return aggregates, nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CountingStore wraps a DeviceStore and counts the heartbeats and upload
+// times it accepts, for monitoring. Data replayed on startup is not counted.
+type CountingStore struct {
+	DeviceStore
+	heartbeats atomic.Int64
+	uploads    atomic.Int64
+}
+
+// NewCountingStore wraps store
+func NewCountingStore(store DeviceStore) *CountingStore {
+	return &CountingStore{DeviceStore: store}
+}
+
+// AddHeartbeat adds a heartbeat and counts it if it was accepted
+func (s *CountingStore) AddHeartbeat(deviceID string, timestamp time.Time) error {
+	err := s.DeviceStore.AddHeartbeat(deviceID, timestamp)
+	if err == nil {
+		s.heartbeats.Add(1)
+	}
+	return err
+}
+
+// AddUploadTime adds an upload time and counts it if it was accepted
+func (s *CountingStore) AddUploadTime(deviceID string, sentAt time.Time, uploadTime int64) error {
+	err := s.DeviceStore.AddUploadTime(deviceID, sentAt, uploadTime)
+	if err == nil {
+		s.uploads.Add(1)
+	}
+	return err
+}
+
+// AddBatch adds heartbeats and upload times and counts them if they were
+// accepted
+func (s *CountingStore) AddBatch(deviceID string, heartbeats []time.Time, uploads []UploadSample) error {
+	err := s.DeviceStore.AddBatch(deviceID, heartbeats, uploads)
+	if err == nil {
+		s.heartbeats.Add(int64(len(heartbeats)))
+		s.uploads.Add(int64(len(uploads)))
+	}
+	return err
+}
+
+// Counts returns how many heartbeats and upload times have been accepted
+func (s *CountingStore) Counts() (heartbeats, uploads int64) {
+	return s.heartbeats.Load(), s.uploads.Load()
+}
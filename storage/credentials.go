@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned when a device has no key with the given ID
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrSignatureRequired is returned when an unsigned report is sent for a
+// device that must sign its reports
+var ErrSignatureRequired = errors.New("device must sign its reports")
+
+// DeviceKey is a shared secret a device signs its requests with
+type DeviceKey struct {
+	ID        string    `json:"id"`
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // zero if the key does not expire
+}
+
+// Active reports whether the key can be used at now
+func (k DeviceKey) Active(now time.Time) bool {
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
+
+// Credentials holds the keys of devices. Keys can be provisioned in a
+// credentials file, which every change is written back to. A device that
+// was given keys stays provisioned once they expire or are revoked, so it
+// still has to sign its reports.
+type Credentials struct {
+	path     string // empty to keep keys in memory only
+	now      func() time.Time
+	required bool // whether devices without keys must sign too
+
+	mu   sync.RWMutex
+	keys map[string][]DeviceKey // by provisioned device ID, oldest first
+}
+
+// credentialsFile is the layout of a credentials file
+type credentialsFile struct {
+	Devices map[string][]DeviceKey `json:"devices"`
+}
+
+// NewCredentials creates credentials kept in memory only
+func NewCredentials() *Credentials {
+	return &Credentials{
+		now:  time.Now,
+		keys: make(map[string][]DeviceKey),
+	}
+}
+
+// LoadCredentials reads the credentials file at path. A missing file is
+// created on the first change.
+func LoadCredentials(path string) (*Credentials, error) {
+	c := NewCredentials()
+	c.path = path
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var file credentialsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	for deviceID, keys := range file.Devices {
+		for _, key := range keys {
+			if key.ID == "" || key.Secret == "" {
+				return nil, fmt.Errorf("invalid key of device %s: id and secret are required", deviceID)
+			}
+		}
+		c.keys[deviceID] = keys
+	}
+	return c, nil
+}
+
+// RequireSignatures makes devices without keys sign their reports too,
+// which they cannot, so their reports are rejected. Call it before the
+// credentials are used.
+func (c *Credentials) RequireSignatures(required bool) {
+	c.required = required
+}
+
+// SignatureRequired reports whether the reports of a device must be signed:
+// it was provisioned, even if none of its keys is active anymore, or
+// signatures are required of every device
+func (c *Credentials) SignatureRequired(deviceID string) bool {
+	if c.required {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, provisioned := c.keys[deviceID]
+	return provisioned
+}
+
+// Keys returns the active keys of a device, oldest first
+func (c *Credentials) Keys(deviceID string) []DeviceKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.now()
+	var active []DeviceKey
+	for _, key := range c.keys[deviceID] {
+		if key.Active(now) {
+			active = append(active, key)
+		}
+	}
+	return active
+}
+
+// Key returns an active key of a device
+func (c *Credentials) Key(deviceID, keyID string) (DeviceKey, error) {
+	for _, key := range c.Keys(deviceID) {
+		if key.ID == keyID {
+			return key, nil
+		}
+	}
+	return DeviceKey{}, ErrKeyNotFound
+}
+
+// Rotate creates a new key for a device. The device's other keys stay
+// valid for overlap, or their remaining lifetime if shorter, so devices
+// can switch over to the new key.
+func (c *Credentials) Rotate(deviceID string, overlap time.Duration) (DeviceKey, error) {
+	key, err := newDeviceKey()
+	if err != nil {
+		return DeviceKey{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	key.CreatedAt = now.UTC()
+	keys := make([]DeviceKey, 0, len(c.keys[deviceID])+1)
+	for _, old := range c.keys[deviceID] {
+		if !old.Active(now) {
+			continue
+		}
+		if expiresAt := now.Add(overlap).UTC(); old.ExpiresAt.IsZero() || expiresAt.Before(old.ExpiresAt) {
+			old.ExpiresAt = expiresAt
+		}
+		if old.Active(now) {
+			keys = append(keys, old)
+		}
+	}
+	keys = append(keys, key)
+
+	previous := c.keys[deviceID]
+	c.keys[deviceID] = keys
+	if err := c.save(); err != nil {
+		c.keys[deviceID] = previous
+		return DeviceKey{}, err
+	}
+	return key, nil
+}
+
+// Revoke removes a key of a device
+func (c *Credentials) Revoke(deviceID, keyID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.keys[deviceID]
+	i := slices.IndexFunc(previous, func(key DeviceKey) bool {
+		return key.ID == keyID && key.Active(c.now())
+	})
+	if i < 0 {
+		return ErrKeyNotFound
+	}
+	c.keys[deviceID] = slices.Delete(slices.Clone(previous), i, i+1)
+	if err := c.save(); err != nil {
+		c.keys[deviceID] = previous
+		return err
+	}
+	return nil
+}
+
+// RemoveDevice removes every key of a device, which is no longer
+// provisioned
+func (c *Credentials) RemoveDevice(deviceID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, exists := c.keys[deviceID]
+	if !exists {
+		return nil
+	}
+	delete(c.keys, deviceID)
+	if err := c.save(); err != nil {
+		c.keys[deviceID] = previous
+		return err
+	}
+	return nil
+}
+
+// save atomically writes the keys that are still active to the
+// credentials file, readable by the owner only. Devices without active
+// keys are written with none to keep them provisioned.
+func (c *Credentials) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	now := c.now()
+	file := credentialsFile{Devices: make(map[string][]DeviceKey, len(c.keys))}
+	deviceIDs := make([]string, 0, len(c.keys))
+	for deviceID := range c.keys {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+	for _, deviceID := range deviceIDs {
+		file.Devices[deviceID] = []DeviceKey{}
+		for _, key := range c.keys[deviceID] {
+			if key.Active(now) {
+				file.Devices[deviceID] = append(file.Devices[deviceID], key)
+			}
+		}
+	}
+	content, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(content, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to install credentials: %w", err)
+	}
+	return nil
+}
+
+// newDeviceKey generates a key with a random ID and secret
+func newDeviceKey() (DeviceKey, error) {
+	random := make([]byte, 8+32)
+	if _, err := rand.Read(random); err != nil {
+		return DeviceKey{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return DeviceKey{
+		ID:     hex.EncodeToString(random[:8]),
+		Secret: hex.EncodeToString(random[8:]),
+	}, nil
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCredentialsRotation(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	credentials, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadCredentials failed: %v", err)
+	}
+	credentials.now = func() time.Time { return now }
+
+	first, err := credentials.Rotate("device-1", 0)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	second, err := credentials.Rotate("device-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if first.ID == second.ID || first.Secret == second.Secret || len(second.Secret) != 64 {
+		t.Errorf("Expected distinct random keys, got %+v and %+v", first, second)
+	}
+
+	// A shorter overlap cuts the remaining lifetime of older keys, a longer one does not extend it
+	now = now.Add(10 * time.Minute)
+	third, err := credentials.Rotate("device-1", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if _, err := credentials.Rotate("device-1", 2*time.Hour); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		at       time.Duration // after the first rotation
+		key      DeviceKey
+		expected bool
+	}{
+		{name: "First key during the shortened overlap", at: 19 * time.Minute, key: first, expected: true},
+		{name: "First key after the shortened overlap", at: 30 * time.Minute, key: first, expected: false},
+		{name: "Second key during the overlap", at: 19 * time.Minute, key: second, expected: true},
+		{name: "Second key after the overlap", at: 20 * time.Minute, key: second, expected: false},
+		{name: "Third key during the overlap", at: 2 * time.Hour, key: third, expected: true},
+		{name: "Third key after the overlap", at: 2*time.Hour + 10*time.Minute, key: third, expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(tc.at)
+			_, err := credentials.Key("device-1", tc.key.ID)
+			if active := err == nil; active != tc.expected {
+				t.Errorf("Expected active %v, got %v", tc.expected, active)
+			}
+		})
+	}
+	now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(10 * time.Minute)
+
+	// Changes are written back to the file, readable by the owner only
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("Expected mode 0600, got %o", mode)
+	}
+	reloaded, err := LoadCredentials(path)
+	if err != nil {
+		t.Fatalf("LoadCredentials failed: %v", err)
+	}
+	reloaded.now = credentials.now
+	if keys := reloaded.Keys("device-1"); len(keys) != 4 {
+		t.Errorf("Expected 4 active keys after reloading, got %+v", keys)
+	}
+
+	if err := reloaded.Revoke("device-1", third.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := reloaded.Revoke("device-1", third.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if err := reloaded.RemoveDevice("device-1"); err != nil {
+		t.Fatalf("RemoveDevice failed: %v", err)
+	}
+	if keys := reloaded.Keys("device-1"); len(keys) != 0 {
+		t.Errorf("Expected no keys after removing the device, got %+v", keys)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	testCases := []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{
+			name:    "Provisioned keys",
+			content: `{"devices":{"device-1":[{"id":"k1","secret":"s1","created_at":"2025-01-01T00:00:00Z"}]}}`,
+		},
+		{name: "Missing secret", content: `{"devices":{"device-1":[{"id":"k1"}]}}`, expectErr: true},
+		{name: "Invalid JSON", content: `{"devices":`, expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+			credentials, err := LoadCredentials(path)
+			if tc.expectErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadCredentials failed: %v", err)
+			}
+			if key, err := credentials.Key("device-1", "k1"); err != nil || key.Secret != "s1" {
+				t.Errorf("Expected key k1, got %+v (%v)", key, err)
+			}
+		})
+	}
+}
+
+func TestCredentialsWithoutActiveKeys(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name   string
+		retire func(t *testing.T, credentials *Credentials, key DeviceKey)
+	}{
+		{
+			name: "Last key expired",
+			retire: func(t *testing.T, credentials *Credentials, key DeviceKey) {
+				// Rotating gives the key a lifetime, the new key is revoked at once
+				if _, err := credentials.Rotate("device-1", time.Minute); err != nil {
+					t.Fatalf("Rotate failed: %v", err)
+				}
+				if err := credentials.Revoke("device-1", credentials.Keys("device-1")[1].ID); err != nil {
+					t.Fatalf("Revoke failed: %v", err)
+				}
+				now = now.Add(2 * time.Minute)
+			},
+		},
+		{
+			name: "Last key revoked",
+			retire: func(t *testing.T, credentials *Credentials, key DeviceKey) {
+				if err := credentials.Revoke("device-1", key.ID); err != nil {
+					t.Fatalf("Revoke failed: %v", err)
+				}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			credentials, err := LoadCredentials(path)
+			if err != nil {
+				t.Fatalf("LoadCredentials failed: %v", err)
+			}
+			credentials.now = func() time.Time { return now }
+			key, err := credentials.Rotate("device-1", 0)
+			if err != nil {
+				t.Fatalf("Rotate failed: %v", err)
+			}
+
+			tc.retire(t, credentials, key)
+			if keys := credentials.Keys("device-1"); len(keys) != 0 {
+				t.Fatalf("Expected no active keys, got %+v", keys)
+			}
+			if !credentials.SignatureRequired("device-1") {
+				t.Error("Expected a device without active keys to still have to sign")
+			}
+
+			// The device stays provisioned across restarts, once another change
+			// writes the file without its inactive keys
+			if _, err := credentials.Rotate("device-2", 0); err != nil {
+				t.Fatalf("Rotate failed: %v", err)
+			}
+			reloaded, err := LoadCredentials(path)
+			if err != nil {
+				t.Fatalf("LoadCredentials failed: %v", err)
+			}
+			reloaded.now = credentials.now
+			if !reloaded.SignatureRequired("device-1") {
+				t.Error("Expected the device to still have to sign after reloading")
+			}
+
+			if err := reloaded.RemoveDevice("device-1"); err != nil {
+				t.Fatalf("RemoveDevice failed: %v", err)
+			}
+			if reloaded.SignatureRequired("device-1") {
+				t.Error("Expected a removed device to no longer have to sign")
+			}
+		})
+	}
+}
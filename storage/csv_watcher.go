@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// csvReloadDelay lets a burst of file events settle before reloading, since
+// editors often write a file in several steps
+const csvReloadDelay = 250 * time.Millisecond
+
+// Reload triggers
+const (
+	TriggerFileChange = "file_change"
+	TriggerSignal     = "signal"
+	TriggerAPI        = "api"
+)
+
+// ReloadStatus describes the outcome of a devices CSV reload
+type ReloadStatus struct {
+	Trigger string
+	Time    time.Time
+	Result  ReloadResult
+	Err     error
+}
+
+// CSVWatcher reloads the devices CSV when it changes. It watches the file
+// with inotify (or the platform equivalent) and falls back to polling its
+// modification time and size when that is unavailable.
+type CSVWatcher struct {
+	store        DeviceStore
+	path         string
+	pollInterval time.Duration
+
+	mu      sync.Mutex // serializes reloads and guards last
+	last    ReloadStatus
+	hasLast bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewCSVWatcher creates a watcher for the CSV at path, polling every
+// pollInterval if the file cannot be watched
+func NewCSVWatcher(store DeviceStore, path string, pollInterval time.Duration) *CSVWatcher {
+	return &CSVWatcher{
+		store:        store,
+		path:         path,
+		pollInterval: pollInterval,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Start watches the file in a background goroutine. Changes made after
+// Start returns are picked up.
+func (w *CSVWatcher) Start() {
+	// Watch the directory rather than the file so that editors replacing
+	// the file through a rename are still noticed
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(w.path)); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Cannot watch %s, polling every %s instead: %v", w.path, w.pollInterval, err)
+		watcher = nil
+	}
+	go w.run(watcher, csvFingerprint(w.path))
+}
+
+// Stop stops the watcher and waits for it to exit
+func (w *CSVWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
+
+// Reload reloads the CSV now and logs a summary of the changes
+func (w *CSVWatcher) Reload(trigger string) ReloadStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result, err := w.store.ReloadDevicesFromCSV(w.path)
+	status := ReloadStatus{Trigger: trigger, Time: time.Now(), Result: result, Err: err}
+	if err != nil {
+		log.Printf("Failed to reload devices from %s (%s): %v", w.path, trigger, err)
+	} else {
+		log.Printf("Reloaded devices from %s (%s): %d added, %d retired, %d updated, %d unchanged",
+			w.path, trigger, len(result.Added), len(result.Retired), len(result.Updated), result.Unchanged)
+	}
+
+	w.last = status
+	w.hasLast = true
+	return status
+}
+
+// LastReload returns the outcome of the most recent reload, if any
+func (w *CSVWatcher) LastReload() (ReloadStatus, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.last, w.hasLast
+}
+
+// run reloads on file events from watcher, or polls the fingerprint of the
+// file if watcher is nil
+func (w *CSVWatcher) run(watcher *fsnotify.Watcher, fingerprint fileFingerprint) {
+	defer close(w.done)
+
+	var events chan fsnotify.Event
+	var errs chan error
+	var poll <-chan time.Time
+	if watcher != nil {
+		defer watcher.Close()
+		events = watcher.Events
+		errs = watcher.Errors
+	} else {
+		ticker := time.NewTicker(w.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	target := filepath.Clean(w.path)
+	var pending <-chan time.Time
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if filepath.Clean(event.Name) == target && !event.Has(fsnotify.Chmod) {
+				pending = time.After(csvReloadDelay)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Printf("Error watching %s: %v", w.path, err)
+		case <-pending:
+			pending = nil
+			w.Reload(TriggerFileChange)
+		case <-poll:
+			if current := csvFingerprint(w.path); current != fingerprint {
+				fingerprint = current
+				w.Reload(TriggerFileChange)
+			}
+		}
+	}
+}
+
+// fileFingerprint identifies a version of a file for change polling
+type fileFingerprint struct {
+	modTime time.Time
+	size    int64
+}
+
+// csvFingerprint returns the fingerprint of the file at path, or the zero
+// fingerprint if it cannot be read
+func csvFingerprint(path string) fileFingerprint {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileFingerprint{}
+	}
+	return fileFingerprint{modTime: info.ModTime(), size: info.Size()}
+}
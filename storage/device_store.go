@@ -3,136 +3,185 @@ package storage
 import (
 	"encoding/csv"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
+// DeviceStore is the persistence backend used by the handlers
+type DeviceStore interface {
+	// LoadDevicesFromCSV registers the device IDs listed in a CSV file
+	LoadDevicesFromCSV(filepath string) error
+
+	// ReloadDevicesFromCSV adds devices newly listed in a CSV file and
+	// retires CSV devices that are no longer listed, keeping their data
+	ReloadDevicesFromCSV(filepath string) (ReloadResult, error)
+
+	// RegisterDevice registers a device at runtime
+	RegisterDevice(info DeviceInfo) error
+
+	// DeregisterDevice removes a device, or retires it if retainData is set
+	DeregisterDevice(deviceID string, retainData bool) error
+
+	// GetDeviceInfo retrieves the registration details of a device
+	GetDeviceInfo(deviceID string) (DeviceInfo, error)
+
+	// ListDevices returns the registration details of every device
+	ListDevices() []DeviceInfo
+
+	// DeviceExists checks if a device ID exists and is not retired
+	DeviceExists(deviceID string) bool
+
+	// AddHeartbeat adds a heartbeat timestamp for a device
+	AddHeartbeat(deviceID string, timestamp time.Time) error
+
+	// AddUploadTime adds an upload time, reported at sentAt, for a device
+	AddUploadTime(deviceID string, sentAt time.Time, uploadTime int64) error
+
+	// GetDeviceData retrieves a copy of device data
+	GetDeviceData(deviceID string) (*DeviceData, error)
+
+	// AddBatch adds heartbeats and upload times for a device under a single
+	// lock acquisition
+	AddBatch(deviceID string, heartbeats []time.Time, uploads []UploadSample) error
+
+	// AddMetrics adds a batch of metric samples for a device, all or nothing
+	AddMetrics(deviceID string, samples []MetricSample) error
+
+	// GetMetric retrieves a copy of one metric series of a device
+	GetMetric(deviceID, name string) (MetricSeries, error)
+
+	// GetAggregates retrieves the lifetime aggregates of a device without
+	// copying its samples
+	GetAggregates(deviceID string) (Aggregates, error)
+
+	// Snapshot writes a consistent point-in-time copy of all device data
+	Snapshot(w io.Writer) error
+
+	// Restore replaces all device data with the contents of a snapshot
+	Restore(r io.Reader) error
+
+	// Prune drops raw samples older than cutoff, keeping lifetime
+	// aggregates, and returns how many samples were removed. Metrics named
+	// in keepMetrics have their own retention and are left alone.
+	Prune(cutoff time.Time, keepMetrics []string) (int, error)
+
+	// PruneMetric drops samples of one metric older than cutoff and returns
+	// how many were removed
+	PruneMetric(name string, cutoff time.Time) (int, error)
+
+	// Close releases any resources held by the backend
+	Close() error
+}
+
 // DeviceData holds the tracking data for a single device
 type DeviceData struct {
-	Heartbeats  []time.Time // timestamps of heartbeats
-	UploadTimes []int64     // upload times in nanoseconds
-	mu          sync.RWMutex
+	Heartbeats []time.Time    // timestamps of heartbeats
+	Uploads    []UploadSample // upload time samples
+	Lifetime   Aggregates     // running totals, including pruned samples
+	Metrics    map[string]*MetricSeries
+	Info       DeviceInfo // registration details
+	mu         sync.RWMutex
 }
 
-// DeviceStore manages all device data
-type DeviceStore struct {
-	devices map[string]*DeviceData
-	mu      sync.RWMutex
+// UploadSample is a single upload time report
+type UploadSample struct {
+	SentAt     time.Time // when the device sent the report
+	UploadTime int64     // upload time in nanoseconds
 }
 
-// NewDeviceStore creates a new device store
-func NewDeviceStore() *DeviceStore {
-	return &DeviceStore{
-		devices: make(map[string]*DeviceData),
-	}
-}
+// Tags in the CSV tags column are separated by this character
+const csvTagSeparator = ";"
 
-// LoadDevicesFromCSV loads device IDs from a CSV file
-func (s *DeviceStore) LoadDevicesFromCSV(filepath string) error {
+// readDevicesCSV reads the devices listed in a CSV file. The header names
+// the columns: device_id (or else the first column), the metadata columns
+// model, firmware, site, region, owner and tags, and any other columns,
+// which are kept as attributes. Rows without a device ID are skipped; a row
+// that could not be registered through the API fails the whole file.
+func readDevicesCSV(filepath string) ([]DeviceInfo, error) {
 	file, err := os.Open(filepath)
 	if err != nil {
-		return fmt.Errorf("failed to open CSV file: %w", err)
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
 	}
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
 	records, err := reader.ReadAll()
 	if err != nil {
-		return fmt.Errorf("failed to read CSV file: %w", err)
+		return nil, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	if len(records) == 0 {
+		return []DeviceInfo{}, nil
 	}
 
-	// Skip header row and load device IDs
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	header := make([]string, len(records[0]))
+	idColumn := 0
+	for i, name := range records[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if header[i] == "device_id" {
+			idColumn = i
+		}
+	}
 
-	for i, record := range records {
-		if i == 0 {
-			// Skip header
+	devices := make([]DeviceInfo, 0, len(records)-1)
+	seen := make(map[string]bool, len(records)-1)
+	for row, record := range records[1:] {
+		if idColumn >= len(record) {
 			continue
 		}
-		if len(record) > 0 && record[0] != "" {
-			deviceID := record[0]
-			s.devices[deviceID] = &DeviceData{
-				Heartbeats:  make([]time.Time, 0),
-				UploadTimes: make([]int64, 0),
-			}
+		deviceID := strings.TrimSpace(record[idColumn])
+		if deviceID == "" || seen[deviceID] {
+			continue
 		}
-	}
-
-	return nil
-}
-
-// DeviceExists checks if a device ID exists
-func (s *DeviceStore) DeviceExists(deviceID string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	_, exists := s.devices[deviceID]
-	return exists
-}
-
-// AddHeartbeat adds a heartbeat timestamp for a device
-func (s *DeviceStore) AddHeartbeat(deviceID string, timestamp time.Time) error {
-	s.mu.RLock()
-	device, exists := s.devices[deviceID]
-	s.mu.RUnlock()
-
-	if !exists {
-		return fmt.Errorf("device not found")
-	}
-
-	device.mu.Lock()
-	defer device.mu.Unlock()
-	device.Heartbeats = append(device.Heartbeats, timestamp)
-	return nil
-}
-
-// AddUploadTime adds an upload time for a device
-func (s *DeviceStore) AddUploadTime(deviceID string, uploadTime int64) error {
-	s.mu.RLock()
-	device, exists := s.devices[deviceID]
-	s.mu.RUnlock()
+		seen[deviceID] = true
 
-	if !exists {
-		return fmt.Errorf("device not found")
+		info := DeviceInfo{DeviceID: deviceID, Source: SourceCSV}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if i == idColumn || i >= len(header) || value == "" {
+				continue
+			}
+			info.Metadata.set(header[i], value)
+		}
+		// Rows are numbered from the header, like the lines of the file
+		if err := info.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid CSV row %d: %w", row+2, err)
+		}
+		devices = append(devices, info)
 	}
 
-	device.mu.Lock()
-	defer device.mu.Unlock()
-	device.UploadTimes = append(device.UploadTimes, uploadTime)
-	return nil
+	return devices, nil
 }
 
-// GetDeviceData retrieves a copy of device data
-func (s *DeviceStore) GetDeviceData(deviceID string) (*DeviceData, error) {
-	s.mu.RLock()
-	device, exists := s.devices[deviceID]
-	s.mu.RUnlock()
-
-	if !exists {
-		return nil, fmt.Errorf("device not found")
-	}
-
-	device.mu.RLock()
-	defer device.mu.RUnlock()
-
-	// Return a copy to avoid race conditions
-	copy := &DeviceData{
-		Heartbeats:  make([]time.Time, len(device.Heartbeats)),
-		UploadTimes: make([]int64, len(device.UploadTimes)),
+// set assigns a CSV column to the matching metadata field
+func (m *DeviceMetadata) set(column, value string) {
+	switch column {
+	case "model":
+		m.Model = value
+	case "firmware":
+		m.Firmware = value
+	case "site":
+		m.Site = value
+	case "region":
+		m.Region = value
+	case "owner":
+		m.Owner = value
+	case "tags":
+		for _, tag := range strings.Split(value, csvTagSeparator) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				m.Tags = append(m.Tags, tag)
+			}
+		}
+	default:
+		if column == "" {
+			return
+		}
+		if m.Attributes == nil {
+			m.Attributes = make(map[string]string)
+		}
+		m.Attributes[column] = value
 	}
-	copySlice(copy.Heartbeats, device.Heartbeats)
-	copyInt64Slice(copy.UploadTimes, device.UploadTimes)
-
-	return copy, nil
-}
-
-// Helper function to copy time slices
-func copySlice(dst, src []time.Time) {
-	copy(dst, src)
-}
-
-// Helper function to copy int64 slices
-func copyInt64Slice(dst, src []int64) {
-	copy(dst, src)
 }
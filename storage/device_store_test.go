@@ -1,8 +1,16 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestLoadDevicesFromCSV(t *testing.T) {
@@ -85,7 +93,7 @@ device-2
 			}
 
 			// Execute
-			store := NewDeviceStore()
+			store := NewMemoryStore()
 			err := store.LoadDevicesFromCSV(filepath)
 
 			// Validate error expectation
@@ -116,3 +124,202 @@ device-2
 		})
 	}
 }
+
+func TestLoadDevicesFromCSVKeepsData(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMemoryStore()
+
+	if err := store.LoadDevicesFromCSV(writeDevicesCSV(t, dir, "device_id\ndevice-1\n")); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+	if err := store.AddHeartbeat("device-1", time.Now()); err != nil {
+		t.Fatalf("AddHeartbeat failed: %v", err)
+	}
+
+	// Loading again adds new devices without resetting existing ones
+	if err := store.LoadDevicesFromCSV(writeDevicesCSV(t, dir, "device_id\ndevice-1\ndevice-2\n")); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+	data, err := store.GetDeviceData("device-1")
+	if err != nil || len(data.Heartbeats) != 1 {
+		t.Errorf("Expected device-1 to keep its heartbeat, got %v (err %v)", data, err)
+	}
+	if !store.DeviceExists("device-2") {
+		t.Error("Expected device-2 to exist")
+	}
+}
+
+func TestReadDevicesCSVMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := writeDevicesCSV(t, dir, `Site,device_id,model,firmware,region,owner,tags,rack
+lab,device-1,x1,1.2.3,eu-west,ops,beta; edge ;,r12
+,device-2
+field,device-1,x2
+`)
+
+	devices, err := readDevicesCSV(path)
+	if err != nil {
+		t.Fatalf("readDevicesCSV failed: %v", err)
+	}
+
+	expected := []DeviceInfo{
+		{
+			DeviceID: "device-1",
+			Source:   SourceCSV,
+			Metadata: DeviceMetadata{
+				Model:      "x1",
+				Firmware:   "1.2.3",
+				Site:       "lab",
+				Region:     "eu-west",
+				Owner:      "ops",
+				Tags:       []string{"beta", "edge"},
+				Attributes: map[string]string{"rack": "r12"},
+			},
+		},
+		{DeviceID: "device-2", Source: SourceCSV},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, devices)
+	}
+}
+
+func TestReadDevicesCSVInvalidRows(t *testing.T) {
+	long := strings.Repeat("x", maxSnapshotStringLength+1)
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{name: "Long model", content: "device_id,model\ndevice-1,x1\ndevice-2," + long + "\n"},
+		{name: "Long attribute", content: "device_id,rack\ndevice-1," + long + "\n"},
+		{name: "Long tag", content: "device_id,tags\ndevice-1,beta;" + long + "\n"},
+		{name: "Long device ID", content: "device_id\n" + long + "\n"},
+		{name: "Device ID with a slash", content: "device_id\ndevice/1\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeDevicesCSV(t, t.TempDir(), tc.content)
+			if _, err := readDevicesCSV(path); !errors.Is(err, ErrInvalidDevice) {
+				t.Errorf("Expected ErrInvalidDevice, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAddBatch(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	heartbeats := []time.Time{baseTime, baseTime.Add(time.Minute)}
+	uploads := []UploadSample{{SentAt: baseTime, UploadTime: int64(2 * time.Second)}}
+
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+	dataDir := filepath.Join(dir, "data")
+
+	memory := NewMemoryStore()
+	if err := memory.LoadDevicesFromCSV(csvPath); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+	file := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+
+	for name, store := range map[string]DeviceStore{"memory": memory, "file": file} {
+		t.Run(name, func(t *testing.T) {
+			if err := store.AddBatch("device-1", heartbeats, uploads); err != nil {
+				t.Fatalf("AddBatch failed: %v", err)
+			}
+			if err := store.AddBatch("unknown", heartbeats, nil); !errors.Is(err, ErrDeviceNotFound) {
+				t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+			}
+
+			data, err := store.GetDeviceData("device-1")
+			if err != nil {
+				t.Fatalf("GetDeviceData failed: %v", err)
+			}
+			if !reflect.DeepEqual(data.Heartbeats, heartbeats) || !reflect.DeepEqual(data.Uploads, uploads) {
+				t.Errorf("Unexpected data: heartbeats %v, uploads %v", data.Heartbeats, data.Uploads)
+			}
+			if data.Lifetime.HeartbeatCount != 2 || data.Lifetime.UploadCount != 1 {
+				t.Errorf("Unexpected lifetime aggregates %+v", data.Lifetime)
+			}
+		})
+	}
+
+	// The batch is replayed from the WAL
+	file.Close()
+	file = openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	defer file.Close()
+
+	data, err := file.GetDeviceData("device-1")
+	if err != nil || len(data.Heartbeats) != 2 || len(data.Uploads) != 1 {
+		t.Errorf("Expected the batch to be recovered, got %+v (err %v)", data, err)
+	}
+}
+
+// ---------------------------------------
+// Benchmarks
+// ---------------------------------------
+
+const benchmarkDeviceCount = 100_000
+
+// newBenchmarkStore creates a store with benchmarkDeviceCount devices
+func newBenchmarkStore(shards int) (*MemoryStore, []string) {
+	store := newMemoryStoreWithShards(shards)
+	deviceIDs := make([]string, benchmarkDeviceCount)
+	for i := range deviceIDs {
+		deviceIDs[i] = fmt.Sprintf("%02x-%02x-%02x-%02x-%02x-%02x",
+			byte(i>>40), byte(i>>32), byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+		store.addDevice(DeviceInfo{DeviceID: deviceIDs[i], Source: SourceCSV})
+	}
+	return store, deviceIDs
+}
+
+// BenchmarkAddHeartbeatParallel measures heartbeat throughput with many
+// goroutines writing to random devices. The single-shard case behaves like
+// one global lock and serves as the baseline.
+func BenchmarkAddHeartbeatParallel(b *testing.B) {
+	for _, shards := range []int{1, 16, defaultShardCount, 256} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			store, deviceIDs := newBenchmarkStore(shards)
+			now := time.Now()
+			var seed atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(seed.Add(1)))
+				for pb.Next() {
+					if err := store.AddHeartbeat(deviceIDs[rng.Intn(len(deviceIDs))], now); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMixedParallel measures a realistic mix of heartbeats, stats
+// uploads and stats reads across the fleet
+func BenchmarkMixedParallel(b *testing.B) {
+	for _, shards := range []int{1, defaultShardCount} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			store, deviceIDs := newBenchmarkStore(shards)
+			now := time.Now()
+			var seed atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(seed.Add(1)))
+				for pb.Next() {
+					deviceID := deviceIDs[rng.Intn(len(deviceIDs))]
+					switch op := rng.Intn(10); {
+					case op < 7:
+						_ = store.AddHeartbeat(deviceID, now)
+					case op < 9:
+						_ = store.AddUploadTime(deviceID, now, int64(time.Second))
+					default:
+						_, _ = store.GetAggregates(deviceID)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeDevicesCSV writes a devices CSV into dir and returns its path
+func writeDevicesCSV(t *testing.T, dir string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "devices.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write CSV file: %v", err)
+	}
+	return path
+}
+
+// openFileStore opens a file store and loads the devices CSV into it
+func openFileStore(t *testing.T, dataDir, csvPath string, opts FileStoreOptions) *FileStore {
+	t.Helper()
+	store, err := NewFileStore(dataDir, opts)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	if err := store.LoadDevicesFromCSV(csvPath); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+	return store
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name    string
+		opts    FileStoreOptions
+		compact bool
+	}{
+		{
+			name: "Replay WAL with fsync on every write",
+			opts: FileStoreOptions{WAL: WALOptions{SyncPolicy: SyncAlways}},
+		},
+		{
+			name: "Replay WAL with batched fsync",
+			opts: FileStoreOptions{WAL: WALOptions{SyncPolicy: SyncBatch}},
+		},
+		{
+			name: "Replay WAL with interval fsync",
+			opts: FileStoreOptions{WAL: WALOptions{SyncPolicy: SyncInterval, SyncInterval: time.Millisecond}},
+		},
+		{
+			name:    "Replay snapshot after compaction",
+			opts:    FileStoreOptions{WAL: WALOptions{SyncPolicy: SyncAlways}},
+			compact: true,
+		},
+		{
+			name: "Replay across rotated segments",
+			opts: FileStoreOptions{WAL: WALOptions{SyncPolicy: SyncAlways, SegmentSize: 64}, CompactSegments: 1000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\ndevice-2\n")
+			dataDir := filepath.Join(dir, "data")
+
+			// First run: record some data
+			store := openFileStore(t, dataDir, csvPath, tc.opts)
+			for i := 0; i < 3; i++ {
+				if err := store.AddHeartbeat("device-1", baseTime.Add(time.Duration(i)*time.Minute)); err != nil {
+					t.Fatalf("AddHeartbeat failed: %v", err)
+				}
+			}
+			if tc.compact {
+				if err := store.Compact(); err != nil {
+					t.Fatalf("Compact failed: %v", err)
+				}
+			}
+			if err := store.AddUploadTime("device-2", baseTime, 5*time.Second.Nanoseconds()); err != nil {
+				t.Fatalf("AddUploadTime failed: %v", err)
+			}
+			if err := store.AddHeartbeat("unknown", baseTime); err == nil {
+				t.Error("Expected error for unknown device")
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			if tc.compact {
+				segments, err := listSegments(dataDir)
+				if err != nil {
+					t.Fatalf("listSegments failed: %v", err)
+				}
+				if len(segments) != 1 {
+					t.Errorf("Expected compaction to leave 1 segment, got %d", len(segments))
+				}
+			}
+
+			// Second run: data is restored
+			store = openFileStore(t, dataDir, csvPath, tc.opts)
+			defer store.Close()
+
+			data, err := store.GetDeviceData("device-1")
+			if err != nil {
+				t.Fatalf("GetDeviceData failed: %v", err)
+			}
+			if len(data.Heartbeats) != 3 {
+				t.Fatalf("Expected 3 heartbeats, got %d", len(data.Heartbeats))
+			}
+			if !data.Heartbeats[2].Equal(baseTime.Add(2 * time.Minute)) {
+				t.Errorf("Unexpected heartbeat timestamp %v", data.Heartbeats[2])
+			}
+
+			data, err = store.GetDeviceData("device-2")
+			if err != nil {
+				t.Fatalf("GetDeviceData failed: %v", err)
+			}
+			if len(data.Uploads) != 1 || data.Uploads[0] != (UploadSample{SentAt: baseTime, UploadTime: 5 * time.Second.Nanoseconds()}) {
+				t.Errorf("Unexpected uploads %v", data.Uploads)
+			}
+		})
+	}
+}
+
+func TestFileStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+	dataDir := filepath.Join(dir, "data")
+
+	store := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	if err := store.AddHeartbeat("device-1", time.Now()); err != nil {
+		t.Fatalf("AddHeartbeat failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Simulate a crash in the middle of writing a record
+	segments, err := listSegments(dataDir)
+	if err != nil {
+		t.Fatalf("listSegments failed: %v", err)
+	}
+	segmentPath := store.wal.segmentPath(segments[len(segments)-1])
+	file, err := os.OpenFile(segmentPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	if _, err := file.WriteString(`{"type":"heartbeat","dev`); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	file.Close()
+
+	store = openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	defer store.Close()
+
+	data, err := store.GetDeviceData("device-1")
+	if err != nil {
+		t.Fatalf("GetDeviceData failed: %v", err)
+	}
+	if len(data.Heartbeats) != 1 {
+		t.Errorf("Expected 1 heartbeat, got %d", len(data.Heartbeats))
+	}
+
+	// New writes after recovery must still be readable
+	if err := store.AddHeartbeat("device-1", time.Now()); err != nil {
+		t.Fatalf("AddHeartbeat after recovery failed: %v", err)
+	}
+}
+
+func TestFileStoreRestore(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Take a snapshot on one host
+	source := openFileStore(t, filepath.Join(dir, "source"), csvPath, FileStoreOptions{})
+	_ = source.AddHeartbeat("device-1", baseTime)
+	_ = source.AddHeartbeat("device-1", baseTime.Add(time.Minute))
+	var snapshot bytes.Buffer
+	if err := source.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	source.Close()
+
+	// Restore it on another host that already has unrelated data
+	dataDir := filepath.Join(dir, "target")
+	target := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	_ = target.AddUploadTime("device-1", baseTime, 42)
+	if err := target.Restore(&snapshot); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	_ = target.AddHeartbeat("device-1", baseTime.Add(2*time.Minute))
+	target.Close()
+
+	// The restored state plus later writes survive a restart
+	target = openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	defer target.Close()
+
+	data, _ := target.GetDeviceData("device-1")
+	if len(data.Heartbeats) != 3 {
+		t.Errorf("Expected 3 heartbeats, got %d", len(data.Heartbeats))
+	}
+	if len(data.Uploads) != 0 {
+		t.Errorf("Expected uploads to be replaced by the snapshot, got %v", data.Uploads)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewFileStore(dataDir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+
+	if _, err := NewFileStore(dataDir, FileStoreOptions{}); !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("Expected ErrDataDirLocked while the store is open, got %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	store, err = NewFileStore(dataDir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("Expected the directory to be unlocked after Close, got %v", err)
+	}
+	store.Close()
+}
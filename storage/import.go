@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/models"
+)
+
+const (
+	// importBatchSize is how many records are parsed before they are
+	// written to the store. Reading stops while a batch is written, so a
+	// slow store slows down the sender instead of buffering the input.
+	importBatchSize = 1000
+
+	// maxImportLineLength bounds the length of a single NDJSON record
+	maxImportLineLength = 64 * 1024
+
+	// maxImportFailures bounds how many failed lines are reported; later
+	// failures are still counted as rejected
+	maxImportFailures = 1000
+)
+
+// ErrImportLineTooLong is returned when an import record exceeds the
+// maximum line length
+var ErrImportLineTooLong = errors.New("import line too long")
+
+// ImportRecord is a single heartbeat or upload stats report in an NDJSON
+// import. Type may be omitted, in which case records with an upload time
+// are stats and all others heartbeats.
+type ImportRecord struct {
+	DeviceID   string    `json:"device_id"`
+	Type       string    `json:"type,omitempty"`
+	SentAt     time.Time `json:"sent_at"`
+	UploadTime *int64    `json:"upload_time,omitempty"` // nanoseconds
+}
+
+// ImportFailure describes a rejected import line
+type ImportFailure struct {
+	Line  int
+	Error string
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Accepted  int
+	Rejected  int
+	Failures  []ImportFailure // sorted by line, at most maxImportFailures
+	Truncated bool            // whether failures were left out of Failures
+}
+
+// Import reads newline-delimited JSON records from r and adds them to the
+// store in batches. Invalid records, records of unknown devices and, if
+// credentials are given, records of devices that must sign their reports
+// are rejected and reported by line number; blank lines are skipped. An
+// error is returned, along with the result so far, if r cannot be read or
+// the store fails.
+func Import(store DeviceStore, r io.Reader, credentials *Credentials) (ImportResult, error) {
+	importer := &importer{store: store, credentials: credentials, pending: make(map[string]*importBatch)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineLength)
+	line := 0
+	for scanner.Scan() {
+		line++
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+		importer.add(line, content)
+
+		if importer.size >= importBatchSize {
+			if err := importer.flush(); err != nil {
+				return importer.finish(), err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = ErrImportLineTooLong
+		}
+		// Still store the records read so far
+		if flushErr := importer.flush(); flushErr != nil {
+			return importer.finish(), flushErr
+		}
+		return importer.finish(), fmt.Errorf("line %d: %w", line+1, err)
+	}
+
+	err := importer.flush()
+	return importer.finish(), err
+}
+
+// importer accumulates parsed records per device until they are flushed
+type importer struct {
+	store       DeviceStore
+	credentials *Credentials // nil to accept records of every device
+	result      ImportResult
+	pending     map[string]*importBatch
+	size        int
+}
+
+// importBatch holds the pending records of one device
+type importBatch struct {
+	heartbeats []time.Time
+	uploads    []UploadSample
+	lines      []int
+}
+
+// add parses a record and queues it, or records why it was rejected
+func (im *importer) add(line int, content []byte) {
+	var record ImportRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		im.reject(line, fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+	if err := record.validate(); err != nil {
+		im.reject(line, err.Error())
+		return
+	}
+
+	batch, exists := im.pending[record.DeviceID]
+	if !exists {
+		if !im.store.DeviceExists(record.DeviceID) {
+			im.reject(line, ErrDeviceNotFound.Error())
+			return
+		}
+		if im.credentials != nil && im.credentials.SignatureRequired(record.DeviceID) {
+			im.reject(line, ErrSignatureRequired.Error())
+			return
+		}
+		batch = &importBatch{}
+		im.pending[record.DeviceID] = batch
+	}
+
+	if record.isStats() {
+		batch.uploads = append(batch.uploads, UploadSample{SentAt: record.SentAt, UploadTime: *record.UploadTime})
+	} else {
+		batch.heartbeats = append(batch.heartbeats, record.SentAt)
+	}
+	batch.lines = append(batch.lines, line)
+	im.size++
+}
+
+// flush writes the pending records to the store, one batch per device
+func (im *importer) flush() error {
+	for deviceID, batch := range im.pending {
+		err := im.store.AddBatch(deviceID, batch.heartbeats, batch.uploads)
+		switch {
+		case err == nil:
+			im.result.Accepted += len(batch.lines)
+		case errors.Is(err, ErrDeviceNotFound):
+			// Deregistered since its first record was read
+			for _, line := range batch.lines {
+				im.reject(line, err.Error())
+			}
+		default:
+			return fmt.Errorf("failed to store records for device %s: %w", deviceID, err)
+		}
+		delete(im.pending, deviceID)
+	}
+	im.size = 0
+	return nil
+}
+
+// reject counts a rejected line and reports it if there is room
+func (im *importer) reject(line int, reason string) {
+	im.result.Rejected++
+	if len(im.result.Failures) >= maxImportFailures {
+		im.result.Truncated = true
+		return
+	}
+	im.result.Failures = append(im.result.Failures, ImportFailure{Line: line, Error: reason})
+}
+
+// finish returns the result with failures sorted by line
+func (im *importer) finish() ImportResult {
+	sort.SliceStable(im.result.Failures, func(i, j int) bool {
+		return im.result.Failures[i].Line < im.result.Failures[j].Line
+	})
+	return im.result
+}
+
+// isStats reports whether the record is an upload stats report
+func (r ImportRecord) isStats() bool {
+	return r.Type == models.ReportStats || (r.Type == "" && r.UploadTime != nil)
+}
+
+// validate checks that the record has the fields its type needs, like any
+// other report
+func (r ImportRecord) validate() error {
+	if r.DeviceID == "" {
+		return fmt.Errorf("device_id is required")
+	}
+	item := models.BatchItem{Type: r.Type, SentAt: r.SentAt, UploadTime: r.UploadTime}
+	if item.Type == "" {
+		item.Type = models.ReportHeartbeat
+		if r.isStats() {
+			item.Type = models.ReportStats
+		}
+	}
+	return item.Validate()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	testCases := []struct {
+		name       string
+		input      string
+		expectErr  error
+		accepted   int
+		heartbeats int
+		uploads    int
+		failures   []ImportFailure
+	}{
+		{
+			name: "Mixed records",
+			input: `{"device_id": "device-1", "type": "heartbeat", "sent_at": "2025-01-01T00:00:00Z"}
+{"device_id": "device-1", "type": "stats", "sent_at": "2025-01-01T00:00:00Z", "upload_time": 1000}
+
+{"device_id": "device-2", "sent_at": "2025-01-01T00:00:00Z", "upload_time": 2000}
+{"device_id": "device-2", "sent_at": "2025-01-01T00:01:00Z"}
+`,
+			accepted:   4,
+			heartbeats: 1,
+			uploads:    1,
+		},
+		{
+			name: "Failures are reported by line",
+			input: `{"device_id": "device-1", "sent_at": "2025-01-01T00:00:00Z"}
+not json
+{"device_id": "unknown", "sent_at": "2025-01-01T00:00:00Z"}
+{"device_id": "device-1"}
+{"device_id": "device-1", "type": "stats", "sent_at": "2025-01-01T00:00:00Z"}
+{"device_id": "device-1", "type": "reboot", "sent_at": "2025-01-01T00:00:00Z"}
+{"sent_at": "2025-01-01T00:00:00Z"}
+{"device_id": "device-1", "sent_at": "2999-01-01T00:00:00Z"}`,
+			accepted:   1,
+			heartbeats: 1,
+			failures: []ImportFailure{
+				{Line: 2, Error: "invalid JSON: invalid character 'o' in literal null (expecting 'u')"},
+				{Line: 3, Error: "device not found"},
+				{Line: 4, Error: "sent_at is required"},
+				{Line: 5, Error: "upload_time is required for stats"},
+				{Line: 6, Error: `unknown type "reboot": expected heartbeat or stats`},
+				{Line: 7, Error: "device_id is required"},
+				{Line: 8, Error: "sent_at is more than 5m0s ahead of the server clock"},
+			},
+		},
+		{
+			name:       "Line too long",
+			input:      `{"device_id": "device-1", "sent_at": "2025-01-01T00:00:00Z"}` + "\n" + strings.Repeat(" ", maxImportLineLength+1) + "\n",
+			expectErr:  ErrImportLineTooLong,
+			accepted:   1,
+			heartbeats: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			if err := store.LoadDevicesFromCSV(writeDevicesCSV(t, t.TempDir(), "device_id\ndevice-1\ndevice-2\n")); err != nil {
+				t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+			}
+
+			result, err := Import(store, strings.NewReader(tc.input), nil)
+			if !errors.Is(err, tc.expectErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectErr, err)
+			}
+			if result.Accepted != tc.accepted || result.Rejected != len(tc.failures) {
+				t.Errorf("Expected %d accepted and %d rejected, got %+v", tc.accepted, len(tc.failures), result)
+			}
+			if len(tc.failures) > 0 && !reflect.DeepEqual(result.Failures, tc.failures) {
+				t.Errorf("Expected failures %+v, got %+v", tc.failures, result.Failures)
+			}
+
+			data, err := store.GetDeviceData("device-1")
+			if err != nil {
+				t.Fatalf("GetDeviceData failed: %v", err)
+			}
+			if len(data.Heartbeats) != tc.heartbeats || len(data.Uploads) != tc.uploads {
+				t.Errorf("Expected %d heartbeats and %d uploads, got %d and %d",
+					tc.heartbeats, tc.uploads, len(data.Heartbeats), len(data.Uploads))
+			}
+		})
+	}
+}
+
+func TestImportManyRecords(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.LoadDevicesFromCSV(writeDevicesCSV(t, t.TempDir(), "device_id\ndevice-1\n")); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+
+	// Spans several batches, with more failures than are reported
+	var input strings.Builder
+	records := 3*importBatchSize + 1
+	for i := 0; i < records; i++ {
+		fmt.Fprintf(&input, "{\"device_id\": \"device-1\", \"sent_at\": \"2025-01-01T00:00:%02dZ\"}\n", i%60)
+	}
+	for i := 0; i <= maxImportFailures; i++ {
+		input.WriteString("{}\n")
+	}
+
+	result, err := Import(store, strings.NewReader(input.String()), nil)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Accepted != records || result.Rejected != maxImportFailures+1 ||
+		len(result.Failures) != maxImportFailures || !result.Truncated {
+		t.Errorf("Unexpected result: %d accepted, %d rejected, %d failures, truncated %v",
+			result.Accepted, result.Rejected, len(result.Failures), result.Truncated)
+	}
+	if data, _ := store.GetDeviceData("device-1"); len(data.Heartbeats) != records {
+		t.Errorf("Expected %d heartbeats, got %d", records, len(data.Heartbeats))
+	}
+}
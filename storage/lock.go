@@ -0,0 +1,11 @@
+package storage
+
+import "errors"
+
+// ErrDataDirLocked is returned when another store has the data directory
+// open, e.g. a running server while importing
+var ErrDataDirLocked = errors.New("data directory is in use")
+
+// lockFileName is the file in the data directory that is locked while a
+// store has the directory open
+const lockFileName = "LOCK"
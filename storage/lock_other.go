@@ -0,0 +1,22 @@
+//go:build !unix
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// lockDir opens the lock file of dir. Locking is only supported on Unix, so
+// other platforms must not open a data directory twice.
+func lockDir(dir string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	return file, nil
+}
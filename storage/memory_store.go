@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+var _ DeviceStore = (*MemoryStore)(nil)
+
+// defaultShardCount is the number of lock-striped shards in a MemoryStore.
+// It must be a power of two.
+const defaultShardCount = 64
+
+// MemoryStore keeps all device data in memory. Devices are spread over
+// lock-striped shards by a hash of their ID so that requests for different
+// devices rarely contend on the same lock.
+type MemoryStore struct {
+	shards []*memoryShard
+	mask   uint32
+}
+
+// memoryShard holds the devices whose ID hashes to it
+type memoryShard struct {
+	devices map[string]*DeviceData
+	mu      sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory device store
+func NewMemoryStore() *MemoryStore {
+	return newMemoryStoreWithShards(defaultShardCount)
+}
+
+// newMemoryStoreWithShards creates a store with n shards; n must be a power of two
+func newMemoryStoreWithShards(n int) *MemoryStore {
+	shards := make([]*memoryShard, n)
+	for i := range shards {
+		shards[i] = &memoryShard{
+			devices: make(map[string]*DeviceData),
+		}
+	}
+	return &MemoryStore{
+		shards: shards,
+		mask:   uint32(n - 1),
+	}
+}
+
+// LoadDevicesFromCSV loads devices and their metadata from a CSV file
+func (s *MemoryStore) LoadDevicesFromCSV(filepath string) error {
+	devices, err := readDevicesCSV(filepath)
+	if err != nil {
+		return err
+	}
+
+	s.loadCSVDevices(devices)
+	return nil
+}
+
+// loadCSVDevices adds the devices listed in the CSV and refreshes the
+// metadata of those already loaded from it
+func (s *MemoryStore) loadCSVDevices(devices []DeviceInfo) {
+	now := time.Now()
+	for _, info := range devices {
+		info.RegisteredAt = now
+		if !s.addDevice(info) {
+			if current, err := s.GetDeviceInfo(info.DeviceID); err == nil && current.Source == SourceCSV {
+				_ = s.setMetadata(info.DeviceID, info.Metadata)
+			}
+		}
+	}
+}
+
+// DeviceExists checks if a device ID exists
+func (s *MemoryStore) DeviceExists(deviceID string) bool {
+	_, exists := s.lookup(deviceID)
+	return exists
+}
+
+// AddHeartbeat adds a heartbeat timestamp for a device
+func (s *MemoryStore) AddHeartbeat(deviceID string, timestamp time.Time) error {
+	return s.update(deviceID, func(device *DeviceData) error {
+		device.Heartbeats = append(device.Heartbeats, timestamp)
+		device.Lifetime.AddHeartbeat(timestamp)
+		return nil
+	})
+}
+
+// AddUploadTime adds an upload time, reported at sentAt, for a device
+func (s *MemoryStore) AddUploadTime(deviceID string, sentAt time.Time, uploadTime int64) error {
+	return s.update(deviceID, func(device *DeviceData) error {
+		device.Uploads = append(device.Uploads, UploadSample{SentAt: sentAt, UploadTime: uploadTime})
+		device.Lifetime.AddUpload(uploadTime)
+		return nil
+	})
+}
+
+// GetDeviceData retrieves a copy of device data
+func (s *MemoryStore) GetDeviceData(deviceID string) (*DeviceData, error) {
+	device, exists := s.lookup(deviceID)
+	if !exists {
+		return nil, ErrDeviceNotFound
+	}
+
+	device.mu.RLock()
+	defer device.mu.RUnlock()
+
+	// Return a copy to avoid race conditions
+	return device.clone(), nil
+}
+
+// AddBatch adds heartbeats and upload times for a device under a single
+// lock acquisition
+func (s *MemoryStore) AddBatch(deviceID string, heartbeats []time.Time, uploads []UploadSample) error {
+	return s.update(deviceID, func(device *DeviceData) error {
+		device.addBatch(heartbeats, uploads)
+		return nil
+	})
+}
+
+// AddMetrics adds a batch of metric samples for a device, all or nothing
+func (s *MemoryStore) AddMetrics(deviceID string, samples []MetricSample) error {
+	return s.update(deviceID, func(device *DeviceData) error {
+		return device.addMetrics(samples)
+	})
+}
+
+// GetMetric retrieves a copy of one metric series of a device
+func (s *MemoryStore) GetMetric(deviceID, name string) (MetricSeries, error) {
+	device, exists := s.lookup(deviceID)
+	if !exists {
+		return MetricSeries{}, ErrDeviceNotFound
+	}
+
+	device.mu.RLock()
+	defer device.mu.RUnlock()
+
+	series, exists := device.Metrics[name]
+	if !exists {
+		return MetricSeries{}, ErrMetricNotFound
+	}
+	return *series.clone(), nil
+}
+
+// GetAggregates retrieves the lifetime aggregates of a device
+func (s *MemoryStore) GetAggregates(deviceID string) (Aggregates, error) {
+	device, exists := s.lookup(deviceID)
+	if !exists {
+		return Aggregates{}, ErrDeviceNotFound
+	}
+
+	device.mu.RLock()
+	defer device.mu.RUnlock()
+	return device.Lifetime, nil
+}
+
+// Snapshot writes a consistent point-in-time copy of all device data to w
+func (s *MemoryStore) Snapshot(w io.Writer) error {
+	return encodeSnapshot(w, s.copyDevices())
+}
+
+// Restore replaces all device data with the contents of a snapshot.
+// Devices registered at runtime are restored along with their data; devices
+// from the CSV are only restored if they are registered in this store.
+// Registered devices missing from the snapshot end up with no data, or are
+// removed if they were registered at runtime.
+func (s *MemoryStore) Restore(r io.Reader) error {
+	devices, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+	s.replaceDevices(devices)
+	return nil
+}
+
+// copyDevices returns a consistent copy of the data of every device
+func (s *MemoryStore) copyDevices() map[string]*DeviceData {
+	s.lockAll()
+	defer s.unlockAll()
+
+	devices := make(map[string]*DeviceData)
+	s.forEachDeviceLocked(func(deviceID string, device *DeviceData) {
+		devices[deviceID] = device.clone()
+	})
+	return devices
+}
+
+// replaceDevices swaps in the given devices as described by Restore
+func (s *MemoryStore) replaceDevices(devices map[string]*DeviceData) {
+	s.lockAll()
+	defer s.unlockAll()
+
+	// Runtime registrations are part of the replaced state
+	for _, shard := range s.shards {
+		for deviceID, device := range shard.devices {
+			if _, ok := devices[deviceID]; !ok && device.Info.Source == SourceAPI {
+				delete(shard.devices, deviceID)
+			}
+		}
+	}
+	for deviceID, data := range devices {
+		shard := s.shard(deviceID)
+		if _, exists := shard.devices[deviceID]; !exists && data.Info.Source == SourceAPI {
+			shard.devices[deviceID] = newDeviceData(data.Info)
+		}
+	}
+
+	s.forEachDeviceLocked(func(deviceID string, device *DeviceData) {
+		device.mu.Lock()
+		if data, ok := devices[deviceID]; ok {
+			device.Heartbeats = data.Heartbeats
+			device.Uploads = data.Uploads
+			device.Lifetime = data.Lifetime
+			device.Metrics = data.Metrics
+			if data.Info.Source != "" {
+				device.Info = data.Info
+			}
+		} else {
+			device.Heartbeats = make([]time.Time, 0)
+			device.Uploads = make([]UploadSample, 0)
+			device.Lifetime = Aggregates{}
+			device.Metrics = nil
+		}
+		device.mu.Unlock()
+	})
+}
+
+// Prune drops raw samples older than cutoff, keeping lifetime aggregates
+// and the metrics in keepMetrics
+func (s *MemoryStore) Prune(cutoff time.Time, keepMetrics []string) (int, error) {
+	pruned := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for _, device := range shard.devices {
+			device.mu.Lock()
+			pruned += device.prune(cutoff)
+			pruned += device.pruneMetrics(cutoff, keepMetrics)
+			device.mu.Unlock()
+		}
+		shard.mu.RUnlock()
+	}
+	return pruned, nil
+}
+
+// PruneMetric drops samples of one metric older than cutoff
+func (s *MemoryStore) PruneMetric(name string, cutoff time.Time) (int, error) {
+	pruned := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for _, device := range shard.devices {
+			device.mu.Lock()
+			pruned += device.pruneMetric(name, cutoff)
+			device.mu.Unlock()
+		}
+		shard.mu.RUnlock()
+	}
+	return pruned, nil
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// shard returns the shard a device ID hashes to (32-bit FNV-1a)
+func (s *MemoryStore) shard(deviceID string) *memoryShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(deviceID); i++ {
+		hash ^= uint32(deviceID[i])
+		hash *= 16777619
+	}
+	return s.shards[hash&s.mask]
+}
+
+// lookup returns the data of an active (not retired) device
+func (s *MemoryStore) lookup(deviceID string) (*DeviceData, bool) {
+	shard := s.shard(deviceID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	device, exists := shard.devices[deviceID]
+	if !exists {
+		return nil, false
+	}
+
+	device.mu.RLock()
+	defer device.mu.RUnlock()
+	return device, !device.Info.Retired()
+}
+
+// addDevice registers a device with no data, keeping the existing record
+// if it is already known. It reports whether the device was added.
+func (s *MemoryStore) addDevice(info DeviceInfo) bool {
+	shard := s.shard(info.DeviceID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, exists := shard.devices[info.DeviceID]; exists {
+		return false
+	}
+	shard.devices[info.DeviceID] = newDeviceData(info)
+	return true
+}
+
+// update applies fn to a device while holding its lock and returns its
+// error. The shard read lock is held until fn returns so snapshots, which
+// write-lock every shard, always see a consistent cut across devices.
+func (s *MemoryStore) update(deviceID string, fn func(device *DeviceData) error) error {
+	shard := s.shard(deviceID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	device, exists := shard.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.Info.Retired() {
+		return ErrDeviceNotFound
+	}
+	return fn(device)
+}
+
+// lockAll write-locks every shard, always in the same order
+func (s *MemoryStore) lockAll() {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+	}
+}
+
+// unlockAll releases the locks taken by lockAll
+func (s *MemoryStore) unlockAll() {
+	for _, shard := range s.shards {
+		shard.mu.Unlock()
+	}
+}
+
+// forEachDeviceLocked calls fn for every device; the caller must hold the
+// shard locks
+func (s *MemoryStore) forEachDeviceLocked(fn func(deviceID string, device *DeviceData)) {
+	for _, shard := range s.shards {
+		for deviceID, device := range shard.devices {
+			fn(deviceID, device)
+		}
+	}
+}
+
+// addBatch appends heartbeats and uploads; the caller must hold d.mu for
+// writing
+func (d *DeviceData) addBatch(heartbeats []time.Time, uploads []UploadSample) {
+	d.Heartbeats = append(d.Heartbeats, heartbeats...)
+	for _, heartbeat := range heartbeats {
+		d.Lifetime.AddHeartbeat(heartbeat)
+	}
+	d.Uploads = append(d.Uploads, uploads...)
+	for _, upload := range uploads {
+		d.Lifetime.AddUpload(upload.UploadTime)
+	}
+}
+
+// clone returns a deep copy of the device data; the caller must hold d.mu
+func (d *DeviceData) clone() *DeviceData {
+	clone := &DeviceData{
+		Heartbeats: make([]time.Time, len(d.Heartbeats)),
+		Uploads:    make([]UploadSample, len(d.Uploads)),
+		Lifetime:   d.Lifetime,
+		Metrics:    cloneMetrics(d.Metrics),
+		Info:       d.Info,
+	}
+	copySlice(clone.Heartbeats, d.Heartbeats)
+	copyUploadSlice(clone.Uploads, d.Uploads)
+	return clone
+}
+
+// Helper function to copy time slices
+func copySlice(dst, src []time.Time) {
+	copy(dst, src)
+}
+
+// Helper function to copy upload sample slices
+func copyUploadSlice(dst, src []UploadSample) {
+	copy(dst, src)
+}
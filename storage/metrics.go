@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// MetricType is the kind of a device metric
+type MetricType string
+
+const (
+	// MetricGauge samples are point-in-time readings, such as CPU usage
+	MetricGauge MetricType = "gauge"
+	// MetricCounter samples are increments, such as errors since the last report
+	MetricCounter MetricType = "counter"
+	// MetricHistogram samples are individual observations, such as request latencies
+	MetricHistogram MetricType = "histogram"
+)
+
+// ParseMetricType validates a metric type name
+func ParseMetricType(value string) (MetricType, error) {
+	switch t := MetricType(value); t {
+	case MetricGauge, MetricCounter, MetricHistogram:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown metric type %q: expected gauge, counter or histogram", value)
+	}
+}
+
+var (
+	// ErrMetricNotFound is returned when a device has no samples of a metric
+	ErrMetricNotFound = errors.New("metric not found")
+
+	// ErrMetricTypeMismatch is returned when a sample's type or unit does
+	// not match the metric's existing samples
+	ErrMetricTypeMismatch = errors.New("metric type mismatch")
+)
+
+// MetricSample is a single reported value of a named metric
+type MetricSample struct {
+	Name      string     `json:"name"`
+	Type      MetricType `json:"type"`
+	Unit      string     `json:"unit,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+	Value     float64    `json:"value"`
+}
+
+// MetricSeries holds the samples of one metric of a device
+type MetricSeries struct {
+	Type   MetricType
+	Unit   string
+	Points []MetricPoint
+}
+
+// MetricPoint is a timestamped metric value
+type MetricPoint struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// addMetrics appends samples to the device's series, all or nothing; the
+// caller must hold d.mu for writing
+func (d *DeviceData) addMetrics(samples []MetricSample) error {
+	if err := d.checkMetrics(samples); err != nil {
+		return err
+	}
+
+	if d.Metrics == nil {
+		d.Metrics = make(map[string]*MetricSeries)
+	}
+	for _, sample := range samples {
+		series, exists := d.Metrics[sample.Name]
+		if !exists {
+			series = &MetricSeries{Type: sample.Type, Unit: sample.Unit}
+			d.Metrics[sample.Name] = series
+		}
+		series.Points = append(series.Points, MetricPoint{Timestamp: sample.Timestamp, Value: sample.Value})
+	}
+	return nil
+}
+
+// checkMetrics reports whether every sample matches the type and unit of
+// its metric, including earlier samples of the same batch; the caller must
+// hold d.mu
+func (d *DeviceData) checkMetrics(samples []MetricSample) error {
+	pending := make(map[string]MetricSample)
+	for _, sample := range samples {
+		existing, ok := pending[sample.Name]
+		if !ok {
+			if series, exists := d.Metrics[sample.Name]; exists {
+				existing = MetricSample{Type: series.Type, Unit: series.Unit}
+				ok = true
+			}
+		}
+		if ok && (existing.Type != sample.Type || existing.Unit != sample.Unit) {
+			return fmt.Errorf("%w: %s is a %s with unit %q", ErrMetricTypeMismatch, sample.Name, existing.Type, existing.Unit)
+		}
+		pending[sample.Name] = sample
+	}
+	return nil
+}
+
+// pruneMetric drops points of the named metric older than cutoff and
+// returns how many were removed; the caller must hold d.mu for writing
+func (d *DeviceData) pruneMetric(name string, cutoff time.Time) int {
+	series, exists := d.Metrics[name]
+	if !exists {
+		return 0
+	}
+	return series.prune(cutoff)
+}
+
+// pruneMetrics drops points of every metric but those in keep older than
+// cutoff and returns how many were removed; the caller must hold d.mu for
+// writing
+func (d *DeviceData) pruneMetrics(cutoff time.Time, keep []string) int {
+	pruned := 0
+	for name, series := range d.Metrics {
+		if !slices.Contains(keep, name) {
+			pruned += series.prune(cutoff)
+		}
+	}
+	return pruned
+}
+
+// prune drops points older than cutoff and returns how many were removed.
+// Emptied series are kept so the metric's type stays fixed.
+func (s *MetricSeries) prune(cutoff time.Time) int {
+	pruned := 0
+	points := s.Points[:0]
+	for _, point := range s.Points {
+		if point.Timestamp.Before(cutoff) {
+			pruned++
+			continue
+		}
+		points = append(points, point)
+	}
+	s.Points = points
+	return pruned
+}
+
+// cloneMetrics returns a deep copy of the device's metric series
+func cloneMetrics(metrics map[string]*MetricSeries) map[string]*MetricSeries {
+	if metrics == nil {
+		return nil
+	}
+	clone := make(map[string]*MetricSeries, len(metrics))
+	for name, series := range metrics {
+		clone[name] = series.clone()
+	}
+	return clone
+}
+
+// clone returns a deep copy of the series
+func (s *MetricSeries) clone() *MetricSeries {
+	clone := *s
+	clone.Points = make([]MetricPoint, len(s.Points))
+	copy(clone.Points, s.Points)
+	return &clone
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Aggregation names how a metric is summarized by default
+type Aggregation string
+
+// Supported aggregations
+const (
+	AggregationAvg   Aggregation = "avg"
+	AggregationSum   Aggregation = "sum"
+	AggregationMin   Aggregation = "min"
+	AggregationMax   Aggregation = "max"
+	AggregationLast  Aggregation = "last"
+	AggregationCount Aggregation = "count"
+	AggregationRate  Aggregation = "rate" // sum per second
+	AggregationP50   Aggregation = "p50"
+	AggregationP90   Aggregation = "p90"
+	AggregationP99   Aggregation = "p99"
+)
+
+// ParseAggregation validates an aggregation name
+func ParseAggregation(value string) (Aggregation, error) {
+	switch a := Aggregation(value); a {
+	case AggregationAvg, AggregationSum, AggregationMin, AggregationMax, AggregationLast,
+		AggregationCount, AggregationRate, AggregationP50, AggregationP90, AggregationP99:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown aggregation %q", value)
+	}
+}
+
+// DefaultAggregation is the aggregation used for metrics without a definition
+func DefaultAggregation(metricType MetricType) Aggregation {
+	if metricType == MetricCounter {
+		return AggregationSum
+	}
+	return AggregationAvg
+}
+
+// MetricDefinition configures a named metric
+type MetricDefinition struct {
+	Name        string
+	Type        MetricType
+	Unit        string        // expected unit, or empty to accept the first reported one
+	Aggregation Aggregation   // default aggregation reported by the API
+	Retention   time.Duration // how long to keep samples, 0 to follow the global retention
+}
+
+// MetricRegistry holds the configured metric definitions. Metrics without a
+// definition are accepted too; their type is fixed by the first sample.
+type MetricRegistry struct {
+	definitions map[string]MetricDefinition
+}
+
+// NewMetricRegistry creates a registry with the given definitions
+func NewMetricRegistry(definitions ...MetricDefinition) (*MetricRegistry, error) {
+	registry := &MetricRegistry{definitions: make(map[string]MetricDefinition)}
+	for _, definition := range definitions {
+		if definition.Name == "" {
+			return nil, fmt.Errorf("metric definition without a name")
+		}
+		if _, exists := registry.definitions[definition.Name]; exists {
+			return nil, fmt.Errorf("metric %q is defined twice", definition.Name)
+		}
+		if _, err := ParseMetricType(string(definition.Type)); err != nil {
+			return nil, fmt.Errorf("metric %q: %w", definition.Name, err)
+		}
+		if definition.Aggregation == "" {
+			definition.Aggregation = DefaultAggregation(definition.Type)
+		}
+		if _, err := ParseAggregation(string(definition.Aggregation)); err != nil {
+			return nil, fmt.Errorf("metric %q: %w", definition.Name, err)
+		}
+		if definition.Retention < 0 {
+			return nil, fmt.Errorf("metric %q: retention must not be negative", definition.Name)
+		}
+		registry.definitions[definition.Name] = definition
+	}
+	return registry, nil
+}
+
+// Lookup returns the definition of a metric, if it has one
+func (r *MetricRegistry) Lookup(name string) (MetricDefinition, bool) {
+	definition, ok := r.definitions[name]
+	return definition, ok
+}
+
+// Definitions returns every definition, sorted by name
+func (r *MetricRegistry) Definitions() []MetricDefinition {
+	definitions := make([]MetricDefinition, 0, len(r.definitions))
+	for _, definition := range r.definitions {
+		definitions = append(definitions, definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+	return definitions
+}
+
+// metricsConfig is the layout of a metrics config file
+type metricsConfig struct {
+	Metrics []struct {
+		Name        string `json:"name" yaml:"name"`
+		Type        string `json:"type" yaml:"type"`
+		Unit        string `json:"unit" yaml:"unit"`
+		Aggregation string `json:"aggregation" yaml:"aggregation"`
+		Retention   string `json:"retention" yaml:"retention"`
+	} `json:"metrics" yaml:"metrics"`
+}
+
+// LoadMetricRegistry reads metric definitions from a JSON or YAML file,
+// chosen by its extension
+func LoadMetricRegistry(path string) (*MetricRegistry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics config: %w", err)
+	}
+
+	var config metricsConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &config)
+	default:
+		return nil, fmt.Errorf("unsupported metrics config format %q: expected .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse metrics config: %w", err)
+	}
+
+	definitions := make([]MetricDefinition, 0, len(config.Metrics))
+	for _, metric := range config.Metrics {
+		definition := MetricDefinition{
+			Name:        metric.Name,
+			Type:        MetricType(metric.Type),
+			Unit:        metric.Unit,
+			Aggregation: Aggregation(metric.Aggregation),
+		}
+		if metric.Retention != "" {
+			if definition.Retention, err = ParseRetention(metric.Retention); err != nil {
+				return nil, fmt.Errorf("metric %q: %w", metric.Name, err)
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+	return NewMetricRegistry(definitions...)
+}
+
+// ParseRetention parses a duration such as "36h", also accepting whole days
+// such as "7d"
+func ParseRetention(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	retention, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q", value)
+	}
+	return retention, nil
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddMetrics(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := newLoadedMemoryStore(t, "device-1")
+
+	cpu := func(offset time.Duration, value float64) MetricSample {
+		return MetricSample{Name: "cpu", Type: MetricGauge, Unit: "percent", Timestamp: baseTime.Add(offset), Value: value}
+	}
+
+	if err := store.AddMetrics("device-1", []MetricSample{cpu(0, 10), cpu(time.Minute, 20)}); err != nil {
+		t.Fatalf("AddMetrics failed: %v", err)
+	}
+
+	testCases := []struct {
+		name    string
+		samples []MetricSample
+	}{
+		{
+			name:    "Type differs from stored series",
+			samples: []MetricSample{{Name: "cpu", Type: MetricCounter, Unit: "percent", Value: 1}},
+		},
+		{
+			name:    "Unit differs from stored series",
+			samples: []MetricSample{{Name: "cpu", Type: MetricGauge, Unit: "ratio", Value: 1}},
+		},
+		{
+			name: "Type differs within the batch",
+			samples: []MetricSample{
+				{Name: "errors", Type: MetricCounter, Value: 1},
+				{Name: "errors", Type: MetricGauge, Value: 1},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// A valid sample in the same batch must not be stored either
+			samples := append([]MetricSample{cpu(2*time.Minute, 30)}, tc.samples...)
+			if err := store.AddMetrics("device-1", samples); !errors.Is(err, ErrMetricTypeMismatch) {
+				t.Fatalf("Expected ErrMetricTypeMismatch, got %v", err)
+			}
+			series, err := store.GetMetric("device-1", "cpu")
+			if err != nil {
+				t.Fatalf("GetMetric failed: %v", err)
+			}
+			if len(series.Points) != 2 {
+				t.Errorf("Expected the batch to be rejected as a whole, got %d points", len(series.Points))
+			}
+		})
+	}
+
+	if _, err := store.GetMetric("device-1", "errors"); !errors.Is(err, ErrMetricNotFound) {
+		t.Errorf("Expected ErrMetricNotFound, got %v", err)
+	}
+	if err := store.AddMetrics("unknown", []MetricSample{cpu(0, 1)}); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+	}
+
+	// Pruning keeps the series, and with it the metric's type
+	pruned, err := store.PruneMetric("cpu", baseTime.Add(30*time.Second))
+	if err != nil || pruned != 1 {
+		t.Fatalf("Expected 1 pruned sample, got %d (err %v)", pruned, err)
+	}
+	series, _ := store.GetMetric("device-1", "cpu")
+	if series.Type != MetricGauge || len(series.Points) != 1 || series.Points[0].Value != 20 {
+		t.Errorf("Unexpected series after pruning %+v", series)
+	}
+}
+
+func TestFileStoreMetricsRecovery(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, compact := range []bool{false, true} {
+		name := "Replay WAL"
+		if compact {
+			name = "Replay snapshot after compaction"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+			dataDir := filepath.Join(dir, "data")
+
+			store := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+			samples := []MetricSample{
+				{Name: "temperature", Type: MetricGauge, Unit: "celsius", Timestamp: baseTime, Value: 21.5},
+				{Name: "errors", Type: MetricCounter, Timestamp: baseTime, Value: 3},
+				{Name: "temperature", Type: MetricGauge, Unit: "celsius", Timestamp: baseTime.Add(time.Minute), Value: 22.25},
+			}
+			if err := store.AddMetrics("device-1", samples); err != nil {
+				t.Fatalf("AddMetrics failed: %v", err)
+			}
+			if err := store.AddMetrics("device-1", []MetricSample{{Name: "errors", Type: MetricGauge}}); err == nil {
+				t.Fatal("Expected mismatched batch to be rejected")
+			}
+			if _, err := store.PruneMetric("temperature", baseTime.Add(time.Second)); err != nil {
+				t.Fatalf("PruneMetric failed: %v", err)
+			}
+			if compact {
+				if err := store.Compact(); err != nil {
+					t.Fatalf("Compact failed: %v", err)
+				}
+			}
+			store.Close()
+
+			store = openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+			defer store.Close()
+
+			series, err := store.GetMetric("device-1", "temperature")
+			if err != nil {
+				t.Fatalf("GetMetric failed: %v", err)
+			}
+			if series.Type != MetricGauge || series.Unit != "celsius" || len(series.Points) != 1 ||
+				series.Points[0].Value != 22.25 || !series.Points[0].Timestamp.Equal(baseTime.Add(time.Minute)) {
+				t.Errorf("Unexpected series %+v", series)
+			}
+			series, err = store.GetMetric("device-1", "errors")
+			if err != nil || series.Type != MetricCounter || len(series.Points) != 1 {
+				t.Errorf("Unexpected series %+v (err %v)", series, err)
+			}
+		})
+	}
+}
+
+func TestLoadMetricRegistry(t *testing.T) {
+	testCases := []struct {
+		name        string
+		filename    string
+		content     string
+		expectError bool
+		expected    []MetricDefinition
+	}{
+		{
+			name:     "YAML",
+			filename: "metrics.yaml",
+			content: `metrics:
+  - name: cpu_usage
+    type: gauge
+    unit: percent
+    aggregation: avg
+    retention: 24h
+  - name: error_rate
+    type: counter
+    retention: 7d
+`,
+			expected: []MetricDefinition{
+				{Name: "cpu_usage", Type: MetricGauge, Unit: "percent", Aggregation: AggregationAvg, Retention: 24 * time.Hour},
+				{Name: "error_rate", Type: MetricCounter, Aggregation: AggregationSum, Retention: 7 * 24 * time.Hour},
+			},
+		},
+		{
+			name:     "JSON",
+			filename: "metrics.json",
+			content:  `{"metrics": [{"name": "latency", "type": "histogram", "unit": "ms", "aggregation": "p99"}]}`,
+			expected: []MetricDefinition{
+				{Name: "latency", Type: MetricHistogram, Unit: "ms", Aggregation: AggregationP99},
+			},
+		},
+		{
+			name:        "Unknown type",
+			filename:    "metrics.json",
+			content:     `{"metrics": [{"name": "latency", "type": "summary"}]}`,
+			expectError: true,
+		},
+		{
+			name:        "Duplicate metric",
+			filename:    "metrics.json",
+			content:     `{"metrics": [{"name": "a", "type": "gauge"}, {"name": "a", "type": "gauge"}]}`,
+			expectError: true,
+		},
+		{
+			name:        "Invalid retention",
+			filename:    "metrics.yml",
+			content:     "metrics:\n  - name: a\n    type: gauge\n    retention: soon\n",
+			expectError: true,
+		},
+		{
+			name:        "Unsupported format",
+			filename:    "metrics.toml",
+			content:     "",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.filename)
+			if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			registry, err := LoadMetricRegistry(path)
+			if tc.expectError {
+				if err == nil {
+					t.Fatal("Expected error but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadMetricRegistry failed: %v", err)
+			}
+
+			definitions := registry.Definitions()
+			if len(definitions) != len(tc.expected) {
+				t.Fatalf("Expected %d definitions, got %d", len(tc.expected), len(definitions))
+			}
+			for i, definition := range definitions {
+				if definition != tc.expected[i] {
+					t.Errorf("Expected %+v, got %+v", tc.expected[i], definition)
+				}
+			}
+		})
+	}
+}
+
+func TestJanitorMetricRetention(t *testing.T) {
+	now := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	store := newLoadedMemoryStore(t, "device-1")
+	_ = store.AddHeartbeat("device-1", now.Add(-48*time.Hour))
+	_ = store.AddMetrics("device-1", []MetricSample{
+		{Name: "short", Type: MetricGauge, Timestamp: now.Add(-2 * time.Hour)},
+		{Name: "long", Type: MetricGauge, Timestamp: now.Add(-2 * time.Hour)},
+	})
+
+	metrics, err := NewMetricRegistry(MetricDefinition{Name: "short", Type: MetricGauge, Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("NewMetricRegistry failed: %v", err)
+	}
+
+	// Without a global retention only the configured metric is pruned
+	janitor := NewJanitor(store, 0, metrics, time.Hour)
+	janitor.now = func() time.Time { return now }
+	pruned, err := janitor.RunOnce()
+	if err != nil || pruned != 1 {
+		t.Fatalf("Expected 1 pruned sample, got %d (err %v)", pruned, err)
+	}
+
+	short, _ := store.GetMetric("device-1", "short")
+	long, _ := store.GetMetric("device-1", "long")
+	data, _ := store.GetDeviceData("device-1")
+	if len(short.Points) != 0 || len(long.Points) != 1 || len(data.Heartbeats) != 1 {
+		t.Errorf("Unexpected data after pruning: short %d, long %d, heartbeats %d",
+			len(short.Points), len(long.Points), len(data.Heartbeats))
+	}
+}
+
+func TestJanitorMetricRetentionLongerThanGlobal(t *testing.T) {
+	now := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+
+	testCases := []struct {
+		name  string
+		open  func(t *testing.T) DeviceStore
+		check func(t *testing.T) DeviceStore // reopens the store to replay the WAL
+	}{
+		{
+			name: "Memory store",
+			open: func(t *testing.T) DeviceStore {
+				return newLoadedMemoryStore(t, "device-1")
+			},
+		},
+		{
+			name: "File store replay",
+			open: func(t *testing.T) DeviceStore {
+				return openFileStore(t, filepath.Join(dir, "data"), csvPath, FileStoreOptions{})
+			},
+			check: func(t *testing.T) DeviceStore {
+				return openFileStore(t, filepath.Join(dir, "data"), csvPath, FileStoreOptions{})
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.open(t)
+			for _, age := range []time.Duration{10 * 24 * time.Hour, time.Hour} {
+				if err := store.AddMetrics("device-1", []MetricSample{
+					{Name: "long", Type: MetricGauge, Timestamp: now.Add(-age)},
+					{Name: "default", Type: MetricGauge, Timestamp: now.Add(-age)},
+				}); err != nil {
+					t.Fatalf("AddMetrics failed: %v", err)
+				}
+			}
+
+			metrics, err := NewMetricRegistry(
+				MetricDefinition{Name: "long", Type: MetricGauge, Retention: 30 * 24 * time.Hour},
+				MetricDefinition{Name: "default", Type: MetricGauge},
+			)
+			if err != nil {
+				t.Fatalf("NewMetricRegistry failed: %v", err)
+			}
+
+			// A metric's own retention wins over a shorter global one
+			janitor := NewJanitor(store, 7*24*time.Hour, metrics, time.Hour)
+			janitor.now = func() time.Time { return now }
+			if pruned, err := janitor.RunOnce(); err != nil || pruned != 1 {
+				t.Fatalf("Expected 1 pruned sample, got %d (err %v)", pruned, err)
+			}
+
+			if tc.check != nil {
+				store.Close()
+				store = tc.check(t)
+			}
+			defer store.Close()
+
+			long, _ := store.GetMetric("device-1", "long")
+			standard, _ := store.GetMetric("device-1", "default")
+			if len(long.Points) != 2 || len(standard.Points) != 1 {
+				t.Errorf("Expected 2 long and 1 default points, got %d and %d", len(long.Points), len(standard.Points))
+			}
+		})
+	}
+}
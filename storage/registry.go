@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDeviceNotFound is returned when a device is not registered
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// ErrDeviceExists is returned when registering a device that is
+	// already registered
+	ErrDeviceExists = errors.New("device already exists")
+
+	// ErrInvalidDevice is returned when registering a device with an invalid
+	// ID or metadata
+	ErrInvalidDevice = errors.New("invalid device")
+)
+
+// DeviceSource records how a device was registered
+type DeviceSource string
+
+const (
+	// SourceCSV devices come from the devices CSV file and are registered
+	// again on every startup
+	SourceCSV DeviceSource = "csv"
+	// SourceAPI devices were registered at runtime and are persisted by the
+	// store itself
+	SourceAPI DeviceSource = "api"
+)
+
+// DeviceMetadata describes a device
+type DeviceMetadata struct {
+	Model      string            `json:"model,omitempty"`
+	Firmware   string            `json:"firmware,omitempty"`
+	Site       string            `json:"site,omitempty"`
+	Region     string            `json:"region,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"` // any other CSV columns
+}
+
+// Equal reports whether two sets of metadata are the same
+func (m DeviceMetadata) Equal(other DeviceMetadata) bool {
+	return m.Model == other.Model &&
+		m.Firmware == other.Firmware &&
+		m.Site == other.Site &&
+		m.Region == other.Region &&
+		m.Owner == other.Owner &&
+		slices.Equal(m.Tags, other.Tags) &&
+		maps.Equal(m.Attributes, other.Attributes)
+}
+
+// clone returns a deep copy of the metadata
+func (m DeviceMetadata) clone() DeviceMetadata {
+	m.Tags = slices.Clone(m.Tags)
+	m.Attributes = maps.Clone(m.Attributes)
+	return m
+}
+
+// DeviceInfo holds the registration details of a device
+type DeviceInfo struct {
+	DeviceID     string
+	Source       DeviceSource
+	Metadata     DeviceMetadata
+	RegisteredAt time.Time
+	RetiredAt    time.Time // zero while the device is active
+}
+
+// Retired reports whether the device was deregistered with its data retained
+func (i DeviceInfo) Retired() bool {
+	return !i.RetiredAt.IsZero()
+}
+
+// Validate checks that the device ID and metadata can be stored
+func (i DeviceInfo) Validate() error {
+	if i.DeviceID == "" {
+		return fmt.Errorf("%w: device ID is required", ErrInvalidDevice)
+	}
+	if strings.ContainsAny(i.DeviceID, "/?#") {
+		return fmt.Errorf("%w: device ID must not contain '/', '?' or '#'", ErrInvalidDevice)
+	}
+
+	fields := append([]string{i.DeviceID, i.Metadata.Model, i.Metadata.Firmware,
+		i.Metadata.Site, i.Metadata.Region, i.Metadata.Owner}, i.Metadata.Tags...)
+	for key, value := range i.Metadata.Attributes {
+		fields = append(fields, key, value)
+	}
+	for _, field := range fields {
+		if len(field) > maxSnapshotStringLength {
+			return fmt.Errorf("%w: values must be at most %d bytes", ErrInvalidDevice, maxSnapshotStringLength)
+		}
+	}
+	return nil
+}
+
+// RegisterDevice registers a device at runtime. Registering a retired device
+// reactivates it with its retained data.
+func (s *MemoryStore) RegisterDevice(info DeviceInfo) error {
+	if err := info.Validate(); err != nil {
+		return err
+	}
+
+	shard := s.shard(info.DeviceID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if device, exists := shard.devices[info.DeviceID]; exists {
+		device.mu.Lock()
+		defer device.mu.Unlock()
+
+		if !device.Info.Retired() {
+			return ErrDeviceExists
+		}
+		info.Metadata = info.Metadata.clone()
+		device.Info = info
+		return nil
+	}
+
+	info.Metadata = info.Metadata.clone()
+	shard.devices[info.DeviceID] = newDeviceData(info)
+	return nil
+}
+
+// DeregisterDevice removes a device. With retainData the device is retired
+// instead: it stops accepting samples but keeps its data until it is
+// registered again. Devices from the CSV are always retired, with their data
+// dropped unless retained, so they are not registered again on restart.
+func (s *MemoryStore) DeregisterDevice(deviceID string, retainData bool) error {
+	return s.deregisterDevice(deviceID, retainData, time.Now())
+}
+
+// deregisterDevice removes or retires a device as of the given time
+func (s *MemoryStore) deregisterDevice(deviceID string, retainData bool, at time.Time) error {
+	shard := s.shard(deviceID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	device, exists := shard.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	device.mu.Lock()
+	defer device.mu.Unlock()
+
+	if !retainData {
+		if device.Info.Source != SourceCSV {
+			delete(shard.devices, deviceID)
+			return nil
+		}
+		device.Heartbeats = make([]time.Time, 0)
+		device.Uploads = make([]UploadSample, 0)
+		device.Lifetime = Aggregates{}
+		device.Metrics = nil
+	} else if device.Info.Retired() {
+		return ErrDeviceNotFound
+	}
+
+	if !device.Info.Retired() {
+		device.Info.RetiredAt = at
+	}
+	return nil
+}
+
+// setMetadata replaces the metadata of a device
+func (s *MemoryStore) setMetadata(deviceID string, metadata DeviceMetadata) error {
+	shard := s.shard(deviceID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	device, exists := shard.devices[deviceID]
+	if !exists {
+		return ErrDeviceNotFound
+	}
+
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	device.Info.Metadata = metadata.clone()
+	return nil
+}
+
+// GetDeviceInfo retrieves the registration details of a device, including
+// retired ones
+func (s *MemoryStore) GetDeviceInfo(deviceID string) (DeviceInfo, error) {
+	shard := s.shard(deviceID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	device, exists := shard.devices[deviceID]
+	if !exists {
+		return DeviceInfo{}, ErrDeviceNotFound
+	}
+
+	device.mu.RLock()
+	defer device.mu.RUnlock()
+	return device.Info, nil
+}
+
+// ListDevices returns the registration details of every device, including
+// retired ones, sorted by device ID
+func (s *MemoryStore) ListDevices() []DeviceInfo {
+	var devices []DeviceInfo
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for _, device := range shard.devices {
+			device.mu.RLock()
+			devices = append(devices, device.Info)
+			device.mu.RUnlock()
+		}
+		shard.mu.RUnlock()
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+	return devices
+}
+
+// newDeviceData creates the record of a newly registered device
+func newDeviceData(info DeviceInfo) *DeviceData {
+	return &DeviceData{
+		Heartbeats: make([]time.Time, 0),
+		Uploads:    make([]UploadSample, 0),
+		Info:       info,
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegisterDevice(t *testing.T) {
+	store := newLoadedMemoryStore(t, "csv-1")
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	info := DeviceInfo{
+		DeviceID:     "api-1",
+		Source:       SourceAPI,
+		Metadata:     DeviceMetadata{Model: "m1", Tags: []string{"a", "b"}},
+		RegisteredAt: baseTime,
+	}
+	if err := store.RegisterDevice(info); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	if err := store.RegisterDevice(info); !errors.Is(err, ErrDeviceExists) {
+		t.Errorf("Expected ErrDeviceExists, got %v", err)
+	}
+	if err := store.RegisterDevice(DeviceInfo{DeviceID: "a/b"}); !errors.Is(err, ErrInvalidDevice) {
+		t.Errorf("Expected ErrInvalidDevice, got %v", err)
+	}
+	if !store.DeviceExists("api-1") {
+		t.Fatal("Expected registered device to exist")
+	}
+	if err := store.AddHeartbeat("api-1", baseTime); err != nil {
+		t.Fatalf("AddHeartbeat failed: %v", err)
+	}
+
+	devices := store.ListDevices()
+	if len(devices) != 2 || devices[0].DeviceID != "api-1" || devices[1].DeviceID != "csv-1" {
+		t.Fatalf("Unexpected devices %+v", devices)
+	}
+	if devices[0].Metadata.Model != "m1" || devices[1].Source != SourceCSV {
+		t.Errorf("Unexpected registration details %+v", devices)
+	}
+}
+
+func TestDeregisterDevice(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		deviceID   string
+		retainData bool
+		expectInfo bool // device still listed
+		expectData int  // heartbeats once registered again
+	}{
+		{name: "Remove runtime device", deviceID: "api-1", expectInfo: false},
+		{name: "Retire runtime device", deviceID: "api-1", retainData: true, expectInfo: true, expectData: 1},
+		{name: "Remove CSV device", deviceID: "csv-1", expectInfo: true},
+		{name: "Retire CSV device", deviceID: "csv-1", retainData: true, expectInfo: true, expectData: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newLoadedMemoryStore(t, "csv-1")
+			if err := store.RegisterDevice(DeviceInfo{DeviceID: "api-1", Source: SourceAPI}); err != nil {
+				t.Fatalf("RegisterDevice failed: %v", err)
+			}
+			_ = store.AddHeartbeat(tc.deviceID, baseTime)
+
+			if err := store.DeregisterDevice(tc.deviceID, tc.retainData); err != nil {
+				t.Fatalf("DeregisterDevice failed: %v", err)
+			}
+			if store.DeviceExists(tc.deviceID) {
+				t.Error("Expected deregistered device to be gone")
+			}
+			if err := store.AddHeartbeat(tc.deviceID, baseTime); !errors.Is(err, ErrDeviceNotFound) {
+				t.Errorf("Expected ErrDeviceNotFound, got %v", err)
+			}
+			if err := store.DeregisterDevice(tc.deviceID, true); !errors.Is(err, ErrDeviceNotFound) {
+				t.Errorf("Expected ErrDeviceNotFound deregistering twice, got %v", err)
+			}
+
+			info, err := store.GetDeviceInfo(tc.deviceID)
+			if (err == nil) != tc.expectInfo {
+				t.Fatalf("Expected device listed: %v, got error %v", tc.expectInfo, err)
+			}
+			if tc.expectInfo && !info.Retired() {
+				t.Error("Expected device to be retired")
+			}
+
+			// Registering again revives retained data
+			if err := store.RegisterDevice(DeviceInfo{DeviceID: tc.deviceID, Source: SourceAPI}); err != nil {
+				t.Fatalf("RegisterDevice failed: %v", err)
+			}
+			data, err := store.GetDeviceData(tc.deviceID)
+			if err != nil {
+				t.Fatalf("GetDeviceData failed: %v", err)
+			}
+			if len(data.Heartbeats) != tc.expectData {
+				t.Errorf("Expected %d heartbeats, got %d", tc.expectData, len(data.Heartbeats))
+			}
+		})
+	}
+}
+
+func TestFileStoreRegistrationRecovery(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, compact := range []bool{false, true} {
+		name := "Replay WAL"
+		if compact {
+			name = "Replay snapshot after compaction"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := writeDevicesCSV(t, dir, "device_id\ncsv-1\ncsv-2\n")
+			dataDir := filepath.Join(dir, "data")
+
+			store := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+			for _, deviceID := range []string{"api-1", "api-2"} {
+				info := DeviceInfo{
+					DeviceID:     deviceID,
+					Source:       SourceAPI,
+					Metadata:     DeviceMetadata{Site: "lab", Tags: []string{"x"}, Attributes: map[string]string{"rack": "r1"}},
+					RegisteredAt: baseTime,
+				}
+				if err := store.RegisterDevice(info); err != nil {
+					t.Fatalf("RegisterDevice failed: %v", err)
+				}
+			}
+			_ = store.AddHeartbeat("api-1", baseTime)
+			_ = store.AddHeartbeat("csv-1", baseTime)
+			if err := store.DeregisterDevice("api-2", false); err != nil {
+				t.Fatalf("DeregisterDevice failed: %v", err)
+			}
+			if err := store.DeregisterDevice("csv-1", true); err != nil {
+				t.Fatalf("DeregisterDevice failed: %v", err)
+			}
+			if compact {
+				if err := store.Compact(); err != nil {
+					t.Fatalf("Compact failed: %v", err)
+				}
+			}
+			store.Close()
+
+			store = openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+			defer store.Close()
+
+			info, err := store.GetDeviceInfo("api-1")
+			if err != nil {
+				t.Fatalf("GetDeviceInfo failed: %v", err)
+			}
+			if info.Source != SourceAPI || info.Metadata.Site != "lab" || len(info.Metadata.Tags) != 1 ||
+				info.Metadata.Attributes["rack"] != "r1" ||
+				!info.RegisteredAt.Equal(baseTime) {
+				t.Errorf("Unexpected registration %+v", info)
+			}
+			if data, err := store.GetDeviceData("api-1"); err != nil || len(data.Heartbeats) != 1 {
+				t.Errorf("Expected api-1 data to be recovered, got %v, %v", data, err)
+			}
+			if _, err := store.GetDeviceInfo("api-2"); !errors.Is(err, ErrDeviceNotFound) {
+				t.Errorf("Expected api-2 to stay removed, got %v", err)
+			}
+			// The CSV still lists csv-1, so it is reactivated like on a reload
+			if data, err := store.GetDeviceData("csv-1"); err != nil || len(data.Heartbeats) != 1 {
+				t.Errorf("Expected csv-1 to be reactivated with its data, got %v, %v", data, err)
+			}
+			if !store.DeviceExists("csv-2") {
+				t.Error("Expected csv-2 to exist")
+			}
+		})
+	}
+}
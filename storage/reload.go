@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ReloadResult summarizes the changes made by reloading the devices CSV
+type ReloadResult struct {
+	Added     []string // devices listed for the first time, or listed again after being retired
+	Retired   []string // CSV devices that are no longer listed
+	Updated   []string // CSV devices whose metadata changed
+	Unchanged int
+}
+
+// ReloadDevicesFromCSV brings the registered devices in line with a CSV
+// file: listed devices are added (or reactivated), the metadata of CSV
+// devices is refreshed and CSV devices that are no longer listed are
+// retired. Device data is never dropped, and devices registered through the
+// API are left alone unless they were retired and the CSV lists them.
+func (s *MemoryStore) ReloadDevicesFromCSV(filepath string) (ReloadResult, error) {
+	devices, err := readDevicesCSV(filepath)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	result, listed := s.csvChanges(devices)
+	now := time.Now()
+	for _, deviceID := range result.Added {
+		info := listed[deviceID]
+		info.RegisteredAt = now
+		if err := s.RegisterDevice(info); err != nil && !errors.Is(err, ErrDeviceExists) {
+			return result, err
+		}
+	}
+	for _, deviceID := range result.Updated {
+		if err := s.setMetadata(deviceID, listed[deviceID].Metadata); err != nil && !errors.Is(err, ErrDeviceNotFound) {
+			return result, err
+		}
+	}
+	for _, deviceID := range result.Retired {
+		if err := s.deregisterDevice(deviceID, true, now); err != nil && !errors.Is(err, ErrDeviceNotFound) {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// csvChanges works out which devices a reload of the CSV adds, updates and
+// retires. It also returns the listed devices by ID.
+func (s *MemoryStore) csvChanges(devices []DeviceInfo) (ReloadResult, map[string]DeviceInfo) {
+	var result ReloadResult
+
+	listed := make(map[string]DeviceInfo, len(devices))
+	for _, info := range devices {
+		listed[info.DeviceID] = info
+
+		current, err := s.GetDeviceInfo(info.DeviceID)
+		switch {
+		case err != nil || current.Retired():
+			result.Added = append(result.Added, info.DeviceID)
+		case current.Source == SourceCSV && !current.Metadata.Equal(info.Metadata):
+			result.Updated = append(result.Updated, info.DeviceID)
+		default:
+			result.Unchanged++
+		}
+	}
+
+	for _, info := range s.ListDevices() {
+		if _, ok := listed[info.DeviceID]; !ok && info.Source == SourceCSV && !info.Retired() {
+			result.Retired = append(result.Retired, info.DeviceID)
+		}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+	return result, listed
+}
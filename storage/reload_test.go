@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloadDevicesFromCSV(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	stores := map[string]func(t *testing.T, dir, csvPath string) DeviceStore{
+		"Memory store": func(t *testing.T, dir, csvPath string) DeviceStore {
+			store := NewMemoryStore()
+			if err := store.LoadDevicesFromCSV(csvPath); err != nil {
+				t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+			}
+			return store
+		},
+		"File store": func(t *testing.T, dir, csvPath string) DeviceStore {
+			return openFileStore(t, filepath.Join(dir, "data"), csvPath, FileStoreOptions{})
+		},
+	}
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\ndevice-2\n")
+			store := open(t, dir, csvPath)
+			defer store.Close()
+
+			if err := store.RegisterDevice(DeviceInfo{DeviceID: "api-1", Source: SourceAPI}); err != nil {
+				t.Fatalf("RegisterDevice failed: %v", err)
+			}
+			_ = store.AddHeartbeat("device-1", baseTime)
+
+			// device-1 is removed, device-3 is added
+			writeDevicesCSV(t, dir, "device_id\ndevice-2\ndevice-3\n")
+			result, err := store.ReloadDevicesFromCSV(csvPath)
+			if err != nil {
+				t.Fatalf("ReloadDevicesFromCSV failed: %v", err)
+			}
+			expected := ReloadResult{Added: []string{"device-3"}, Retired: []string{"device-1"}, Unchanged: 1}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("Expected %+v, got %+v", expected, result)
+			}
+			if store.DeviceExists("device-1") || !store.DeviceExists("device-3") || !store.DeviceExists("api-1") {
+				t.Errorf("Unexpected devices after reload: %+v", store.ListDevices())
+			}
+
+			// Listing device-1 again brings back its data
+			writeDevicesCSV(t, dir, "device_id\ndevice-1\ndevice-2\ndevice-3\n")
+			result, err = store.ReloadDevicesFromCSV(csvPath)
+			if err != nil {
+				t.Fatalf("ReloadDevicesFromCSV failed: %v", err)
+			}
+			if !reflect.DeepEqual(result.Added, []string{"device-1"}) || result.Retired != nil || result.Unchanged != 2 {
+				t.Errorf("Unexpected result %+v", result)
+			}
+			data, err := store.GetDeviceData("device-1")
+			if err != nil {
+				t.Fatalf("GetDeviceData failed: %v", err)
+			}
+			if len(data.Heartbeats) != 1 {
+				t.Errorf("Expected retained heartbeat, got %d", len(data.Heartbeats))
+			}
+
+			// A missing file leaves the devices alone
+			if _, err := store.ReloadDevicesFromCSV(filepath.Join(dir, "missing.csv")); err == nil {
+				t.Error("Expected error for missing file")
+			}
+			if len(store.ListDevices()) != 4 {
+				t.Errorf("Expected 4 devices, got %d", len(store.ListDevices()))
+			}
+		})
+	}
+}
+
+func TestReloadDevicesFromCSVMetadata(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id,site\ndevice-1,lab\ndevice-2,lab\n")
+	dataDir := filepath.Join(dir, "data")
+
+	store := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	writeDevicesCSV(t, dir, "device_id,site,rack\ndevice-1,field,r1\ndevice-2,lab\n")
+	result, err := store.ReloadDevicesFromCSV(csvPath)
+	if err != nil {
+		t.Fatalf("ReloadDevicesFromCSV failed: %v", err)
+	}
+	if !reflect.DeepEqual(result.Updated, []string{"device-1"}) || result.Unchanged != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	store.Close()
+
+	// The file wins over the WAL on restart
+	writeDevicesCSV(t, dir, "device_id,site\ndevice-1,depot\ndevice-2,lab\n")
+	store = openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	defer store.Close()
+	info, err := store.GetDeviceInfo("device-1")
+	if err != nil {
+		t.Fatalf("GetDeviceInfo failed: %v", err)
+	}
+	if info.Metadata.Site != "depot" || info.Metadata.Attributes != nil {
+		t.Errorf("Unexpected metadata %+v", info.Metadata)
+	}
+}
+
+func TestFileStoreReloadRecovery(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+	dataDir := filepath.Join(dir, "data")
+
+	// device-2 is added by a reload, device-1 is retired by another
+	store := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+	writeDevicesCSV(t, dir, "device_id\ndevice-1\ndevice-2\n")
+	if _, err := store.ReloadDevicesFromCSV(csvPath); err != nil {
+		t.Fatalf("ReloadDevicesFromCSV failed: %v", err)
+	}
+	_ = store.AddHeartbeat("device-1", baseTime)
+	_ = store.AddHeartbeat("device-2", baseTime)
+	writeDevicesCSV(t, dir, "device_id\ndevice-2\n")
+	if _, err := store.ReloadDevicesFromCSV(csvPath); err != nil {
+		t.Fatalf("ReloadDevicesFromCSV failed: %v", err)
+	}
+	store.Close()
+
+	// While the server is down device-1 is listed again and device-2 removed
+	writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+	for _, restart := range []string{"First restart", "Second restart"} {
+		t.Run(restart, func(t *testing.T) {
+			store := openFileStore(t, dataDir, csvPath, FileStoreOptions{})
+			defer store.Close()
+
+			if !store.DeviceExists("device-1") {
+				t.Error("Expected device-1 to be reactivated")
+			}
+			if data, err := store.GetDeviceData("device-1"); err != nil || len(data.Heartbeats) != 1 {
+				t.Errorf("Expected the retained heartbeat of device-1, got %+v (%v)", data, err)
+			}
+
+			if store.DeviceExists("device-2") {
+				t.Error("Expected device-2 to be retired")
+			}
+			info, err := store.GetDeviceInfo("device-2")
+			if err != nil || !info.Retired() {
+				t.Errorf("Expected device-2 to be kept as retired, got %+v (%v)", info, err)
+			}
+		})
+	}
+}
+
+func TestCSVWatcher(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\n")
+	store := NewMemoryStore()
+	if err := store.LoadDevicesFromCSV(csvPath); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+
+	watcher := NewCSVWatcher(store, csvPath, 10*time.Millisecond)
+	watcher.Start()
+	defer watcher.Stop()
+
+	if _, ok := watcher.LastReload(); ok {
+		t.Error("Expected no reload before the file changes")
+	}
+
+	// Replace the file the way editors do, through a rename
+	tmpPath := filepath.Join(dir, "devices.csv.tmp")
+	if err := os.WriteFile(tmpPath, []byte("device_id\ndevice-1\ndevice-2\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write CSV file: %v", err)
+	}
+	if err := os.Rename(tmpPath, csvPath); err != nil {
+		t.Fatalf("Failed to replace CSV file: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !store.DeviceExists("device-2") {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the CSV to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status, ok := watcher.LastReload()
+	if !ok || status.Trigger != TriggerFileChange || status.Err != nil {
+		t.Errorf("Unexpected reload status %+v", status)
+	}
+
+	status = watcher.Reload(TriggerSignal)
+	if status.Err != nil || status.Result.Unchanged != 2 || len(status.Result.Added) != 0 {
+		t.Errorf("Unexpected reload status %+v", status)
+	}
+}
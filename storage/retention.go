@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// prune drops samples older than cutoff and returns how many were removed;
+// the caller must hold d.mu for writing. Lifetime aggregates are kept.
+func (d *DeviceData) prune(cutoff time.Time) int {
+	pruned := 0
+
+	heartbeats := d.Heartbeats[:0]
+	for _, heartbeat := range d.Heartbeats {
+		if heartbeat.Before(cutoff) {
+			pruned++
+			continue
+		}
+		heartbeats = append(heartbeats, heartbeat)
+	}
+	d.Heartbeats = heartbeats
+
+	uploads := d.Uploads[:0]
+	for _, upload := range d.Uploads {
+		if upload.SentAt.Before(cutoff) {
+			pruned++
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	d.Uploads = uploads
+
+	return pruned
+}
+
+// Janitor periodically prunes samples older than the retention window, and
+// metric samples older than their metric's own retention
+type Janitor struct {
+	store     DeviceStore
+	retention time.Duration // 0 keeps samples forever
+	metrics   *MetricRegistry
+	interval  time.Duration
+	now       func() time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewJanitor creates a janitor that keeps samples for the retention window
+// and metric samples as configured in metrics, checking every interval
+func NewJanitor(store DeviceStore, retention time.Duration, metrics *MetricRegistry, interval time.Duration) *Janitor {
+	return &Janitor{
+		store:     store,
+		retention: retention,
+		metrics:   metrics,
+		interval:  interval,
+		now:       time.Now,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start runs the janitor in a background goroutine
+func (j *Janitor) Start() {
+	go j.run()
+}
+
+// Stop stops the janitor and waits for it to exit
+func (j *Janitor) Stop() {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
+
+// RunOnce prunes every sample older than its retention window
+func (j *Janitor) RunOnce() (int, error) {
+	now := j.now()
+	pruned := 0
+	if j.retention > 0 {
+		// Metrics with a retention of their own are pruned below
+		var keepMetrics []string
+		if j.metrics != nil {
+			for _, definition := range j.metrics.Definitions() {
+				if definition.Retention > 0 {
+					keepMetrics = append(keepMetrics, definition.Name)
+				}
+			}
+		}
+		n, err := j.store.Prune(now.Add(-j.retention), keepMetrics)
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n
+	}
+
+	if j.metrics == nil {
+		return pruned, nil
+	}
+	for _, definition := range j.metrics.Definitions() {
+		if definition.Retention <= 0 {
+			continue
+		}
+		n, err := j.store.PruneMetric(definition.Name, now.Add(-definition.Retention))
+		if err != nil {
+			return pruned, err
+		}
+		pruned += n
+	}
+	return pruned, nil
+}
+
+func (j *Janitor) run() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			pruned, err := j.RunOnce()
+			if err != nil {
+				log.Printf("Retention janitor failed: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Retention janitor pruned %d samples", pruned)
+			}
+		}
+	}
+}
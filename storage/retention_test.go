@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cutoff := baseTime.Add(10 * time.Minute)
+
+	dir := t.TempDir()
+	csvPath := writeDevicesCSV(t, dir, "device_id\ndevice-1\ndevice-2\n")
+
+	testCases := []struct {
+		name  string
+		open  func(t *testing.T) DeviceStore
+		check func(t *testing.T) DeviceStore // reopens the store to verify persistence
+	}{
+		{
+			name: "Memory store",
+			open: func(t *testing.T) DeviceStore {
+				return newLoadedMemoryStore(t, "device-1", "device-2")
+			},
+		},
+		{
+			name: "File store survives restart",
+			open: func(t *testing.T) DeviceStore {
+				return openFileStore(t, filepath.Join(dir, "data"), csvPath, FileStoreOptions{})
+			},
+			check: func(t *testing.T) DeviceStore {
+				return openFileStore(t, filepath.Join(dir, "data"), csvPath, FileStoreOptions{})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.open(t)
+
+			// Out of order on purpose
+			_ = store.AddHeartbeat("device-1", baseTime.Add(5*time.Minute))
+			_ = store.AddHeartbeat("device-1", baseTime)
+			_ = store.AddHeartbeat("device-1", baseTime.Add(15*time.Minute))
+			_ = store.AddUploadTime("device-1", baseTime, 100)
+			_ = store.AddUploadTime("device-1", baseTime.Add(20*time.Minute), 300)
+			_ = store.AddHeartbeat("device-2", baseTime.Add(30*time.Minute))
+
+			pruned, err := store.Prune(cutoff, nil)
+			if err != nil {
+				t.Fatalf("Prune failed: %v", err)
+			}
+			if pruned != 3 {
+				t.Errorf("Expected 3 pruned samples, got %d", pruned)
+			}
+
+			// Samples written after pruning are kept as-is
+			_ = store.AddHeartbeat("device-1", baseTime.Add(time.Minute))
+
+			if tc.check != nil {
+				store.Close()
+				store = tc.check(t)
+			}
+			defer store.Close()
+
+			// Lifetime aggregates still cover the pruned samples
+			data, _ := store.GetDeviceData("device-1")
+			expectedLifetime := Aggregates{
+				HeartbeatCount: 4,
+				FirstHeartbeat: baseTime,
+				LastHeartbeat:  baseTime.Add(15 * time.Minute),
+				UploadCount:    2,
+				UploadSum:      400,
+			}
+			if data.Lifetime != expectedLifetime {
+				t.Errorf("Expected lifetime %+v, got %+v", expectedLifetime, data.Lifetime)
+			}
+			if aggregates, _ := store.GetAggregates("device-1"); aggregates != expectedLifetime {
+				t.Errorf("Expected GetAggregates to return %+v, got %+v", expectedLifetime, aggregates)
+			}
+			if len(data.Heartbeats) != 2 || !data.Heartbeats[0].Equal(baseTime.Add(15*time.Minute)) {
+				t.Errorf("Unexpected retained heartbeats %v", data.Heartbeats)
+			}
+			if len(data.Uploads) != 1 || data.Uploads[0].UploadTime != 300 {
+				t.Errorf("Unexpected retained uploads %v", data.Uploads)
+			}
+
+			data, _ = store.GetDeviceData("device-2")
+			if data.Lifetime.HeartbeatCount != 1 || len(data.Heartbeats) != 1 {
+				t.Errorf("Expected device-2 to be untouched, got %+v", data)
+			}
+		})
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	now := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	store := newLoadedMemoryStore(t, "device-1")
+	_ = store.AddHeartbeat("device-1", now.Add(-48*time.Hour))
+	_ = store.AddHeartbeat("device-1", now.Add(-1*time.Hour))
+
+	janitor := NewJanitor(store, 24*time.Hour, nil, time.Millisecond)
+	janitor.now = func() time.Time { return now }
+	janitor.Start()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := store.GetDeviceData("device-1")
+		if data.Lifetime.HeartbeatCount == 2 && len(data.Heartbeats) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Janitor did not prune in time: %+v", data)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	janitor.Stop()
+	janitor.Stop() // idempotent
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Snapshot binary format (all integers are varints unless noted):
+//
+//	magic        [4]byte "FMSS"
+//	version      uint16, big endian
+//	deviceCount  uvarint
+//	per device:
+//	  idLen, id
+//	  heartbeatCount, then per heartbeat: timestamp
+//	  uploadCount, then per upload: timestamp, uploadTimeNanos
+//	  lifetime: heartbeatCount, firstHeartbeat timestamp, lastHeartbeat timestamp,
+//	            uploadCount, uploadSumNanos
+//	  registration: source, model, firmware, site, region, owner,
+//	                tagCount, then per tag: tag,
+//	                attributeCount, then per attribute: key, value
+//	                registeredAt timestamp, retiredAt timestamp
+//	  metricCount, then per metric (sorted by name):
+//	    name, type, unit, pointCount, then per point: timestamp, value
+//	checksum     uint32 CRC-32 (IEEE) of everything above, big endian
+//
+// A timestamp is unixSeconds, nanoseconds, zoneOffsetSeconds. A string is
+// its length followed by its bytes. A value is a float64, 8 bytes big endian.
+const (
+	snapshotMagic   = "FMSS"
+	snapshotVersion = 1
+)
+
+// ErrInvalidSnapshot is returned when a snapshot cannot be decoded
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// ErrSnapshotValueTooLong is returned when a string is too long to be
+// written to a snapshot, as the snapshot could not be read back
+var ErrSnapshotValueTooLong = errors.New("value too long for a snapshot")
+
+// encodeSnapshot writes devices to w in the snapshot binary format
+func encodeSnapshot(w io.Writer, devices map[string]*DeviceData) error {
+	crc := crc32.NewIEEE()
+	buf := bufio.NewWriter(io.MultiWriter(w, crc))
+	enc := snapshotEncoder{w: buf}
+
+	enc.bytes([]byte(snapshotMagic))
+	enc.bytes(binary.BigEndian.AppendUint16(nil, snapshotVersion))
+	enc.uvarint(uint64(len(devices)))
+
+	// Sort devices so identical state always produces identical bytes
+	deviceIDs := make([]string, 0, len(devices))
+	for deviceID := range devices {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+
+	for _, deviceID := range deviceIDs {
+		data := devices[deviceID]
+
+		enc.string(deviceID)
+
+		enc.uvarint(uint64(len(data.Heartbeats)))
+		for _, heartbeat := range data.Heartbeats {
+			enc.timestamp(heartbeat)
+		}
+
+		enc.uvarint(uint64(len(data.Uploads)))
+		for _, upload := range data.Uploads {
+			enc.timestamp(upload.SentAt)
+			enc.varint(upload.UploadTime)
+		}
+
+		enc.uvarint(uint64(data.Lifetime.HeartbeatCount))
+		enc.timestamp(data.Lifetime.FirstHeartbeat)
+		enc.timestamp(data.Lifetime.LastHeartbeat)
+		enc.uvarint(uint64(data.Lifetime.UploadCount))
+		enc.varint(data.Lifetime.UploadSum)
+
+		info := data.Info
+		enc.string(string(info.Source))
+		enc.string(info.Metadata.Model)
+		enc.string(info.Metadata.Firmware)
+		enc.string(info.Metadata.Site)
+		enc.string(info.Metadata.Region)
+		enc.string(info.Metadata.Owner)
+		enc.uvarint(uint64(len(info.Metadata.Tags)))
+		for _, tag := range info.Metadata.Tags {
+			enc.string(tag)
+		}
+		enc.uvarint(uint64(len(info.Metadata.Attributes)))
+		for _, key := range slices.Sorted(maps.Keys(info.Metadata.Attributes)) {
+			enc.string(key)
+			enc.string(info.Metadata.Attributes[key])
+		}
+		enc.timestamp(info.RegisteredAt)
+		enc.timestamp(info.RetiredAt)
+
+		enc.uvarint(uint64(len(data.Metrics)))
+		for _, name := range slices.Sorted(maps.Keys(data.Metrics)) {
+			series := data.Metrics[name]
+			enc.string(name)
+			enc.string(string(series.Type))
+			enc.string(series.Unit)
+			enc.uvarint(uint64(len(series.Points)))
+			for _, point := range series.Points {
+				enc.timestamp(point.Timestamp)
+				enc.bytes(binary.BigEndian.AppendUint64(nil, math.Float64bits(point.Value)))
+			}
+		}
+	}
+
+	if enc.err == nil {
+		enc.err = buf.Flush()
+	}
+	if enc.err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", enc.err)
+	}
+
+	if _, err := w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// decodeSnapshot reads a snapshot written by encodeSnapshot
+func decodeSnapshot(r io.Reader) (map[string]*DeviceData, error) {
+	dec := snapshotDecoder{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+
+	magic := dec.bytes(len(snapshotMagic))
+	if dec.err == nil && string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+
+	version := binary.BigEndian.Uint16(dec.bytes(2))
+	if dec.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+
+	deviceCount := dec.uvarint()
+	devices := make(map[string]*DeviceData)
+	for i := uint64(0); i < deviceCount && dec.err == nil; i++ {
+		deviceID := dec.string()
+
+		heartbeats := make([]time.Time, 0)
+		for n := dec.uvarint(); n > 0 && dec.err == nil; n-- {
+			heartbeats = append(heartbeats, dec.timestamp())
+		}
+
+		uploads := make([]UploadSample, 0)
+		for n := dec.uvarint(); n > 0 && dec.err == nil; n-- {
+			sentAt := dec.timestamp()
+			uploads = append(uploads, UploadSample{SentAt: sentAt, UploadTime: dec.varint()})
+		}
+
+		var lifetime Aggregates
+		lifetime.HeartbeatCount = int64(dec.uvarint())
+		lifetime.FirstHeartbeat = dec.timestamp()
+		lifetime.LastHeartbeat = dec.timestamp()
+		lifetime.UploadCount = int64(dec.uvarint())
+		lifetime.UploadSum = dec.varint()
+
+		info := DeviceInfo{DeviceID: deviceID}
+		info.Source = DeviceSource(dec.string())
+		info.Metadata.Model = dec.string()
+		info.Metadata.Firmware = dec.string()
+		info.Metadata.Site = dec.string()
+		info.Metadata.Region = dec.string()
+		info.Metadata.Owner = dec.string()
+		for n := dec.uvarint(); n > 0 && dec.err == nil; n-- {
+			info.Metadata.Tags = append(info.Metadata.Tags, dec.string())
+		}
+		for n := dec.uvarint(); n > 0 && dec.err == nil; n-- {
+			if info.Metadata.Attributes == nil {
+				info.Metadata.Attributes = make(map[string]string)
+			}
+			key := dec.string()
+			info.Metadata.Attributes[key] = dec.string()
+		}
+		info.RegisteredAt = dec.timestamp()
+		info.RetiredAt = dec.timestamp()
+
+		var metrics map[string]*MetricSeries
+		for n := dec.uvarint(); n > 0 && dec.err == nil; n-- {
+			if metrics == nil {
+				metrics = make(map[string]*MetricSeries)
+			}
+			name := dec.string()
+			series := &MetricSeries{Type: MetricType(dec.string()), Unit: dec.string()}
+			for p := dec.uvarint(); p > 0 && dec.err == nil; p-- {
+				timestamp := dec.timestamp()
+				value := math.Float64frombits(binary.BigEndian.Uint64(dec.bytes(8)))
+				series.Points = append(series.Points, MetricPoint{Timestamp: timestamp, Value: value})
+			}
+			metrics[name] = series
+		}
+
+		devices[deviceID] = &DeviceData{
+			Heartbeats: heartbeats,
+			Uploads:    uploads,
+			Lifetime:   lifetime,
+			Metrics:    metrics,
+			Info:       info,
+		}
+	}
+
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, dec.err)
+	}
+
+	// The checksum itself is not part of the checksummed data
+	expected := dec.crc.Sum32()
+	checksum := binary.BigEndian.Uint32(dec.bytes(4))
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: missing checksum", ErrInvalidSnapshot)
+	}
+	if checksum != expected {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidSnapshot)
+	}
+
+	return devices, nil
+}
+
+// snapshotEncoder writes varints and raw bytes, remembering the first error
+type snapshotEncoder struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *snapshotEncoder) bytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *snapshotEncoder) uvarint(v uint64) {
+	e.bytes(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *snapshotEncoder) varint(v int64) {
+	e.bytes(e.buf[:binary.PutVarint(e.buf[:], v)])
+}
+
+func (e *snapshotEncoder) string(s string) {
+	if len(s) > maxSnapshotStringLength && e.err == nil {
+		e.err = fmt.Errorf("%w: %d bytes, at most %d", ErrSnapshotValueTooLong, len(s), maxSnapshotStringLength)
+	}
+	e.uvarint(uint64(len(s)))
+	e.bytes([]byte(s))
+}
+
+func (e *snapshotEncoder) timestamp(t time.Time) {
+	_, offset := t.Zone()
+	e.varint(t.Unix())
+	e.uvarint(uint64(t.Nanosecond()))
+	e.varint(int64(offset))
+}
+
+// maxSnapshotStringLength bounds string lengths read from a snapshot so
+// corrupt input cannot trigger huge allocations. Longer strings are refused
+// when writing.
+const maxSnapshotStringLength = 1024
+
+// snapshotDecoder reads varints and raw bytes, checksumming everything it
+// consumes and remembering the first error
+type snapshotDecoder struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+// ReadByte implements io.ByteReader for the varint helpers
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (d *snapshotDecoder) bytes(n int) []byte {
+	b := make([]byte, n)
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, b)
+		d.crc.Write(b)
+	}
+	return b
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	var v uint64
+	v, d.err = binary.ReadUvarint(d)
+	return v
+}
+
+func (d *snapshotDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	var v int64
+	v, d.err = binary.ReadVarint(d)
+	return v
+}
+
+func (d *snapshotDecoder) string() string {
+	n := d.uvarint()
+	if n > maxSnapshotStringLength && d.err == nil {
+		d.err = fmt.Errorf("string length %d out of range", n)
+	}
+	if d.err != nil {
+		return ""
+	}
+	return string(d.bytes(int(n)))
+}
+
+func (d *snapshotDecoder) timestamp() time.Time {
+	seconds := d.varint()
+	nanos := int64(d.uvarint())
+	return inZone(time.Unix(seconds, nanos), int(d.varint()))
+}
+
+// inZone converts t to a fixed zone with the given offset, or to UTC so the
+// zero time round-trips as time.Time{}
+func inZone(t time.Time, offset int) time.Time {
+	if offset == 0 {
+		return t.UTC()
+	}
+	return t.In(time.FixedZone("", offset))
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLoadedMemoryStore creates a memory store with the given devices registered
+func newLoadedMemoryStore(t *testing.T, deviceIDs ...string) *MemoryStore {
+	t.Helper()
+	content := "device_id\n"
+	for _, deviceID := range deviceIDs {
+		content += deviceID + "\n"
+	}
+	store := NewMemoryStore()
+	if err := store.LoadDevicesFromCSV(writeDevicesCSV(t, t.TempDir(), content)); err != nil {
+		t.Fatalf("LoadDevicesFromCSV failed: %v", err)
+	}
+	return store
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 12, 0, 0, 0, time.FixedZone("", 7*3600))
+
+	source := newLoadedMemoryStore(t, "device-1", "device-2", "device-3")
+	_ = source.AddHeartbeat("device-1", baseTime)
+	_ = source.AddHeartbeat("device-1", baseTime.Add(time.Minute).UTC())
+	_ = source.AddUploadTime("device-1", baseTime, 1500)
+	_ = source.AddUploadTime("device-2", time.Time{}, 5*time.Second.Nanoseconds())
+
+	var snapshot bytes.Buffer
+	if err := source.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// device-3 is not registered on the target, device-4 is not in the snapshot
+	target := newLoadedMemoryStore(t, "device-1", "device-2", "device-4")
+	_ = target.AddHeartbeat("device-4", baseTime)
+	if err := target.Restore(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	data, _ := target.GetDeviceData("device-1")
+	if len(data.Heartbeats) != 2 || !data.Heartbeats[0].Equal(baseTime) || !data.Heartbeats[1].Equal(baseTime.Add(time.Minute)) {
+		t.Errorf("Unexpected heartbeats %v", data.Heartbeats)
+	}
+	if _, offset := data.Heartbeats[0].Zone(); offset != 7*3600 {
+		t.Errorf("Expected zone offset to be preserved, got %d", offset)
+	}
+	if len(data.Uploads) != 1 || !data.Uploads[0].SentAt.Equal(baseTime) || data.Uploads[0].UploadTime != 1500 {
+		t.Errorf("Unexpected uploads %v", data.Uploads)
+	}
+
+	data, _ = target.GetDeviceData("device-2")
+	if len(data.Uploads) != 1 || data.Uploads[0] != (UploadSample{UploadTime: 5 * time.Second.Nanoseconds()}) {
+		t.Errorf("Expected zero SentAt to round-trip, got %v", data.Uploads)
+	}
+
+	data, _ = target.GetDeviceData("device-4")
+	if len(data.Heartbeats) != 0 {
+		t.Errorf("Expected device-4 data to be cleared, got %v", data.Heartbeats)
+	}
+
+	// Re-encoding the restored state yields identical bytes
+	var again bytes.Buffer
+	restored := newLoadedMemoryStore(t, "device-1", "device-2", "device-3")
+	if err := restored.Restore(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if err := restored.Snapshot(&again); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if !bytes.Equal(snapshot.Bytes(), again.Bytes()) {
+		t.Error("Expected snapshots of identical state to be identical")
+	}
+}
+
+func TestRestoreInvalidSnapshot(t *testing.T) {
+	source := newLoadedMemoryStore(t, "device-1")
+	_ = source.AddHeartbeat("device-1", time.Now())
+
+	var snapshot bytes.Buffer
+	if err := source.Snapshot(&snapshot); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	valid := snapshot.Bytes()
+
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "Bad magic", data: append([]byte("XXXX"), valid[4:]...)},
+		{name: "Unsupported version", data: append(append([]byte(snapshotMagic), 0, 99), valid[6:]...)},
+		{name: "Truncated", data: valid[:len(valid)-5]},
+		{name: "Checksum mismatch", data: append(append([]byte{}, valid[:len(valid)-1]...), valid[len(valid)-1]^0xff)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := newLoadedMemoryStore(t, "device-1")
+			_ = target.AddHeartbeat("device-1", time.Now())
+
+			err := target.Restore(bytes.NewReader(tc.data))
+			if !errors.Is(err, ErrInvalidSnapshot) {
+				t.Fatalf("Expected ErrInvalidSnapshot, got %v", err)
+			}
+
+			// Existing data is left untouched
+			data, _ := target.GetDeviceData("device-1")
+			if len(data.Heartbeats) != 1 {
+				t.Errorf("Expected existing data to be kept, got %d heartbeats", len(data.Heartbeats))
+			}
+		})
+	}
+}
+
+func TestSnapshotStringLength(t *testing.T) {
+	testCases := []struct {
+		name    string
+		length  int
+		wantErr bool
+	}{
+		{name: "At the limit", length: maxSnapshotStringLength},
+		{name: "Past the limit", length: maxSnapshotStringLength + 1, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := strings.Repeat("m", tc.length)
+			source := newLoadedMemoryStore(t, "device-1")
+			sample := MetricSample{Name: name, Type: MetricGauge, Timestamp: time.Now(), Value: 1}
+			if err := source.AddMetrics("device-1", []MetricSample{sample}); err != nil {
+				t.Fatalf("AddMetrics failed: %v", err)
+			}
+
+			var snapshot bytes.Buffer
+			err := source.Snapshot(&snapshot)
+			if tc.wantErr {
+				if !errors.Is(err, ErrSnapshotValueTooLong) {
+					t.Fatalf("Expected ErrSnapshotValueTooLong, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+
+			target := newLoadedMemoryStore(t, "device-1")
+			if err := target.Restore(&snapshot); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			if _, err := target.GetMetric("device-1", name); err != nil {
+				t.Errorf("Expected the metric to be restored, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotConsistentUnderWrites(t *testing.T) {
+	store := newLoadedMemoryStore(t, "device-1", "device-2")
+	baseTime := time.Now()
+
+	// Each writer adds a heartbeat to device-1 then device-2, so any
+	// consistent cut has device-1 ahead of device-2 by at most one
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = store.AddHeartbeat("device-1", baseTime)
+			_ = store.AddHeartbeat("device-2", baseTime)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		var snapshot bytes.Buffer
+		if err := store.Snapshot(&snapshot); err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		devices, err := decodeSnapshot(&snapshot)
+		if err != nil {
+			t.Fatalf("decodeSnapshot failed: %v", err)
+		}
+		diff := len(devices["device-1"].Heartbeats) - len(devices["device-2"].Heartbeats)
+		if diff < 0 || diff > 1 {
+			t.Fatalf("Inconsistent snapshot: device-1=%d device-2=%d",
+				len(devices["device-1"].Heartbeats), len(devices["device-2"].Heartbeats))
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
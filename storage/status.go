@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceStatus is the liveness of a device, derived from the age of its
+// latest heartbeat
+type DeviceStatus string
+
+const (
+	StatusOnline    DeviceStatus = "online"
+	StatusDegraded  DeviceStatus = "degraded"
+	StatusOffline   DeviceStatus = "offline"
+	StatusNeverSeen DeviceStatus = "never_seen"
+)
+
+// ParseDeviceStatus validates a device status name
+func ParseDeviceStatus(value string) (DeviceStatus, error) {
+	switch s := DeviceStatus(value); s {
+	case StatusOnline, StatusDegraded, StatusOffline, StatusNeverSeen:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown device status %q: expected online, degraded, offline or never_seen", value)
+	}
+}
+
+// maxStatusTransitions is how many of a device's latest transitions are kept
+const maxStatusTransitions = 20
+
+// StatusThresholds are the heartbeat ages at which a device is considered
+// degraded and offline
+type StatusThresholds struct {
+	Degraded time.Duration
+	Offline  time.Duration
+}
+
+// DefaultStatusThresholds suit devices sending a heartbeat every minute
+var DefaultStatusThresholds = StatusThresholds{Degraded: 2 * time.Minute, Offline: 10 * time.Minute}
+
+// Validate checks that both thresholds are positive and in order
+func (t StatusThresholds) Validate() error {
+	if t.Degraded <= 0 || t.Offline <= 0 {
+		return fmt.Errorf("status thresholds must be positive")
+	}
+	if t.Offline <= t.Degraded {
+		return fmt.Errorf("offline threshold %s must be longer than degraded threshold %s", t.Offline, t.Degraded)
+	}
+	return nil
+}
+
+// Classify derives a device's status from its aggregates at now
+func (t StatusThresholds) Classify(aggregates Aggregates, now time.Time) DeviceStatus {
+	if aggregates.HeartbeatCount == 0 {
+		return StatusNeverSeen
+	}
+	switch age := now.Sub(aggregates.LastHeartbeat); {
+	case age >= t.Offline:
+		return StatusOffline
+	case age >= t.Degraded:
+		return StatusDegraded
+	default:
+		return StatusOnline
+	}
+}
+
+// StatusTransition records a change of a device's status
+type StatusTransition struct {
+	From DeviceStatus
+	To   DeviceStatus
+	At   time.Time
+}
+
+// StatusChange is a transition of a specific device
+type StatusChange struct {
+	DeviceID string
+	StatusTransition
+}
+
+// DeviceStatusInfo is the current status of a device and how it got there
+type DeviceStatusInfo struct {
+	Status        DeviceStatus
+	Since         time.Time // when the status was first observed, zero if not evaluated yet
+	LastHeartbeat time.Time // zero if the device was never seen
+	Connected     bool      // the device holds a persistent connection
+	Transitions   []StatusTransition
+}
+
+// StatusTracker periodically evaluates the status of every active device
+// and records transitions between statuses. A device holding a persistent
+// connection is online whatever its heartbeats say. Statuses and
+// transitions are kept in memory only.
+type StatusTracker struct {
+	store      DeviceStore
+	thresholds StatusThresholds
+	interval   time.Duration
+	now        func() time.Time
+
+	mu          sync.Mutex // guards statuses and connections
+	statuses    map[string]*DeviceStatusInfo
+	connections map[string]int // open persistent connections per device
+
+	onChange func(StatusChange) // called for transitions found in the background
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewStatusTracker creates a tracker that evaluates statuses every interval
+func NewStatusTracker(store DeviceStore, thresholds StatusThresholds, interval time.Duration) *StatusTracker {
+	return &StatusTracker{
+		store:       store,
+		thresholds:  thresholds,
+		interval:    interval,
+		now:         time.Now,
+		statuses:    make(map[string]*DeviceStatusInfo),
+		connections: make(map[string]int),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start evaluates every device once, then keeps evaluating them in a
+// background goroutine
+func (t *StatusTracker) Start() {
+	t.RunOnce()
+	go t.run()
+}
+
+// OnChange sets a function to call with each transition found by the
+// background evaluations. It must be set before Start.
+func (t *StatusTracker) OnChange(fn func(StatusChange)) {
+	t.onChange = fn
+}
+
+// Stop stops the tracker and waits for it to exit
+func (t *StatusTracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+	<-t.done
+}
+
+// RunOnce evaluates the status of every active device and returns the
+// transitions since the previous evaluation, sorted by device ID. The first
+// evaluation of a device sets its status without recording a transition.
+func (t *StatusTracker) RunOnce() []StatusChange {
+	now := t.now()
+
+	evaluated := make(map[string]Aggregates)
+	for _, info := range t.store.ListDevices() {
+		if info.Retired() {
+			continue
+		}
+		aggregates, err := t.store.GetAggregates(info.DeviceID)
+		if err != nil {
+			continue // deregistered in the meantime
+		}
+		evaluated[info.DeviceID] = aggregates
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var changes []StatusChange
+	for deviceID, aggregates := range evaluated {
+		connected := t.connections[deviceID] > 0
+		status := t.classify(aggregates, connected, now)
+		current, tracked := t.statuses[deviceID]
+		if !tracked {
+			t.statuses[deviceID] = &DeviceStatusInfo{Status: status, Since: now, LastHeartbeat: aggregates.LastHeartbeat, Connected: connected}
+			continue
+		}
+
+		current.LastHeartbeat = aggregates.LastHeartbeat
+		current.Connected = connected
+		if current.Status == status {
+			continue
+		}
+		transition := StatusTransition{From: current.Status, To: status, At: now}
+		current.Status = status
+		current.Since = now
+		current.Transitions = append(current.Transitions, transition)
+		if excess := len(current.Transitions) - maxStatusTransitions; excess > 0 {
+			current.Transitions = append([]StatusTransition(nil), current.Transitions[excess:]...)
+		}
+		changes = append(changes, StatusChange{DeviceID: deviceID, StatusTransition: transition})
+	}
+
+	// Forget devices that were deregistered or retired
+	for deviceID := range t.statuses {
+		if _, exists := evaluated[deviceID]; !exists {
+			delete(t.statuses, deviceID)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].DeviceID < changes[j].DeviceID
+	})
+	return changes
+}
+
+// Status returns the status of an active device. Devices registered since
+// the last evaluation are classified on the spot, with a zero Since.
+func (t *StatusTracker) Status(deviceID string) (DeviceStatusInfo, error) {
+	t.mu.Lock()
+	if current, tracked := t.statuses[deviceID]; tracked {
+		info := *current
+		info.Transitions = append([]StatusTransition(nil), current.Transitions...)
+		t.mu.Unlock()
+		return info, nil
+	}
+	connected := t.connections[deviceID] > 0
+	t.mu.Unlock()
+
+	aggregates, err := t.store.GetAggregates(deviceID)
+	if err != nil {
+		return DeviceStatusInfo{}, err
+	}
+	return DeviceStatusInfo{
+		Status:        t.classify(aggregates, connected, t.now()),
+		LastHeartbeat: aggregates.LastHeartbeat,
+		Connected:     connected,
+	}, nil
+}
+
+// Connect records that a device opened a persistent connection. The device
+// counts as online until every connection it opened is disconnected.
+func (t *StatusTracker) Connect(deviceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.connections[deviceID]++
+}
+
+// Disconnect records that a device closed a persistent connection
+func (t *StatusTracker) Disconnect(deviceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.connections[deviceID] <= 1 {
+		delete(t.connections, deviceID)
+		return
+	}
+	t.connections[deviceID]--
+}
+
+// classify derives a device's status, treating connected devices as
+// online
+func (t *StatusTracker) classify(aggregates Aggregates, connected bool, now time.Time) DeviceStatus {
+	if connected {
+		return StatusOnline
+	}
+	return t.thresholds.Classify(aggregates, now)
+}
+
+func (t *StatusTracker) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			for _, change := range t.RunOnce() {
+				log.Printf("Device %s is now %s (was %s)", change.DeviceID, change.To, change.From)
+				if t.onChange != nil {
+					t.onChange(change)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusThresholdsClassify(t *testing.T) {
+	now := time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC)
+	thresholds := StatusThresholds{Degraded: 2 * time.Minute, Offline: 10 * time.Minute}
+
+	testCases := []struct {
+		name       string
+		aggregates Aggregates
+		expected   DeviceStatus
+	}{
+		{name: "Never seen", expected: StatusNeverSeen},
+		{name: "Recent heartbeat", aggregates: Aggregates{HeartbeatCount: 1, LastHeartbeat: now.Add(-time.Minute)}, expected: StatusOnline},
+		{name: "Degraded at the threshold", aggregates: Aggregates{HeartbeatCount: 1, LastHeartbeat: now.Add(-2 * time.Minute)}, expected: StatusDegraded},
+		{name: "Offline at the threshold", aggregates: Aggregates{HeartbeatCount: 1, LastHeartbeat: now.Add(-10 * time.Minute)}, expected: StatusOffline},
+		{name: "Heartbeat from the future", aggregates: Aggregates{HeartbeatCount: 1, LastHeartbeat: now.Add(time.Hour)}, expected: StatusOnline},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status := thresholds.Classify(tc.aggregates, now); status != tc.expected {
+				t.Errorf("Expected %s, got %s", tc.expected, status)
+			}
+		})
+	}
+
+	if err := (StatusThresholds{Degraded: time.Minute, Offline: time.Minute}).Validate(); err == nil {
+		t.Error("Expected thresholds out of order to be rejected")
+	}
+}
+
+func TestStatusTracker(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := baseTime
+
+	store := NewMemoryStore()
+	for _, deviceID := range []string{"device-1", "device-2"} {
+		if err := store.RegisterDevice(DeviceInfo{DeviceID: deviceID, Source: SourceAPI}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	tracker := NewStatusTracker(store, StatusThresholds{Degraded: 2 * time.Minute, Offline: 10 * time.Minute}, time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	// The first evaluation sets statuses without transitions
+	store.AddHeartbeat("device-1", baseTime)
+	if changes := tracker.RunOnce(); len(changes) != 0 {
+		t.Errorf("Expected no transitions, got %+v", changes)
+	}
+
+	steps := []struct {
+		name     string
+		advance  time.Duration
+		action   func()
+		expected []StatusChange
+	}{
+		{
+			name:    "Degraded",
+			advance: 3 * time.Minute,
+			action:  func() { store.AddHeartbeat("device-2", baseTime.Add(3*time.Minute)) },
+			expected: []StatusChange{
+				{DeviceID: "device-1", StatusTransition: StatusTransition{From: StatusOnline, To: StatusDegraded, At: baseTime.Add(3 * time.Minute)}},
+				{DeviceID: "device-2", StatusTransition: StatusTransition{From: StatusNeverSeen, To: StatusOnline, At: baseTime.Add(3 * time.Minute)}},
+			},
+		},
+		{
+			name:    "Offline",
+			advance: 7 * time.Minute,
+			action:  func() { store.AddHeartbeat("device-2", baseTime.Add(10*time.Minute)) },
+			expected: []StatusChange{
+				{DeviceID: "device-1", StatusTransition: StatusTransition{From: StatusDegraded, To: StatusOffline, At: baseTime.Add(10 * time.Minute)}},
+			},
+		},
+		{
+			name:    "Back online",
+			advance: time.Minute,
+			action:  func() { store.AddHeartbeat("device-1", baseTime.Add(11*time.Minute)) },
+			expected: []StatusChange{
+				{DeviceID: "device-1", StatusTransition: StatusTransition{From: StatusOffline, To: StatusOnline, At: baseTime.Add(11 * time.Minute)}},
+			},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			now = now.Add(step.advance)
+			step.action()
+
+			changes := tracker.RunOnce()
+			if !reflect.DeepEqual(changes, step.expected) {
+				t.Errorf("Expected %+v, got %+v", step.expected, changes)
+			}
+		})
+	}
+
+	status, err := tracker.Status("device-1")
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.Status != StatusOnline || !status.Since.Equal(baseTime.Add(11*time.Minute)) ||
+		!status.LastHeartbeat.Equal(baseTime.Add(11*time.Minute)) || len(status.Transitions) != 3 {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	// Deregistered devices are forgotten
+	if err := store.DeregisterDevice("device-2", false); err != nil {
+		t.Fatalf("DeregisterDevice failed: %v", err)
+	}
+	tracker.RunOnce()
+	if _, err := tracker.Status("device-2"); err == nil {
+		t.Error("Expected no status for a deregistered device")
+	}
+}
+
+func TestStatusTrackerConnections(t *testing.T) {
+	baseTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := baseTime
+
+	store := NewMemoryStore()
+	if err := store.RegisterDevice(DeviceInfo{DeviceID: "device-1", Source: SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	tracker := NewStatusTracker(store, StatusThresholds{Degraded: 2 * time.Minute, Offline: 10 * time.Minute}, time.Minute)
+	tracker.now = func() time.Time { return now }
+	store.AddHeartbeat("device-1", baseTime.Add(-time.Hour))
+	tracker.RunOnce()
+
+	steps := []struct {
+		name     string
+		action   func()
+		expected DeviceStatus
+	}{
+		{name: "Connected", action: func() { tracker.Connect("device-1") }, expected: StatusOnline},
+		{name: "Second connection", action: func() { tracker.Connect("device-1") }, expected: StatusOnline},
+		{name: "One left", action: func() { tracker.Disconnect("device-1") }, expected: StatusOnline},
+		{name: "Disconnected", action: func() { tracker.Disconnect("device-1") }, expected: StatusOffline},
+	}
+	for _, step := range steps {
+		step.action()
+		now = now.Add(time.Minute)
+		tracker.RunOnce()
+		status, err := tracker.Status("device-1")
+		if err != nil {
+			t.Fatalf("%s: Status failed: %v", step.name, err)
+		}
+		if status.Status != step.expected || status.Connected != (step.expected == StatusOnline) {
+			t.Errorf("%s: expected %s, got %+v", step.name, step.expected, status)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	walSegmentPrefix = "wal-"
+	walSegmentSuffix = ".log"
+
+	defaultSegmentSize  = 64 << 20 // 64 MiB
+	defaultSyncInterval = time.Second
+)
+
+var errWALClosed = errors.New("write-ahead log is closed")
+
+// SyncPolicy controls when the write-ahead log is fsynced to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs before every append returns
+	SyncAlways SyncPolicy = "always"
+	// SyncBatch lets concurrent appends share a single fsync (group commit);
+	// an append still does not return until its record is on disk
+	SyncBatch SyncPolicy = "batch"
+	// SyncInterval fsyncs in the background on a fixed interval; appends
+	// return immediately and up to one interval of records may be lost on crash
+	SyncInterval SyncPolicy = "interval"
+)
+
+// ParseSyncPolicy converts a policy name into a SyncPolicy
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch policy := SyncPolicy(strings.ToLower(name)); policy {
+	case SyncAlways, SyncBatch, SyncInterval:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown sync policy %q", name)
+	}
+}
+
+// WALOptions configures a write-ahead log
+type WALOptions struct {
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration // only used by SyncInterval
+	SegmentSize  int64         // rotate to a new segment once this size is reached
+}
+
+// DefaultWALOptions returns the options used when none are given
+func DefaultWALOptions() WALOptions {
+	return WALOptions{
+		SyncPolicy:   SyncAlways,
+		SyncInterval: defaultSyncInterval,
+		SegmentSize:  defaultSegmentSize,
+	}
+}
+
+// WAL is a segmented, append-only write-ahead log. Each record is a single
+// newline-terminated line. A new segment is started every time the log is
+// opened, so a torn record can only ever be the last line of a segment.
+type WAL struct {
+	dir  string
+	opts WALOptions
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signalled when an in-flight fsync completes
+	file    *os.File
+	index   uint64 // index of the active segment
+	size    int64  // bytes written to the active segment
+	written uint64 // records appended
+	synced  uint64 // records known to be on disk
+	syncing bool   // an fsync is in flight without holding mu
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenWAL opens the write-ahead log in dir and starts a fresh segment
+func OpenWAL(dir string, opts WALOptions) (*WAL, error) {
+	defaults := DefaultWALOptions()
+	if opts.SyncPolicy == "" {
+		opts.SyncPolicy = defaults.SyncPolicy
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = defaults.SyncInterval
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaults.SegmentSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &WAL{
+		dir:  dir,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	next := uint64(1)
+	if len(segments) > 0 {
+		next = segments[len(segments)-1] + 1
+	}
+	if err := w.openSegment(next); err != nil {
+		return nil, err
+	}
+
+	if opts.SyncPolicy == SyncInterval {
+		go w.syncLoop()
+	} else {
+		close(w.done)
+	}
+
+	return w, nil
+}
+
+// Append writes a record to the log, syncing it according to the policy
+func (w *WAL) Append(record []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWALClosed
+	}
+
+	if w.size >= w.opts.SegmentSize {
+		if _, err := w.rotateLocked(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(record)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	w.written++
+
+	switch w.opts.SyncPolicy {
+	case SyncAlways:
+		return w.syncLocked()
+	case SyncBatch:
+		return w.waitSyncedLocked(w.written)
+	default:
+		return nil
+	}
+}
+
+// Rotate closes the active segment and starts a new one. It returns the
+// index of the new segment; every record appended before the call lives in
+// a segment with a lower index.
+func (w *WAL) Rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, errWALClosed
+	}
+	return w.rotateLocked()
+}
+
+// SegmentIndex returns the index of the active segment
+func (w *WAL) SegmentIndex() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.index
+}
+
+// Replay calls fn for every record in segments with an index >= from, in
+// order. A torn or corrupt line ends the replay of its segment.
+func (w *WAL) Replay(from uint64, fn func(record []byte) error) error {
+	segments, err := listSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range segments {
+		if index < from {
+			continue
+		}
+		if err := replaySegment(w.segmentPath(index), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveSegmentsBefore deletes every closed segment with an index < index
+func (w *WAL) RemoveSegmentsBefore(index uint64) error {
+	segments, err := listSegments(w.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment >= index || segment == w.SegmentIndex() {
+			continue
+		}
+		if err := os.Remove(w.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove WAL segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close syncs and closes the active segment
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.stop)
+	w.mu.Unlock()
+
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.syncLocked()
+	if closeErr := w.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close WAL: %w", closeErr)
+	}
+	return err
+}
+
+// syncLoop fsyncs the log periodically for the interval policy
+func (w *WAL) syncLoop() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.synced < w.written {
+				_ = w.syncLocked()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// syncLocked fsyncs the active segment while holding mu
+func (w *WAL) syncLocked() error {
+	for w.syncing {
+		w.cond.Wait()
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	w.synced = w.written
+	return nil
+}
+
+// waitSyncedLocked blocks until record seq is on disk. The first waiter
+// becomes the leader and fsyncs on behalf of everyone who appended before
+// it; mu is released during the fsync so more records can queue up.
+func (w *WAL) waitSyncedLocked(seq uint64) error {
+	for w.synced < seq {
+		if w.syncing {
+			w.cond.Wait()
+			continue
+		}
+
+		w.syncing = true
+		target := w.written
+		file := w.file
+
+		w.mu.Unlock()
+		err := file.Sync()
+		w.mu.Lock()
+
+		w.syncing = false
+		if err == nil && target > w.synced {
+			w.synced = target
+		}
+		w.cond.Broadcast()
+
+		if err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotateLocked syncs and closes the active segment and opens the next one
+func (w *WAL) rotateLocked() (uint64, error) {
+	if err := w.syncLocked(); err != nil {
+		return 0, err
+	}
+	if err := w.file.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close WAL segment: %w", err)
+	}
+	if err := w.openSegment(w.index + 1); err != nil {
+		return 0, err
+	}
+	return w.index, nil
+}
+
+// openSegment creates a new segment file and makes it the active one
+func (w *WAL) openSegment(index uint64) error {
+	file, err := os.OpenFile(w.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	if err := syncDir(w.dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	w.file = file
+	w.index = index
+	w.size = 0
+	return nil
+}
+
+func (w *WAL) segmentPath(index uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%s%016d%s", walSegmentPrefix, index, walSegmentSuffix))
+}
+
+// listSegments returns the indexes of all WAL segments in dir, sorted
+func listSegments(dir string) ([]uint64, error) {
+	return listIndexedFiles(dir, walSegmentPrefix, walSegmentSuffix)
+}
+
+// listIndexedFiles returns the sorted indexes of files named prefix<index>suffix
+func listIndexedFiles(dir, prefix, suffix string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data directory: %w", err)
+	}
+
+	var indexes []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		var index uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), "%d", &index); err != nil {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes, nil
+}
+
+// replaySegment calls fn for each complete record in a segment file
+func replaySegment(path string, fn func(record []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything left is a partial record from an interrupted write
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read WAL segment: %w", err)
+		}
+		if err := fn(line); err != nil {
+			if errors.Is(err, errCorruptRecord) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// syncDir fsyncs a directory so that created, renamed or removed entries
+// are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+
+	// Best effort: some platforms do not support syncing directories
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,96 @@
+package udp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Datagram layout, big-endian:
+//
+//	version     1 byte, packetVersion
+//	flags       1 byte, flagHMAC if a MAC follows the timestamp
+//	id length   1 byte, 1 to 255
+//	device ID   id length bytes
+//	sent at     8 bytes, Unix milliseconds
+//	MAC         32 bytes, HMAC-SHA256 of everything before it (optional)
+const (
+	packetVersion byte = 1
+	flagHMAC      byte = 0x01
+
+	headerSize    = 3
+	timestampSize = 8
+	macSize       = sha256.Size
+	maxPacketSize = headerSize + 255 + timestampSize + macSize
+)
+
+var errMalformed = errors.New("malformed packet")
+
+// heartbeat is a decoded heartbeat datagram
+type heartbeat struct {
+	deviceID string
+	sentAt   time.Time
+	signed   []byte // bytes covered by the MAC
+	mac      []byte // nil if unsigned
+}
+
+// parseHeartbeat decodes a heartbeat datagram
+func parseHeartbeat(packet []byte) (heartbeat, error) {
+	if len(packet) < headerSize || packet[0] != packetVersion || packet[1]&^flagHMAC != 0 {
+		return heartbeat{}, errMalformed
+	}
+	idLength := int(packet[2])
+	size := headerSize + idLength + timestampSize
+	if packet[1]&flagHMAC != 0 {
+		size += macSize
+	}
+	if idLength == 0 || len(packet) != size {
+		return heartbeat{}, errMalformed
+	}
+
+	end := headerSize + idLength
+	hb := heartbeat{
+		deviceID: string(packet[headerSize:end]),
+		sentAt:   time.UnixMilli(int64(binary.BigEndian.Uint64(packet[end:]))).UTC(),
+		signed:   packet[:end+timestampSize],
+	}
+	if packet[1]&flagHMAC != 0 {
+		hb.mac = packet[end+timestampSize:]
+	}
+	return hb, nil
+}
+
+// verify reports whether the heartbeat carries a valid MAC for key
+func (hb heartbeat) verify(key []byte) bool {
+	return hb.mac != nil && hmac.Equal(hb.mac, sign(key, hb.signed))
+}
+
+// sign computes the MAC of data
+func sign(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// EncodeHeartbeat builds a heartbeat datagram, signed with key unless it is
+// empty
+func EncodeHeartbeat(deviceID string, sentAt time.Time, key []byte) ([]byte, error) {
+	if len(deviceID) == 0 || len(deviceID) > 255 {
+		return nil, fmt.Errorf("device ID must be 1 to 255 bytes, got %d", len(deviceID))
+	}
+	var flags byte
+	if len(key) > 0 {
+		flags |= flagHMAC
+	}
+	packet := make([]byte, 0, maxPacketSize)
+	packet = append(packet, packetVersion, flags, byte(len(deviceID)))
+	packet = append(packet, deviceID...)
+	packet = binary.BigEndian.AppendUint64(packet, uint64(sentAt.UnixMilli()))
+	if len(key) > 0 {
+		packet = append(packet, sign(key, packet)...)
+	}
+	return packet, nil
+}
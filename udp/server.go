@@ -0,0 +1,285 @@
+// Package udp is a listener for compact binary heartbeat datagrams, for
+// constrained devices that can afford a single packet per report but not
+// HTTP. See packet.go for the datagram layout.
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// Default rate limit per device
+const (
+	DefaultRate  = 1.0 // heartbeats per second
+	DefaultBurst = 5
+)
+
+// DefaultMaxSkew is how far the send time of a datagram may be from the
+// server clock by default
+const DefaultMaxSkew = 5 * time.Minute
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("udp: server closed")
+
+// Options configure a server
+type Options struct {
+	// Credentials, if set, holds the device keys: datagrams of a device
+	// with keys must be signed with one of them, and datagrams of a device
+	// without keys are rejected if signatures are required
+	Credentials *storage.Credentials
+	// Secret, if set, is the HMAC key datagrams of devices without keys
+	// must be signed with. Without it, they are accepted signed or not.
+	Secret []byte
+	// Rate and Burst limit the heartbeats accepted per device, as a token
+	// bucket refilled Rate times a second holding up to Burst tokens
+	Rate  float64
+	Burst int
+	// MaxSkew is how far the send time of a datagram may be from the
+	// server clock. Signed datagrams are remembered for as long, so a
+	// replayed one is dropped.
+	MaxSkew time.Duration
+}
+
+// Stats counts the datagrams received by the server
+type Stats struct {
+	Accepted        int64
+	Malformed       int64
+	Unauthenticated int64 // missing or invalid MAC
+	Stale           int64 // sent at a time outside the allowed skew
+	Replayed        int64 // signed datagrams already accepted
+	RateLimited     int64
+	Rejected        int64 // unknown devices or failures to store
+}
+
+// Server receives heartbeat datagrams and stores them
+type Server struct {
+	store   storage.DeviceStore
+	options Options
+	now     func() time.Time
+
+	accepted        atomic.Int64
+	malformed       atomic.Int64
+	unauthenticated atomic.Int64
+	stale           atomic.Int64
+	replayed        atomic.Int64
+	rateLimited     atomic.Int64
+	rejected        atomic.Int64
+
+	mu     sync.Mutex // guards conn and closed
+	conn   net.PacketConn
+	closed bool
+
+	// Only used by the Serve goroutine
+	buckets   map[string]*bucket
+	seen      map[[macSize]byte]time.Time // MACs of accepted datagrams, until they go stale
+	lastPrune time.Time
+}
+
+// bucket is the token bucket of a device
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewServer creates a server that writes into store. Unset rate limits and
+// skew take their defaults.
+func NewServer(store storage.DeviceStore, options Options) *Server {
+	if options.Rate <= 0 {
+		options.Rate = DefaultRate
+	}
+	if options.Burst <= 0 {
+		options.Burst = DefaultBurst
+	}
+	if options.MaxSkew <= 0 {
+		options.MaxSkew = DefaultMaxSkew
+	}
+	return &Server{
+		store:   store,
+		options: options,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+		seen:    make(map[[macSize]byte]time.Time),
+	}
+}
+
+// Serve reads datagrams from conn until Close is called
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.conn = conn
+	s.mu.Unlock()
+
+	// One byte more than the largest datagram, to detect oversized ones
+	buf := make([]byte, maxPacketSize+1)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.handle(buf[:n])
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// Stats returns how many datagrams were accepted and why others were not
+func (s *Server) Stats() Stats {
+	return Stats{
+		Accepted:        s.accepted.Load(),
+		Malformed:       s.malformed.Load(),
+		Unauthenticated: s.unauthenticated.Load(),
+		Stale:           s.stale.Load(),
+		Replayed:        s.replayed.Load(),
+		RateLimited:     s.rateLimited.Load(),
+		Rejected:        s.rejected.Load(),
+	}
+}
+
+// Counters returns the stats by outcome, for /metrics
+func (s *Server) Counters() map[string]int64 {
+	stats := s.Stats()
+	return map[string]int64{
+		"accepted":        stats.Accepted,
+		"malformed":       stats.Malformed,
+		"unauthenticated": stats.Unauthenticated,
+		"stale":           stats.Stale,
+		"replayed":        stats.Replayed,
+		"rate_limited":    stats.RateLimited,
+		"rejected":        stats.Rejected,
+	}
+}
+
+// handle checks and stores a datagram. There is no reply, so rejections
+// are only counted; logging them would let a flood of bad packets flood
+// the logs too.
+func (s *Server) handle(packet []byte) {
+	hb, err := parseHeartbeat(packet)
+	if err != nil {
+		s.malformed.Add(1)
+		return
+	}
+	authenticated, ok := s.authenticate(hb)
+	if !ok {
+		s.unauthenticated.Add(1)
+		return
+	}
+	now := s.now()
+	s.prune(now)
+	if hb.sentAt.Before(now.Add(-s.options.MaxSkew)) || hb.sentAt.After(now.Add(s.options.MaxSkew)) {
+		s.stale.Add(1)
+		return
+	}
+	// Only known devices get a bucket, so spoofed IDs cannot grow the map
+	if !s.store.DeviceExists(hb.deviceID) {
+		s.rejected.Add(1)
+		return
+	}
+	// Replays of verified datagrams are dropped before they can use up the
+	// device's tokens, and only datagrams within the rate limit are
+	// remembered
+	var mac [macSize]byte
+	if authenticated {
+		mac = [macSize]byte(hb.mac)
+		if _, replayed := s.seen[mac]; replayed {
+			s.replayed.Add(1)
+			return
+		}
+	}
+	if !s.allow(hb.deviceID, now) {
+		s.rateLimited.Add(1)
+		return
+	}
+	if authenticated {
+		s.seen[mac] = hb.sentAt.Add(s.options.MaxSkew)
+	}
+	if err := s.store.AddHeartbeat(hb.deviceID, hb.sentAt); err != nil {
+		s.rejected.Add(1)
+		return
+	}
+	s.accepted.Add(1)
+}
+
+// authenticate checks the MAC of a datagram against the keys of its
+// device, or the shared secret if the device was never given keys. It
+// reports whether the MAC was verified and whether the datagram is accepted.
+func (s *Server) authenticate(hb heartbeat) (verified, ok bool) {
+	if credentials := s.options.Credentials; credentials != nil {
+		if keys := credentials.Keys(hb.deviceID); len(keys) > 0 {
+			for _, key := range keys {
+				if hb.verify([]byte(key.Secret)) {
+					return true, true
+				}
+			}
+			return false, false
+		}
+		if credentials.SignatureRequired(hb.deviceID) {
+			return false, false
+		}
+	}
+	if len(s.options.Secret) == 0 {
+		return false, true
+	}
+	verified = hb.verify(s.options.Secret)
+	return verified, verified
+}
+
+// allow takes a token from the bucket of a device if it has one
+func (s *Server) allow(deviceID string, now time.Time) bool {
+	b, exists := s.buckets[deviceID]
+	if !exists {
+		b = &bucket{tokens: float64(s.options.Burst), last: now}
+		s.buckets[deviceID] = b
+	}
+	b.tokens = min(float64(s.options.Burst), b.tokens+now.Sub(b.last).Seconds()*s.options.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune drops, at most once a minute, the buckets that have refilled, as a
+// new bucket would be the same, and the MACs of datagrams that are stale
+// anyway
+func (s *Server) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	full := time.Duration(float64(s.options.Burst) / s.options.Rate * float64(time.Second))
+	for deviceID, b := range s.buckets {
+		if now.Sub(b.last) >= full {
+			delete(s.buckets, deviceID)
+		}
+	}
+	for mac, expiresAt := range s.seen {
+		if now.After(expiresAt) {
+			delete(s.seen, mac)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vdnguyen58/fleet-monitor/storage"
+)
+
+// mustEncode builds a heartbeat datagram
+func mustEncode(t *testing.T, deviceID string, sentAt time.Time, key []byte) []byte {
+	t.Helper()
+	packet, err := EncodeHeartbeat(deviceID, sentAt, key)
+	if err != nil {
+		t.Fatalf("EncodeHeartbeat failed: %v", err)
+	}
+	return packet
+}
+
+func TestServerHandle(t *testing.T) {
+	secret := []byte("secret")
+	sentAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	signed := mustEncode(t, "device-1", sentAt, secret)
+	tampered := append([]byte{}, signed...)
+	tampered[len("device-1")+headerSize]++ // sent at
+
+	keyed := storage.NewCredentials()
+	key, err := keyed.Rotate("device-1", 0)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	required := storage.NewCredentials()
+	required.RequireSignatures(true)
+
+	testCases := []struct {
+		name        string
+		credentials *storage.Credentials
+		secret      []byte
+		packet      []byte
+		expected    Stats
+	}{
+		{name: "Unsigned", packet: mustEncode(t, "device-1", sentAt, nil), expected: Stats{Accepted: 1}},
+		{name: "Signed without a secret", packet: mustEncode(t, "device-1", sentAt, []byte("other")), expected: Stats{Accepted: 1}},
+		{name: "Signed", secret: secret, packet: signed, expected: Stats{Accepted: 1}},
+		{name: "Unsigned with a secret", secret: secret, packet: mustEncode(t, "device-1", sentAt, nil), expected: Stats{Unauthenticated: 1}},
+		{name: "Wrong key", secret: secret, packet: mustEncode(t, "device-1", sentAt, []byte("other")), expected: Stats{Unauthenticated: 1}},
+		{name: "Tampered", secret: secret, packet: tampered, expected: Stats{Unauthenticated: 1}},
+		{name: "Truncated MAC", secret: secret, packet: signed[:len(signed)-1], expected: Stats{Malformed: 1}},
+		{name: "Signed with a device key", credentials: keyed, secret: secret, packet: mustEncode(t, "device-1", sentAt, []byte(key.Secret)), expected: Stats{Accepted: 1}},
+		{name: "Shared secret for a device with keys", credentials: keyed, secret: secret, packet: signed, expected: Stats{Unauthenticated: 1}},
+		{name: "Unsigned for a device with keys", credentials: keyed, packet: mustEncode(t, "device-1", sentAt, nil), expected: Stats{Unauthenticated: 1}},
+		{name: "Device without keys", credentials: storage.NewCredentials(), packet: mustEncode(t, "device-1", sentAt, nil), expected: Stats{Accepted: 1}},
+		{name: "Device without keys when signatures are required", credentials: required, secret: secret, packet: signed, expected: Stats{Unauthenticated: 1}},
+		{name: "Unknown device", packet: mustEncode(t, "unknown", sentAt, nil), expected: Stats{Rejected: 1}},
+		{name: "Empty", packet: []byte{}, expected: Stats{Malformed: 1}},
+		{name: "Unknown version", packet: append([]byte{2}, signed[1:]...), expected: Stats{Malformed: 1}},
+		{name: "Unknown flags", packet: append([]byte{1, 0x03}, signed[2:]...), expected: Stats{Malformed: 1}},
+		{name: "Empty device ID", packet: []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, expected: Stats{Malformed: 1}},
+		{name: "Trailing bytes", packet: append(mustEncode(t, "device-1", sentAt, nil), 0), expected: Stats{Malformed: 1}},
+		{name: "Clock behind within the skew", packet: mustEncode(t, "device-1", sentAt.Add(-time.Minute), nil), expected: Stats{Accepted: 1}},
+		{name: "Too old", packet: mustEncode(t, "device-1", sentAt.Add(-6*time.Minute), nil), expected: Stats{Stale: 1}},
+		{name: "Too far ahead", packet: mustEncode(t, "device-1", sentAt.Add(6*time.Minute), nil), expected: Stats{Stale: 1}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := storage.NewMemoryStore()
+			if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+				t.Fatalf("RegisterDevice failed: %v", err)
+			}
+			server := NewServer(store, Options{Credentials: tc.credentials, Secret: tc.secret})
+			server.now = func() time.Time { return sentAt }
+			server.handle(tc.packet)
+
+			if stats := server.Stats(); stats != tc.expected {
+				t.Errorf("Expected %+v, got %+v", tc.expected, stats)
+			}
+			aggregates, _ := store.GetAggregates("device-1")
+			if accepted := aggregates.HeartbeatCount; accepted != tc.expected.Accepted {
+				t.Errorf("Expected %d stored heartbeats, got %d", tc.expected.Accepted, accepted)
+			} else if accepted == 1 && aggregates.LastHeartbeat.IsZero() {
+				t.Error("Expected a stored heartbeat")
+			}
+		})
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	server := NewServer(store, Options{Rate: 0.5, Burst: 2})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	packet := mustEncode(t, "device-1", now, nil)
+
+	// The burst is accepted, then one heartbeat every two seconds
+	for range 3 {
+		server.handle(packet)
+	}
+	now = now.Add(time.Second)
+	server.handle(packet)
+	now = now.Add(time.Second)
+	server.handle(packet)
+
+	if stats := server.Stats(); stats != (Stats{Accepted: 3, RateLimited: 2}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if counters := server.Counters(); counters["accepted"] != 3 || counters["rate_limited"] != 2 || len(counters) != 7 {
+		t.Errorf("Unexpected counters %v", counters)
+	}
+
+	// Buckets that have refilled are pruned
+	now = now.Add(time.Hour)
+	server.handle(mustEncode(t, "device-1", now, nil))
+	if len(server.buckets) != 1 || server.buckets["device-1"].tokens != 1 {
+		t.Errorf("Expected a single fresh bucket, got %+v", server.buckets)
+	}
+}
+
+func TestServerReplay(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	secret := []byte("secret")
+	server := NewServer(store, Options{Secret: secret, MaxSkew: time.Minute})
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	packet := mustEncode(t, "device-1", now, secret)
+
+	// A signed datagram is accepted once, a later one from the same device still is
+	server.handle(packet)
+	server.handle(packet)
+	server.handle(mustEncode(t, "device-1", now.Add(time.Millisecond), secret))
+	if stats := server.Stats(); stats != (Stats{Accepted: 2, Replayed: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Once the datagram is stale, it is forgotten and rejected as stale
+	now = now.Add(2 * time.Minute)
+	server.handle(packet)
+	if len(server.seen) != 0 {
+		t.Errorf("Expected stale MACs to be pruned, got %d", len(server.seen))
+	}
+	if stats := server.Stats(); stats != (Stats{Accepted: 2, Replayed: 1, Stale: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestServerServe(t *testing.T) {
+	store := storage.NewMemoryStore()
+	if err := store.RegisterDevice(storage.DeviceInfo{DeviceID: "device-1", Source: storage.SourceAPI}); err != nil {
+		t.Fatalf("RegisterDevice failed: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := NewServer(store, Options{})
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	for _, packet := range [][]byte{
+		mustEncode(t, "device-1", time.Now(), nil),
+		make([]byte, maxPacketSize+100),
+	} {
+		if _, err := client.Write(packet); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.Stats() != (Stats{Accepted: 1, Malformed: 1}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected stats %+v", server.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server.Close()
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Expected Serve to return ErrServerClosed, got %v", err)
+	}
+}